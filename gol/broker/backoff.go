@@ -0,0 +1,26 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// maxBackoffDoublings caps the growth of the backoff, so later attempts wait at most
+// 2^maxBackoffDoublings times the base delay.
+const maxBackoffDoublings = 10
+
+// retryDelay is how long to wait before the given retry of a region on a turn, drawn with
+// full jitter from [0, base*2^attempt]. The draw depends only on the seed and its arguments,
+// not on the order the regions' goroutines happen to fail in, so a seed replays exactly.
+func retryDelay(base time.Duration, seed, turn int64, regionID, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	doublings := attempt
+	if doublings > maxBackoffDoublings {
+		doublings = maxBackoffDoublings
+	}
+	ceiling := int64(base) << uint(doublings)
+	rng := rand.New(rand.NewSource(seed ^ turn*1000003 ^ int64(regionID)*10007 ^ int64(attempt)*101))
+	return time.Duration(rng.Int63n(ceiling + 1))
+}
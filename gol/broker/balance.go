@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// weightedSpans splits height rows into strips with heights in proportion to weights, and
+// returns where each strip starts followed by height. Rows left over from rounding go to the
+// strips that lost the most to it, and when there are at least as many rows as strips, every
+// strip has at least one. Weights that sum to nothing split the rows evenly.
+func weightedSpans(height int, weights []float64) []int {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	heights := make([]int, len(weights))
+	remainders := make([]float64, len(weights))
+	assigned := 0
+	for i, weight := range weights {
+		share := float64(height) / float64(len(weights))
+		if total > 0 {
+			share = float64(height) * weight / total
+		}
+		heights[i] = int(math.Floor(share))
+		remainders[i] = share - float64(heights[i])
+		assigned += heights[i]
+	}
+	for ; assigned < height; assigned++ {
+		largest := 0
+		for i, remainder := range remainders {
+			if remainder > remainders[largest] {
+				largest = i
+			}
+		}
+		heights[largest]++
+		remainders[largest] = -1
+	}
+	if height >= len(weights) {
+		for i := range heights {
+			if heights[i] > 0 {
+				continue
+			}
+			tallest := 0
+			for j, h := range heights {
+				if h > heights[tallest] {
+					tallest = j
+				}
+			}
+			heights[tallest]--
+			heights[i]++
+		}
+	}
+
+	spans := make([]int, len(weights)+1)
+	for i, h := range heights {
+		spans[i+1] = spans[i] + h
+	}
+	return spans
+}
+
+// speeds are how fast each worker computed the rows of its region on the last turn, in rows
+// a second, by address.
+type speeds struct {
+	mu    sync.Mutex
+	rates map[string]float64
+}
+
+func newSpeeds() *speeds {
+	return &speeds{rates: make(map[string]float64)}
+}
+
+// observe records the worker at address computing rows rows in elapsed.
+func (s *speeds) observe(address string, rows int, elapsed time.Duration) {
+	if s == nil || elapsed <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.rates[address] = float64(rows) / elapsed.Seconds()
+	s.mu.Unlock()
+}
+
+// reweigh sizes the strips of d's next turn by the speeds its workers computed the last one
+// at, and keeps the weights for the runs that follow on the same workers. Until every worker
+// has been timed, the strips are left as they are.
+func (b *BrokerService) reweigh(d *dispatch) {
+	weights := make([]float64, len(d.addresses))
+	d.speeds.mu.Lock()
+	for i, address := range d.addresses {
+		weights[i] = d.speeds.rates[address]
+	}
+	d.speeds.mu.Unlock()
+	for _, weight := range weights {
+		if weight <= 0 {
+			return
+		}
+	}
+	d.weights = weights
+
+	b.weightsMu.Lock()
+	b.weights, b.weighted = weights, d.addresses
+	b.weightsMu.Unlock()
+}
+
+// measuredWeights returns the weights last measured on addresses, or nil if they have not
+// been.
+func (b *BrokerService) measuredWeights(addresses []string) []float64 {
+	b.weightsMu.Lock()
+	defer b.weightsMu.Unlock()
+	if !sameAddresses(b.weighted, addresses) {
+		return nil
+	}
+	return b.weights
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWeightedSpans checks strips cover the board exactly, in proportion to their weights,
+// with every strip at least a row high however small its weight.
+func TestWeightedSpans(t *testing.T) {
+	tests := []struct {
+		height   int
+		weights  []float64
+		expected []int
+	}{
+		{40, []float64{1, 2, 1, 4}, []int{5, 10, 5, 20}},
+		{10, []float64{1, 1, 1}, []int{4, 3, 3}},
+		{10, []float64{1, 1000}, []int{1, 9}},
+		{6, []float64{0.001, 0.001, 100, 0.001}, []int{1, 1, 3, 1}},
+		{7, []float64{0, 0}, []int{4, 3}},
+	}
+	for _, test := range tests {
+		spans := weightedSpans(test.height, test.weights)
+		if len(spans) != len(test.weights)+1 || spans[0] != 0 || spans[len(spans)-1] != test.height {
+			t.Errorf("%v rows by %v: expected spans from 0 to %v, got %v", test.height, test.weights, test.height, spans)
+			continue
+		}
+		for i, expected := range test.expected {
+			if height := spans[i+1] - spans[i]; height != expected {
+				t.Errorf("%v rows by %v: expected strip %v of %v rows, got %v", test.height, test.weights, i, expected, height)
+			}
+		}
+	}
+}
+
+// rowDelayWorker is a testWorker taking perRow for every row of a region, like a worker on a
+// slower or faster machine. It records the height of the last region it was sent.
+type rowDelayWorker struct {
+	testWorker
+	perRow time.Duration
+
+	mu         sync.Mutex
+	lastHeight int
+}
+
+func (w *rowDelayWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	w.mu.Lock()
+	w.lastHeight = req.Region.Height
+	w.mu.Unlock()
+	time.Sleep(time.Duration(req.Region.Height) * w.perRow)
+	return w.testWorker.Process(req, res)
+}
+
+// TestAdaptive runs a board on a fast and a slow worker, split evenly and then adaptively,
+// and checks the adaptive run gives the slow worker fewer rows and stalls less on it.
+func TestAdaptive(t *testing.T) {
+	const turns = 6
+	world := randomWorld(64, 16, 57)
+	elapsed := make(map[bool]time.Duration)
+	for _, adaptive := range []bool{false, true} {
+		fast := &rowDelayWorker{perRow: 100 * time.Microsecond}
+		slow := &rowDelayWorker{perRow: 2 * time.Millisecond}
+		b := newTestBroker(startWorker(t, fast), startWorker(t, slow))
+		b.Adaptive = adaptive
+
+		start := time.Now()
+		res := new(BrokerProcessResponse)
+		if err := b.Process(BrokerProcessRequest{Turns: turns, World: world}, res); err != nil {
+			t.Fatal(err)
+		}
+		elapsed[adaptive] = time.Since(start)
+		assertEqualWorld(t, res.World, evolve(world, turns))
+
+		if adaptive {
+			if slow.lastHeight >= fast.lastHeight || slow.lastHeight < 1 {
+				t.Errorf("expected the slow worker to be left with fewer rows, got %v to the fast worker's %v", slow.lastHeight, fast.lastHeight)
+			}
+			if weights := b.measuredWeights(b.workerAddresses()); len(weights) != 2 || weights[0] <= weights[1] {
+				t.Errorf("expected the fast worker weighted above the slow one, got %v", weights)
+			}
+		}
+	}
+	if elapsed[true] > elapsed[false]*3/4 {
+		t.Errorf("expected the adaptive run to stall less on the slow worker, took %v to the even split's %v", elapsed[true], elapsed[false])
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// finalBoard is the board a Banded run finished with, and the turns it completed.
+type finalBoard struct {
+	turns int64
+	world World
+}
+
+// ErrNoFinalBoard is returned by FetchBand when there is no Banded run's board to fetch.
+var ErrNoFinalBoard = errors.New("no final board to fetch")
+
+// FetchBand returns rows [req.StartRow, req.EndRow) of the board the last Banded run finished
+// with, so a board too large for one response can be fetched a band at a time. The board is
+// let go once its last row is fetched, so bands are fetched top to bottom.
+func (b *BrokerService) FetchBand(req BrokerFetchBandRequest, res *BrokerFetchBandResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	final := s.final
+	s.mu.Unlock()
+	world := final.world
+	if world.Height == 0 {
+		return ErrNoFinalBoard
+	}
+	if req.StartRow < 0 || req.EndRow > world.Height || req.StartRow >= req.EndRow {
+		return fmt.Errorf("rows [%v, %v) are not a band of a board %v rows high", req.StartRow, req.EndRow, world.Height)
+	}
+
+	res.Turns = final.turns
+	res.Height = world.Height
+	res.Width = world.Width
+	res.Rows = world.Field.Data[req.StartRow:req.EndRow]
+	if req.EndRow == world.Height {
+		s.mu.Lock()
+		s.final = finalBoard{}
+		s.mu.Unlock()
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+// TestFetchBand runs a board Banded and checks Process answers without it, while FetchBand
+// hands it over in bands, the last one short, and refuses bands off the board.
+func TestFetchBand(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	world := randomWorld(23, 16, 64)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 4, World: world, Banded: true}, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Turns != 4 || res.World.Height != 0 {
+		t.Fatalf("expected 4 turns and no board, got %v turns and %v rows", res.Turns, res.World.Height)
+	}
+
+	for _, band := range [][2]int{{-1, 5}, {20, 24}, {5, 5}} {
+		if err := b.FetchBand(BrokerFetchBandRequest{StartRow: band[0], EndRow: band[1]}, new(BrokerFetchBandResponse)); err == nil {
+			t.Errorf("expected rows [%v, %v) to be refused", band[0], band[1])
+		}
+	}
+
+	fetched := World{Height: 23, Width: 16}
+	for start := 0; start < 23; start += 5 {
+		end := start + 5
+		if end > 23 {
+			end = 23
+		}
+		band := new(BrokerFetchBandResponse)
+		if err := b.FetchBand(BrokerFetchBandRequest{StartRow: start, EndRow: end}, band); err != nil {
+			t.Fatal(err)
+		}
+		if band.Turns != 4 || band.Height != 23 || band.Width != 16 || len(band.Rows) != end-start {
+			t.Fatalf("rows [%v, %v): got %v rows of a %vx%v board at turn %v", start, end, len(band.Rows), band.Width, band.Height, band.Turns)
+		}
+		fetched.Field.Data = append(fetched.Field.Data, band.Rows...)
+	}
+	fetched.Field.Height, fetched.Field.Width = 23, 16
+	assertEqualWorld(t, fetched, evolve(world, 4))
+
+	if err := b.FetchBand(BrokerFetchBandRequest{StartRow: 0, EndRow: 5}, new(BrokerFetchBandResponse)); err != ErrNoFinalBoard {
+		t.Errorf("expected ErrNoFinalBoard once the last row was fetched, got %v", err)
+	}
+}
+
+// TestBandedQuit checks a session quit with a Banded run's board still to fetch is kept until
+// it has been, and ended by the Quit after.
+func TestBandedQuit(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	started := new(BrokerStartResponse)
+	if err := b.Start(BrokerStartRequest{}, started); err != nil {
+		t.Fatal(err)
+	}
+	id := started.SessionID
+	req := BrokerProcessRequest{SessionID: id, Turns: 2, World: randomWorld(8, 8, 65), Token: "a", Banded: true}
+	if err := b.Process(req, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	quit := BrokerQuitRequest{SessionID: id, Token: "a"}
+	if err := b.Quit(quit, new(BrokerQuitResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.FetchBand(BrokerFetchBandRequest{SessionID: id, StartRow: 0, EndRow: 8}, new(BrokerFetchBandResponse)); err != nil {
+		t.Fatalf("expected the board kept past Quit, got %v", err)
+	}
+	if err := b.Quit(quit, new(BrokerQuitResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.sessions) != 0 {
+		t.Errorf("expected the session ended once its board was fetched, got %v sessions", len(b.sessions))
+	}
+}
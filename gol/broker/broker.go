@@ -1,11 +1,19 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"math"
 	"net"
+	"net/http"
 	"net/rpc"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"uk.ac.bris.cs/gameoflife/util"
@@ -16,6 +24,16 @@ const (
 	InitialDelay      = 2 * time.Second
 )
 
+// Boundary is what lies beyond the edges of the board.
+type Boundary string
+
+const (
+	// Toroidal wraps each edge of the board round to the opposite one. It is the default.
+	Toroidal Boundary = "toroidal"
+	// Fixed surrounds the board with cells that are always dead.
+	Fixed Boundary = "fixed"
+)
+
 type (
 	Cell struct {
 		X     int
@@ -35,78 +53,448 @@ type (
 		End    int
 		Height int
 		Width  int
+
+		// StartX and EndX are the columns of a tile from a grid decomposition, whose rows
+		// then carry a halo cell at either end. Both are 0 for a strip of whole rows,
+		// which wraps around horizontally instead.
+		StartX int
+		EndX   int
+
+		// Boundary is Fixed for a strip of a board without wrap around, whose rows are
+		// then dead beyond either end.
+		Boundary Boundary
+
+		// Rule is the rule the region is computed with.
+		Rule Rule
+
+		// Packed holds the rows instead of Field when they are sent packed.
+		Packed PackedWorld
+
+		// Payload holds the rows, packed or not, gzipped when Compressed, see compressed.
+		Compressed bool
+		Payload    []byte
 	}
 
 	World struct {
 		Field  Field
 		Height int
 		Width  int
+
+		// boundary is the run's, which the halos of its regions are taken across, and rule
+		// the one its regions are computed with.
+		boundary Boundary
+		rule     Rule
+	}
+
+	// Rule is a Life-like rule, the neighbour counts on which a dead cell is born and a live
+	// one survives. The zero Rule is Conway's B3/S23.
+	Rule struct {
+		Birth    []int
+		Survival []int
 	}
 )
 
 type (
+	BrokerStartRequest struct{}
+
+	BrokerStartResponse struct {
+		// SessionID names the new session in every later RPC about it.
+		SessionID string
+	}
+
 	BrokerProcessRequest struct {
-		Turns int
+		Turns int64
 		World World
+		// Packed is the board to start from instead of World, when it is sent packed.
+		Packed PackedWorld
+		// Token is required by the control RPCs for the rest of the run, so only the
+		// client that started it can pause, quit or shut it down.
+		Token string
+		// Boundary is what lies beyond the edges of the board, Toroidal when empty.
+		Boundary Boundary
+		// Rule is the rule the board evolves by, Conway's when zero.
+		Rule Rule
+		// SessionID is the session from Start, or empty for the broker's own, which clients
+		// that never call Start share.
+		SessionID string
+		// Resume reattaches to the session's run started with Token instead of starting
+		// another, for a client that lost its connection while waiting for the run.
+		Resume bool
+		// Stream keeps the flips of every turn for NextTurns, and holds the run back while
+		// StreamBacklog turns are waiting to be taken.
+		Stream bool
+		// Banded keeps the final board for FetchBand instead of answering with it, for boards
+		// too large to send in one response.
+		Banded bool
 	}
 
 	BrokerProcessResponse struct {
 		World World
-		Turns int
+		// Packed is the final board instead of World when the request was packed.
+		Packed PackedWorld
+		// Turns is how many turns this call completed, fewer than requested if it was stopped early.
+		Turns int64
+		// ComputeTime is how long this call's turns spent being computed.
+		ComputeTime time.Duration
+		// Bytes is the traffic to and from the workers computing the turns.
+		Bytes int64
+		// WorkerRegions is how many regions each worker computed, by address.
+		WorkerRegions map[string]int64
+		// Failovers is how many times a region was moved on from a failed worker.
+		Failovers int64
 	}
 
-	BrokerReportRequest struct{}
+	BrokerReportRequest struct {
+		SessionID string
+	}
 
 	BrokerReportResponse struct {
-		Turns      int
+		Turns      int64
 		CellsCount int
 	}
 
-	BrokerSaveRequest struct{}
+	BrokerFlipsRequest struct {
+		SessionID string
+	}
+
+	BrokerFlipsResponse struct {
+		// Turns is the turn the flips bring the board up to, however many turns they span.
+		Turns      int64
+		CellsCount int
+		// Cells are the cells whose state has changed since the last call.
+		Cells []util.Cell
+	}
+
+	BrokerRegionCountsRequest struct {
+		SessionID string
+	}
+
+	BrokerRegionCountsResponse struct {
+		Turns int64
+		// Counts are the alive cells in each region of the last completed turn, top to bottom.
+		Counts []int
+	}
+
+	BrokerSaveRequest struct {
+		SessionID string
+	}
 
 	BrokerSaveResponse struct {
-		Turns int
+		Turns int64
 		World World
 	}
 
-	BrokerQuitRequest struct{}
+	BrokerQuitRequest struct {
+		SessionID string
+		Token     string
+	}
 
 	BrokerQuitResponse struct {
-		Turns int
+		Turns int64
 	}
 
-	BrokerShutdownRequest struct{}
+	BrokerShutdownRequest struct {
+		SessionID string
+		Token     string
+	}
 
 	BrokerShutdownResponse struct {
-		Turns int
+		Turns int64
+		// World is the last completed turn, taken once the run has stopped at a turn boundary.
+		World World
 	}
 
-	BrokerPauseRequest struct{}
+	BrokerPauseRequest struct {
+		SessionID string
+		Token     string
+	}
 
 	BrokerPauseResponse struct {
-		Turns    int
+		Turns    int64
 		IsPaused bool
 	}
 
-	BrokerService struct {
-		Turns      int
+	BrokerStepRequest struct {
+		SessionID string
+		Token     string
+	}
+
+	BrokerStepResponse struct {
+		// Stepped is false when the run was not paused, in which case nothing was computed
+		// and the rest is left empty but for Turns.
+		Stepped    bool
+		Turns      int64
+		CellsCount int
+		World      World
+		// Cells are the cells flipped since the last Flips call, which the step's are among.
+		Cells []util.Cell
+	}
+
+	BrokerGetWorldRequest struct {
+		SessionID string
+	}
+
+	BrokerGetWorldResponse struct {
+		Turns int64
+		World World
+	}
+
+	BrokerFetchBandRequest struct {
+		SessionID string
+		// StartRow and EndRow are the rows [StartRow, EndRow) of the final board to fetch.
+		StartRow int
+		EndRow   int
+	}
+
+	BrokerFetchBandResponse struct {
+		Turns  int64
+		Height int
+		Width  int
+		Rows   [][]Cell
+	}
+
+	BrokerGetThumbnailRequest struct {
+		SessionID string
+		// MaxDim bounds the longer side of the thumbnail, which is never larger than the board.
+		MaxDim int
+	}
+
+	BrokerGetThumbnailResponse struct {
+		Turns int64
+		// Image is the board scaled down, each pixel shaded by the share of its cells alive.
+		Image [][]uint8
+	}
+
+	BrokerWaitStateChangeRequest struct {
+		SessionID string
+		// Since is the Seq of the state the caller last saw.
+		Since uint64
+		// Timeout bounds how long to wait for a change, up to MaxStateChangeWait.
+		Timeout time.Duration
+	}
+
+	BrokerWaitStateChangeResponse struct {
+		Seq        uint64
+		Turns      int64
+		CellsCount int
+		IsPaused   bool
+		Running    bool
+	}
+
+	BrokerNextTurnsRequest struct {
+		SessionID string
+		// Timeout bounds how long to wait for a turn, up to MaxStateChangeWait.
+		Timeout time.Duration
+	}
+
+	BrokerNextTurnsResponse struct {
+		// Turns are the turns completed since the last call, oldest first.
+		Turns []TurnFlips
+		// Finished is set once the run has finished and every turn of it has been taken.
+		Finished bool
+	}
+
+	// TurnFlips are the cells a single turn flipped.
+	TurnFlips struct {
+		Turns      int64
+		CellsCount int
+		Cells      []util.Cell
+	}
+
+	BrokerRegisterRequest struct {
+		// Address is the host:port the worker is listening on.
+		Address string
+	}
+
+	BrokerRegisterResponse struct {
+		// Workers is how many workers the broker knows of, including this one.
+		Workers int
+	}
+
+	BrokerPauseAndSnapshotRequest struct {
+		SessionID string
+	}
+
+	BrokerPauseAndSnapshotResponse struct {
+		Turns      int64
 		CellsCount int
 		World      World
-		quit       chan bool
-		shutdown   chan bool
-		pause      chan bool
-		isPaused   bool
-		addresses  []string
+	}
+
+	BrokerService struct {
+		// session is the simulation of clients that never call Start, and sessions are
+		// those that have, by SessionID.
+		session
+		sessionsMu sync.Mutex
+		sessions   map[string]*session
+
+		// shutdown is closed, once, when the broker is to exit, by Shutdown or the idle timeout.
+		shutdown     chan bool
+		shutdownOnce sync.Once
+
+		// addresses are the workers, which Register adds to while runs read them. added is
+		// closed when the next worker registers, for runs left without any.
+		addressesMu sync.RWMutex
+		addresses   []string
+		added       chan struct{}
+
+		// RetryBudget is how many failed worker calls may be retried on another worker
+		// in a single turn before the run is aborted with ErrRetriesExhausted.
+		RetryBudget int
+
+		// RetryBackoff is the base delay before retrying a failed worker call, doubled on each
+		// further attempt and jittered from Seed, or 0 to retry at once. sleep waits out the
+		// delay, time.Sleep when nil.
+		RetryBackoff time.Duration
+		Seed         int64
+		sleep        func(time.Duration)
+
+		// Dialer connects to the workers, over plain TCP when nil.
+		Dialer Dialer
+
+		// HaloOnly sends a worker only the halo rows of a region it computed on the previous
+		// turn, letting it reuse the interior rows it returned then.
+		HaloOnly bool
+
+		// Resident keeps each worker's strip on it between turns, the workers swapping halo
+		// rows directly, so the board only comes back to the broker when it is wanted. It
+		// only applies to strips.
+		Resident bool
+
+		// PackRegions sends the workers their regions' rows packed, as they send them back.
+		PackRegions bool
+
+		// CompressRegions gzips the regions sent to the workers, and the workers' replies.
+		// On a slow link it cuts the turn time of rows sent as Cells, but packed rows are
+		// already about as small as gzip makes them.
+		CompressRegions bool
+
+		// Decomposition is how the board is divided among the workers, Strips when empty.
+		Decomposition Decomposition
+
+		// Adaptive sizes each worker's strip in proportion to how fast it computed its last
+		// one, so every worker takes about as long. weights are the last sizes measured, for
+		// the workers weighted, guarded by weightsMu.
+		Adaptive  bool
+		weightsMu sync.Mutex
+		weights   []float64
+		weighted  []string
+
+		// SlowCall is how long a worker call may take before a warning is logged, or 0 to
+		// never warn.
+		SlowCall time.Duration
+
+		// WorkerCallTimeout is how long a worker call may take before it fails with
+		// ErrWorkerTimeout, or 0 to wait as long as it takes.
+		WorkerCallTimeout time.Duration
+
+		// Coordinator makes Process run as CoordinatorProcess, with brokers as its workers.
+		Coordinator bool
+
+		// bands are the rows kept for the bands coordinators hand this broker, guarded by
+		// bandsMu.
+		bandsMu sync.Mutex
+		bands   map[runRegion]keptBand
+
+		// metrics is exported over http when set.
+		metrics *metrics
+
+		// Debug checks after every turn that CellsCount matches the alive cells on the board.
+		Debug bool
+
+		// DumpTurn writes every region sent to compute this turn to the out directory, or
+		// nothing when 0.
+		DumpTurn int64
+
+		// Continue makes each Process carry on from the board and turn count the last one
+		// left, ignoring the world it is sent, until Quit clears them. Otherwise every Process
+		// starts afresh from its own world at turn 0.
+		Continue bool
+
+		// VerifyAssembly recomputes every turn serially over the whole board and fails the run
+		// if the board assembled from the workers differs, such as from rows put in the wrong
+		// place. It is slow, and meant for tests and debugging.
+		VerifyAssembly bool
+
+		// Limits bound each session, and scheduler shares the workers between the turns of
+		// concurrent ones.
+		Limits    Limits
+		scheduler turnQueue
+
+		// IdleTimeout shuts the broker and its workers down once no run is in progress and no
+		// RPC has arrived for this long, or never when 0. lastActive is guarded by mu.
+		IdleTimeout time.Duration
+		lastActive  time.Time
+
+		// Warmup is how many turns each run computes and throws away before its first turn,
+		// so they are left out of its timing, turn count and metrics.
+		Warmup int64
+
+		// MaxTPS is the most turns a run computes per second, or no limit when 0.
+		MaxTPS int
+
+		// HealthInterval is how often every worker is pinged, or never when 0. A worker
+		// failing MaxFailures pings in a row is removed, and the board split among the rest.
+		HealthInterval time.Duration
+		MaxFailures    int
+
+		// CheckpointInterval writes the board to CheckpointPath every this many completed
+		// turns, for a broker restarted with -resume to carry on from, or never when 0.
+		CheckpointInterval int64
+		CheckpointPath     string
 	}
 )
 
 type (
 	WorkerProcessResponse struct {
-		Region Region
+		Region     Region
+		AliveCount int
 	}
 
 	WorkerProcessRequest struct {
+		Region   Region
+		Turn     int64
+		HaloOnly bool
+		Run      int64
+		// Resident, Up and Down keep the region on the worker between turns, see residentRun.
+		// With HaloOnly too a resident region's halos come with it, and EdgesOnly, which
+		// needs Resident, answers with just its new first and last rows.
+		Resident  bool
+		Up        string
+		Down      string
+		UpStart   int
+		DownStart int
+		EdgesOnly bool
+	}
+
+	WorkerFetchStripRequest struct {
+		Run   int64
+		Start int
+		// Pack asks for the strip's rows packed.
+		Pack bool
+	}
+
+	WorkerFetchStripResponse struct {
 		Region Region
+		Turn   int64
+	}
+
+	WorkerEndRunRequest struct {
+		Run int64
+	}
+
+	WorkerEndRunResponse struct{}
+
+	WorkerStatusRequest struct{}
+
+	WorkerStatusResponse struct {
+		Ready           bool
+		Version         string
+		CoresAvailable  int
+		MaxRegionHeight int
+		CPUs            int
+		Memory          uint64
+		Score           float64
 	}
 
 	WorkerShutdownResponse struct{}
@@ -114,37 +502,326 @@ type (
 	WorkerShutdownRequest struct{}
 )
 
+// Dialer opens the connections the broker makes to its workers, so they can be reached
+// through a proxy or service mesh sidecar rather than directly over TCP.
+type Dialer interface {
+	Dial(address string) (net.Conn, error)
+}
+
+// TCPDialer is the default Dialer, which connects straight to the worker's address.
+type TCPDialer struct{}
+
+func (TCPDialer) Dial(address string) (net.Conn, error) {
+	return net.Dial("tcp", address)
+}
+
+// TLSDialer connects to the worker's address over TLS, verifying it with Config.
+type TLSDialer struct {
+	Config *tls.Config
+}
+
+func (d TLSDialer) Dial(address string) (net.Conn, error) {
+	return util.Dial(address, d.Config)
+}
+
+// dial connects an rpc client to address through dialer, or over TCP when dialer is nil.
+func dial(dialer Dialer, address string) (*rpc.Client, error) {
+	if dialer == nil {
+		dialer = TCPDialer{}
+	}
+	conn, err := dialer.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// call makes an RPC on client, giving up with ErrWorkerTimeout if no reply has arrived within
+// timeout, or waiting as long as it takes when timeout is 0. A reply arriving late lands in
+// reply all the same, so a caller that gave up must not reuse it.
+func call(client *rpc.Client, timeout time.Duration, method string, args interface{}, reply interface{}) error {
+	if timeout <= 0 {
+		return client.Call(method, args, reply)
+	}
+	select {
+	case done := <-client.Go(method, args, reply, make(chan *rpc.Call, 1)).Done:
+		return done.Error
+	case <-time.After(timeout):
+		return ErrWorkerTimeout
+	}
+}
+
 var WorkerProcess = "WorkerService.Process"
 
+var BrokerProcessRegion = "BrokerService.ProcessRegion"
+
 var WorkerShutdown = "WorkerService.Shutdown"
 
-func (region *Region) update(ipAddress string, regionCh chan<- [][]Cell) {
-	client, err := rpc.Dial("tcp", ipAddress)
+var WorkerStatus = "WorkerService.Status"
+
+var WorkerEndRun = "WorkerService.EndRun"
+
+// ErrTurnsOutOfRange is returned by Process for a negative turn count, or one that would take
+// the broker's turn counter past the largest int64.
+var ErrTurnsOutOfRange = errors.New("turn count out of range")
+
+// ErrNotController is returned by the control RPCs when called without the running
+// simulation's token, such as by an observer.
+var ErrNotController = errors.New("only the client running the simulation can control it")
+
+// MaxStateChangeWait is the longest WaitStateChange blocks without a change.
+const MaxStateChangeWait = 30 * time.Second
+
+// ErrRetriesExhausted is returned by Process when a turn needed more worker retries than
+// the broker's RetryBudget allows.
+var ErrRetriesExhausted = errors.New("worker retries exhausted")
+
+// ErrNoWorkers is returned by Process when no worker was given on the command line or has
+// registered since, or all of them have been removed for failing their health checks. A run
+// left without workers part way through waits for one to register instead.
+var ErrNoWorkers = errors.New("no live workers to compute the board")
+
+// ErrWorkerTimeout is returned for a worker call given no reply within WorkerCallTimeout. A
+// region whose worker timed out fails over to another worker like any other failed call.
+var ErrWorkerTimeout = errors.New("worker call timed out")
+
+// regionCache remembers which worker computed each region on the previous turn of a run, so
+// that worker can be sent just the region's halo rows and reuse the interior it returned.
+type regionCache struct {
+	// run tells this run's cached rows apart from any other run's on the same workers.
+	run int64
+
+	mu      sync.Mutex
+	holders map[int]cachedRegion
+}
+
+type cachedRegion struct {
+	address string
+	end     int
+	turn    int64
+}
+
+func newRegionCache() *regionCache {
+	return &regionCache{
+		run:     time.Now().UnixNano(),
+		holders: make(map[int]cachedRegion),
+	}
+}
+
+// id is the run to tag worker requests with, zero when halo-only transfer is off.
+func (cache *regionCache) id() int64 {
+	if cache == nil {
+		return 0
+	}
+	return cache.run
+}
+
+// holds reports whether the worker at address computed region on the turn before turn.
+func (cache *regionCache) holds(region Region, address string, turn int64) bool {
+	if cache == nil {
+		return false
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	holder, ok := cache.holders[region.Start]
+	return ok && holder.address == address && holder.end == region.End && holder.turn == turn-1
+}
+
+// store records that the worker at address computed region on turn.
+func (cache *regionCache) store(region Region, address string, turn int64) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.holders[region.Start] = cachedRegion{address: address, end: region.End, turn: turn}
+}
+
+// endRun tells the workers run is over, so they drop the rows and strips they keep for it and
+// runs sharing them keep theirs. It does not wait for them, and a worker it cannot reach is
+// left to drop them when it is restarted.
+func (d dispatch) endRun(run int64) {
+	method := WorkerEndRun
+	if d.method == BrokerProcessRegion {
+		method = BrokerEndRun
+	} else if d.method != WorkerProcess {
+		return
+	}
+	if run == 0 {
+		return
+	}
+	for _, address := range d.addresses {
+		go func(address string) {
+			client, err := dial(d.dialer, address)
+			if err != nil {
+				return
+			}
+			defer client.Close()
+			call(client, d.callTimeout, method, WorkerEndRunRequest{Run: run}, new(WorkerEndRunResponse))
+		}(address)
+	}
+}
+
+// dump writes the region, halos included, to a pgm file in the out directory named after the
+// worker it is sent to and the turn it computes, for diffing against a reference run.
+func (region *Region) dump(ipAddress string, turn int64) error {
+	_ = os.Mkdir("out", os.ModePerm)
+	worker := strings.NewReplacer(":", "-", "/", "-").Replace(ipAddress)
+	file, err := os.Create(fmt.Sprintf("out/broker-turn-%v-worker-%v-region-%v.pgm", turn, worker, region.Start))
 	if err != nil {
-		log.Fatal("dialing:", err)
+		return err
 	}
-	defer client.Close()
+	defer file.Close()
+
+	pixels := make([][]uint8, len(region.Field))
+	for y, row := range region.Field {
+		pixels[y] = make([]uint8, len(row))
+		for x, cell := range row {
+			if cell.Alive {
+				pixels[y][x] = 255
+			}
+		}
+	}
+	return util.WritePgm(file, pixels)
+}
+
+// halos returns the region with only its two halo rows, for a worker that holds the interior.
+func (region *Region) halos() Region {
+	halos := *region
+	halos.Field = [][]Cell{region.Field[0], region.Field[len(region.Field)-1]}
+	return halos
+}
 
-	request := WorkerProcessRequest{Region: *region}
+// process makes a WorkerProcess call, logging a warning when it is slower than d.slowCall.
+func (region *Region) process(d dispatch, client *rpc.Client, ipAddress string, request WorkerProcessRequest) (Region, int, error) {
 	response := new(WorkerProcessResponse)
+	start := time.Now()
+	method := d.method
+	if method == "" {
+		method = WorkerProcess
+	}
+	if d.pack {
+		request.Region = request.Region.packed()
+	}
+	if d.compress {
+		var err error
+		if request.Region, err = request.Region.compressed(); err != nil {
+			return Region{}, 0, err
+		}
+	}
+	err := call(client, d.callTimeout, method, request, response)
+	if elapsed := time.Since(start); d.slowCall > 0 && elapsed > d.slowCall {
+		log.Printf("WARN slow call: worker %v took %v for region [%v, %v) of %vx%v on turn %v",
+			ipAddress, elapsed, region.Start, region.End, region.Width, region.Height, request.Turn)
+	}
+	if err != nil {
+		return Region{}, 0, err
+	}
+
+	result, err := response.Region.decompressed()
+	if err != nil {
+		return Region{}, 0, fmt.Errorf("worker %v returned region [%v, %v) badly compressed: %v", ipAddress, region.Start, region.End, err)
+	}
+	if result, err = result.unpacked(); err != nil {
+		return Region{}, 0, fmt.Errorf("worker %v returned region [%v, %v) badly packed: %v", ipAddress, region.Start, region.End, err)
+	}
+	// A short or long region would silently misplace rows when the board is reassembled.
+	if len(result.Field) == region.Height+2*DefaultHaloOffset && region.Height > 0 {
+		return Region{}, 0, fmt.Errorf("worker %v returned region [%v, %v) with its halo rows, %v rows instead of %v",
+			ipAddress, region.Start, region.End, len(result.Field), region.Height)
+	}
+	if len(result.Field) != result.Height || result.Height != region.Height {
+		return Region{}, 0, fmt.Errorf("worker %v returned %v rows with a height of %v for region [%v, %v)",
+			ipAddress, len(result.Field), result.Height, region.Start, region.End)
+	}
+	return result, response.AliveCount, nil
+}
+
+func (region *Region) update(d dispatch, ipAddress string, turn int64) (Region, int, error) {
+	client, err := dial(d.dialer, ipAddress)
+	if err != nil {
+		return Region{}, 0, err
+	}
+	defer client.Close()
+
+	cache := d.cache
+	if cache.holds(*region, ipAddress, turn) {
+		request := WorkerProcessRequest{Region: region.halos(), Turn: turn, HaloOnly: true, Run: cache.id()}
+		result, alive, err := region.process(d, client, ipAddress, request)
+		if err == nil {
+			cache.store(*region, ipAddress, turn)
+			return result, alive, nil
+		}
+		log.Printf("worker %v could not reuse region [%v, %v) on turn %v, sending it whole: %v", ipAddress, region.Start, region.End, turn, err)
+	}
 
-	client.Call(WorkerProcess, request, response)
+	request := WorkerProcessRequest{Region: *region, Turn: turn, Run: cache.id()}
+	result, alive, err := region.process(d, client, ipAddress, request)
+	if err == nil {
+		cache.store(*region, ipAddress, turn)
+	}
 
-	regionCh <- response.Region.Field
+	return result, alive, err
 }
 
 func (world *World) region(w int, numWorkers int) Region {
+	start, end := span(w, numWorkers, world.Height)
+	return world.strip(start, end)
+}
+
+// weightedRegion is region w of a board split into strips with heights in proportion to
+// weights, see weightedSpans.
+func (world *World) weightedRegion(w int, weights []float64) Region {
+	spans := weightedSpans(world.Height, weights)
+	return world.strip(spans[w], spans[w+1])
+}
+
+// check returns an error if the rule has a neighbour count no cell can have.
+func (rule Rule) check() error {
+	for _, counts := range [][]int{rule.Birth, rule.Survival} {
+		for _, count := range counts {
+			if count < 0 || count > 8 {
+				return fmt.Errorf("invalid neighbour count %v in rule, counts run from 0 to 8", count)
+			}
+		}
+	}
+	return nil
+}
+
+// table returns whether a cell is alive next turn for each neighbour count, indexed first by
+// whether it is alive now.
+func (rule Rule) table() (next [2][9]bool) {
+	if rule.Birth == nil && rule.Survival == nil {
+		rule = Rule{Birth: []int{3}, Survival: []int{2, 3}}
+	}
+	for _, count := range rule.Birth {
+		next[0][count] = true
+	}
+	for _, count := range rule.Survival {
+		next[1][count] = true
+	}
+	return
+}
+
+// strip returns rows [start, end) of the world as a region, with the rows either side as halos,
+// wrapping around unless the board's boundary is Fixed, when the halos past its top and bottom
+// are dead. The range is clamped to the board, and is empty rather than negative if end is
+// before start.
+func (world *World) strip(start, end int) Region {
+	if start < 0 {
+		start = 0
+	}
+	if end > world.Height {
+		end = world.Height
+	}
+	if end < start {
+		end = start
+	}
 	field := Field{
 		Height: 0,
 		Width:  0,
 	}
-	regionHeight := world.Height / numWorkers
-	start := w * regionHeight
-	end := (w + 1) * regionHeight
-	if w == numWorkers-1 {
-		end = world.Height
-	}
-	regionHeight = end - start
+	regionHeight := end - start
 
 	downRowPtr := end % world.Height
 	upRowPtr := (start - 1 + world.Height) % world.Height
@@ -155,169 +832,1392 @@ func (world *World) region(w int, numWorkers int) Region {
 		field.Data[row] = world.Field.Data[start+row-1]
 	}
 	field.Data[regionHeight+1] = world.Field.Data[downRowPtr]
+	if world.boundary == Fixed {
+		if start == 0 {
+			field.Data[0] = make([]Cell, world.Width)
+		}
+		if end == world.Height {
+			field.Data[regionHeight+1] = make([]Cell, world.Width)
+		}
+	}
 
 	return Region{
-		Field:  field.Data,
-		Start:  start,
-		End:    end,
-		Height: regionHeight,
-		Width:  world.Width,
+		Field:    field.Data,
+		Start:    start,
+		End:      end,
+		Height:   regionHeight,
+		Width:    world.Width,
+		Boundary: world.boundary,
+		Rule:     world.rule,
 	}
 }
 
-func (world *World) update(workerAddrs []string) {
-	var newFieldData [][]Cell
+// span returns the part'th of parts ranges of length, the last taking any remainder, as
+// world.region divides rows.
+func span(part, parts, length int) (start, end int) {
+	size := length / parts
+	start = part * size
+	end = (part + 1) * size
+	if part == parts-1 {
+		end = length
+	}
+	return
+}
 
-	numWorkers := len(workerAddrs)
+// Decomposition is how the board is divided into regions for the workers.
+type Decomposition string
 
-	regionChannel := make([]chan [][]Cell, numWorkers)
+const (
+	// Strips splits the board into strips of whole rows. It is the default.
+	Strips Decomposition = "strips"
+	// Grid tiles the board into a grid of rectangles, as square as the worker count allows,
+	// which exchange fewer halo cells than strips on wide boards.
+	Grid Decomposition = "grid"
+)
+
+// gridShape returns the rows and columns of tiles to divide a height x width board into parts
+// tiles, choosing the shape that exchanges the fewest halo cells. It reports false when parts
+// cannot be arranged in a grid with at least one cell in every tile.
+func gridShape(parts, height, width int) (rows, cols int, ok bool) {
+	best := 0
+	for r := 1; r <= parts && r <= height; r++ {
+		c := parts / r
+		if r*c != parts || c > width {
+			continue
+		}
+		// Each row of tiles exchanges a whole row of the board, each column a whole column.
+		if halo := r*width + c*height; !ok || halo < best {
+			rows, cols, best, ok = r, c, halo, true
+		}
+	}
+	return
+}
 
-	var wg sync.WaitGroup
-	wg.Add(numWorkers)
+// regions2D tiles the world into a rows x cols grid of regions, listed row by row. Each tile
+// carries a halo cell all the way round, including the four corner cells from its diagonal
+// neighbours, wrapping around the torus at the edges of the board, or dead there if the
+// boundary is Fixed.
+func (world *World) regions2D(rows, cols int) []Region {
+	var regions []Region
+	for row := 0; row < rows; row++ {
+		start, end := span(row, rows, world.Height)
+		for col := 0; col < cols; col++ {
+			startX, endX := span(col, cols, world.Width)
+
+			field := make([][]Cell, end-start+2*DefaultHaloOffset)
+			for fy := range field {
+				wy := (start - DefaultHaloOffset + fy + world.Height) % world.Height
+				field[fy] = make([]Cell, endX-startX+2*DefaultHaloOffset)
+				for fx := range field[fy] {
+					y := start - DefaultHaloOffset + fy
+					x := startX - DefaultHaloOffset + fx
+					if world.boundary == Fixed && (y < 0 || y >= world.Height || x < 0 || x >= world.Width) {
+						continue
+					}
+					wx := (x + world.Width) % world.Width
+					field[fy][fx] = world.Field.Data[wy][wx]
+				}
+			}
 
-	for workerID := 0; workerID < numWorkers; workerID++ {
-		regionChannel[workerID] = make(chan [][]Cell)
-		region := world.region(workerID, numWorkers)
-		go func(workerID int) {
-			defer func() {
-				close(regionChannel[workerID])
-				wg.Done()
-			}()
-			region.update(workerAddrs[workerID], regionChannel[workerID])
-		}(workerID)
+			regions = append(regions, Region{
+				Field:  field,
+				Start:  start,
+				End:    end,
+				Height: end - start,
+				Width:  endX - startX,
+				StartX: startX,
+				EndX:   endX,
+				Rule:   world.rule,
+			})
+		}
 	}
+	return regions
+}
 
-	for w := 0; w < numWorkers; w++ {
-		region := <-regionChannel[w]
-		newFieldData = append(newFieldData, region...)
+// split returns the part'th of parts regions of region's interior rows. Its halo rows come
+// from region's own rows, halos included, so nothing wraps around, and its Start and End
+// are relative to region's first interior row.
+func (region *Region) split(part, parts int) Region {
+	height := region.Height / parts
+	start := part * height
+	end := (part + 1) * height
+	if part == parts-1 {
+		end = region.Height
 	}
 
-	world.Field.Data = newFieldData
+	return Region{
+		Field:    region.Field[start : end+2*DefaultHaloOffset],
+		Start:    start,
+		End:      end,
+		Height:   end - start,
+		Width:    region.Width,
+		Boundary: region.Boundary,
+		Rule:     region.Rule,
+	}
 }
 
-func aliveCellsInRow(row []Cell, y int) []util.Cell {
-	var alive []util.Cell
-	for x, cell := range row {
-		if cell.Alive {
-			alive = append(alive, util.Cell{X: x, Y: y})
+// uniqueAddresses returns addresses without repeats, keeping the first of each, and warns
+// about any repeat, since it would give one worker several regions in every turn.
+func uniqueAddresses(addresses []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, address := range addresses {
+		if seen[address] {
+			log.Printf("WARN worker %v is listed more than once, ignoring the repeat", address)
+			continue
 		}
+		seen[address] = true
+		unique = append(unique, address)
 	}
-	return alive
+	return unique
 }
 
-func (world *World) alive() []util.Cell {
-	var alive []util.Cell
-	for y, row := range world.Field.Data {
-		alive = append(alive, aliveCellsInRow(row, y)...)
+// regionCount returns how many regions to split height rows into so that every worker has
+// at least one and none holds more than maxHeight rows, where a maxHeight of 0 is no limit.
+func regionCount(height, numWorkers, maxHeight int) int {
+	count := numWorkers
+	if maxHeight <= 0 {
+		return count
 	}
-	return alive
+	// The last region takes the rows left over by the others, so it is the tallest.
+	for count < height && height-(count-1)*(height/count) > maxHeight {
+		count++
+	}
+	return count
 }
 
-func (b *BrokerService) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
-	res.Turns = b.Turns
-	res.CellsCount = b.CellsCount
-	return
+// workerStatuses asks every worker for its Status, in the order of its addresses. A worker
+// that cannot answer gets a zero Status, which advertises neither a limit nor a score.
+func (b *BrokerService) workerStatuses(addresses []string) []WorkerStatusResponse {
+	statuses := make([]WorkerStatusResponse, len(addresses))
+	for i, ipAddress := range addresses {
+		client, err := dial(b.Dialer, ipAddress)
+		if err == nil {
+			err = call(client, b.WorkerCallTimeout, WorkerStatus, WorkerStatusRequest{}, &statuses[i])
+			client.Close()
+		}
+		if err != nil {
+			log.Printf("worker %v status: %v", ipAddress, err)
+			statuses[i] = WorkerStatusResponse{}
+		}
+	}
+	return statuses
 }
 
-func (b *BrokerService) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
-	turns := req.Turns
-	world := req.World
-
-	turn := 0
-
-	for turn < turns {
-		select {
-		case isPaused := <-b.pause:
-			b.isPaused = isPaused
-			if b.isPaused {
-				// Paused, wait for the signal to resume
-				<-b.pause
-			}
-		case <-b.quit:
-			// Received stop signal, exit the loop
+// workerWeights returns the benchmark score each worker's Status advertises, times the cores
+// it says compute each region, or nil to split evenly when any worker does not advertise a
+// score. A worker not saying how many cores counts as one.
+func workerWeights(statuses []WorkerStatusResponse) []float64 {
+	weights := make([]float64, len(statuses))
+	for i, status := range statuses {
+		// Workers from before scores were advertised share the board evenly.
+		if status.Score <= 0 {
 			return nil
-		default:
-			if !b.isPaused {
-
-				world.update(b.addresses)
-
-				b.Turns++
-				b.CellsCount = len(world.alive())
-				b.World = world
-
-				turn++
-			}
+		}
+		weights[i] = status.Score
+		if status.CoresAvailable > 1 {
+			weights[i] *= float64(status.CoresAvailable)
 		}
 	}
-
-	res.World = world
-	res.Turns = b.Turns
-
-	return nil
+	return weights
 }
 
-func (b *BrokerService) Save(req BrokerSaveRequest, res *BrokerSaveResponse) (err error) {
-	res.Turns = b.Turns
-	res.World = b.World
-	return
+// maxRegionHeight returns the smallest region height limit among the workers' statuses, or 0
+// when none has one. Workers that cannot say are assumed unlimited.
+func maxRegionHeight(statuses []WorkerStatusResponse) int {
+	maxHeight := 0
+	for _, status := range statuses {
+		if status.MaxRegionHeight > 0 && (maxHeight == 0 || status.MaxRegionHeight < maxHeight) {
+			maxHeight = status.MaxRegionHeight
+		}
+	}
+	return maxHeight
 }
 
-func (b *BrokerService) Quit(req BrokerQuitRequest, res *BrokerQuitResponse) (err error) {
-	res.Turns = b.Turns
+// dispatch is how the regions of each turn are handed out to the workers.
+type dispatch struct {
+	dialer      Dialer
+	addresses   []string
+	regions     int
+	retryBudget int
+	cache       *regionCache
+	slowCall    time.Duration
+	callTimeout time.Duration
 
-	b.Turns = 0
-	b.CellsCount = 0
-	b.World = World{}
+	// method is the RPC computing a region, WorkerProcess unless the addresses are brokers.
+	method string
 
-	b.quit <- true
+	// weights size region i in proportion to weights[i] when there is one region per worker,
+	// so region i, which goes to worker i, suits its machine. Nil splits the board evenly.
+	weights []float64
 
-	return nil
-}
+	// speeds times the workers for Adaptive runs, and is nil otherwise.
+	speeds *speeds
 
-func (b *BrokerService) Shutdown(req BrokerShutdownRequest, res *BrokerShutdownResponse) (err error) {
-	for _, ipAddress := range b.addresses {
-		client, err := rpc.Dial("tcp", ipAddress)
-		if err != nil {
-			log.Fatal("dialing:", err)
-		}
+	// dumpTurn is the turn whose regions are written to disk as they are sent, or 0 for none.
+	dumpTurn int64
 
-		defer client.Close()
+	// grid tiles the board into a grid of regions rather than splitting it into strips.
+	grid bool
 
-		request := WorkerShutdownRequest{}
-		response := new(WorkerShutdownResponse)
-		client.Call(WorkerShutdown, request, response)
-	}
+	// pack sends regions' rows packed, and compress gzips them, packed or not.
+	pack     bool
+	compress bool
 
-	b.shutdown <- true
+	// stats tallies the run for its summary, when set.
+	stats *runStats
 
-	res.Turns = b.Turns
-	return nil
+	// backoff and seed pace retries, see retryDelay. sleep is time.Sleep when nil.
+	backoff time.Duration
+	seed    int64
+	sleep   func(time.Duration)
 }
 
-func (b *BrokerService) Pause(req BrokerPauseRequest, res *BrokerPauseResponse) (err error) {
-	b.isPaused = !b.isPaused
-	b.pause <- b.isPaused
-	res.IsPaused = b.isPaused
-	res.Turns = b.Turns
-	return
-}
+// wait pauses before the given retry of a region.
+func (d dispatch) wait(turn int64, regionID, attempt int) {
+	delay := retryDelay(d.backoff, d.seed, turn, regionID, attempt)
+	if delay <= 0 {
+		return
+	}
+	if d.sleep != nil {
+		d.sleep(delay)
+		return
+	}
+	time.Sleep(delay)
+}
+
+// update computes the next turn by splitting the world into d.regions regions, which are
+// handed out to the workers round-robin. It returns the alive cells in each region.
+func (world *World) update(d dispatch, turn int64) ([]int, error) {
+	if d.grid {
+		if rows, cols, ok := gridShape(d.regions, world.Height, world.Width); ok {
+			return world.compute(d, world.regions2D(rows, cols), turn)
+		}
+	}
+
+	// A board with fewer rows than regions gets one row per region, leaving the rest idle.
+	parts := d.regions
+	if parts > world.Height {
+		parts = world.Height
+	}
+	regions := make([]Region, 0, parts)
+	for regionID := 0; regionID < parts; regionID++ {
+		if len(d.weights) == parts {
+			regions = append(regions, world.weightedRegion(regionID, d.weights))
+		} else {
+			regions = append(regions, world.region(regionID, parts))
+		}
+	}
+	return world.compute(d, regions, turn)
+}
+
+// regionResult is a computed region, tagged with the index of the region it computes.
+type regionResult struct {
+	id     int
+	region Region
+}
+
+// processRegionWithFailover computes region on the first of addrs, moving on to the next on
+// failure, such as from a worker that died mid-turn, for as long as the turn's shared retry
+// budget lasts. The attempts are made one after another, so only the one that succeeds
+// returns a region. It returns the computed region and its alive cells.
+func (region *Region) processRegionWithFailover(d dispatch, addrs []string, regionID int, turn int64, retries *int32) (regionResult, int, error) {
+	for attempt := int32(0); ; attempt++ {
+		ipAddress := addrs[int(attempt)%len(addrs)]
+		if d.dumpTurn == turn+1 {
+			if err := region.dump(ipAddress, d.dumpTurn); err != nil {
+				log.Printf("dumping region [%v, %v) for worker %v: %v", region.Start, region.End, ipAddress, err)
+			}
+		}
+		start := time.Now()
+		result, alive, err := region.update(d, ipAddress, turn)
+		if err == nil {
+			// A region that failed over says nothing of how fast its first worker is.
+			if attempt == 0 {
+				d.speeds.observe(ipAddress, region.Height, time.Since(start))
+			}
+			d.stats.computed(ipAddress)
+			return regionResult{id: regionID, region: result}, alive, nil
+		}
+		log.Printf("worker %v failed on turn %v: %v", ipAddress, turn, err)
+		if atomic.AddInt32(retries, -1) < 0 {
+			return regionResult{}, 0, ErrRetriesExhausted
+		}
+		d.stats.failedOver()
+		d.wait(turn, regionID, int(attempt))
+	}
+}
+
+// compute hands regions out to the workers round-robin and assembles their results into world,
+// returning the alive cells each worker counted in its region, in the order of regions.
+func (world *World) compute(d dispatch, regions []Region, turn int64) ([]int, error) {
+	workerAddrs := d.addresses
+	numWorkers := len(workerAddrs)
+	numRegions := len(regions)
+	if numWorkers == 0 {
+		return nil, ErrNoWorkers
+	}
+
+	regionCh := make(chan regionResult, numRegions)
+	errCh := make(chan error, numRegions)
+	retries := int32(d.retryBudget)
+	// Each count is written before its region is sent, so all are set once assembled.
+	counts := make([]int, numRegions)
+
+	for regionID, region := range regions {
+		go func(regionID int, region Region) {
+			// Regions start round-robin on the workers and fail over to the ones after.
+			addrs := append(append([]string(nil), workerAddrs[regionID%numWorkers:]...), workerAddrs[:regionID%numWorkers]...)
+			result, alive, err := region.processRegionWithFailover(d, addrs, regionID, turn, &retries)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			counts[regionID] = alive
+			regionCh <- result
+		}(regionID, region)
+	}
+
+	if err := world.assemble(regionCh, errCh, numRegions); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// assemble collects a result for each of regions and places each region's rows at its Start
+// offset, and a tile's cells at its StartX offset too, so the board comes out in spatial order
+// regardless of the order in which the workers finish. A result with missing rows is an
+// error, and the first error, from it or errCh, aborts the assembly and leaves the world
+// untouched.
+func (world *World) assemble(regionCh <-chan regionResult, errCh <-chan error, regions int) error {
+	newFieldData := make([][]Cell, world.Height)
+
+	for remaining := regions; remaining > 0; {
+		select {
+		case result := <-regionCh:
+			region := result.region
+			if region.Height == 0 || len(region.Field) != region.Height {
+				return fmt.Errorf("region [%v, %v) came back with %v of its %v rows", region.Start, region.End, len(region.Field), region.Height)
+			}
+			remaining--
+			if region.EndX <= region.StartX {
+				copy(newFieldData[region.Start:region.End], region.Field)
+				continue
+			}
+			// A tile only fills its own columns of the rows it spans.
+			for y, row := range region.Field {
+				if newFieldData[region.Start+y] == nil {
+					newFieldData[region.Start+y] = make([]Cell, world.Width)
+				}
+				copy(newFieldData[region.Start+y][region.StartX:region.EndX], row)
+			}
+		case err := <-errCh:
+			return err
+		}
+	}
+
+	world.Field.Data = newFieldData
+	return nil
+}
+
+// thumbnail scales the world down by a whole factor until neither side exceeds maxDim,
+// shading each pixel by the share of alive cells in the block it covers.
+func (world *World) thumbnail(maxDim int) [][]uint8 {
+	longest := world.Height
+	if world.Width > longest {
+		longest = world.Width
+	}
+	scale := (longest + maxDim - 1) / maxDim
+	if scale < 1 {
+		scale = 1
+	}
+
+	image := make([][]uint8, (world.Height+scale-1)/scale)
+	for ty := range image {
+		image[ty] = make([]uint8, (world.Width+scale-1)/scale)
+		for tx := range image[ty] {
+			alive, cells := 0, 0
+			for y := ty * scale; y < (ty+1)*scale && y < world.Height; y++ {
+				for x := tx * scale; x < (tx+1)*scale && x < world.Width; x++ {
+					cells++
+					if world.Field.Data[y][x].Alive {
+						alive++
+					}
+				}
+			}
+			image[ty][tx] = uint8(alive * 255 / cells)
+		}
+	}
+	return image
+}
+
+// verifyAssembly checks world is the turn after previous, computed serially over the whole
+// board, returning an error naming the first cell that differs.
+func (world *World) verifyAssembly(previous World, turn int64) error {
+	rule := world.rule.table()
+	for y := 0; y < world.Height; y++ {
+		for x := 0; x < world.Width; x++ {
+			neighbours := 0
+			for j := -1; j <= 1; j++ {
+				for i := -1; i <= 1; i++ {
+					wy, wx := y+j, x+i
+					if world.boundary == Fixed && (wy < 0 || wy >= world.Height || wx < 0 || wx >= world.Width) {
+						continue
+					}
+					wy = (wy + world.Height) % world.Height
+					wx = (wx + world.Width) % world.Width
+					if (i != 0 || j != 0) && previous.Field.Data[wy][wx].Alive {
+						neighbours++
+					}
+				}
+			}
+			alive := rule[0][neighbours]
+			if previous.Field.Data[y][x].Alive {
+				alive = rule[1][neighbours]
+			}
+			if world.Field.Data[y][x].Alive != alive {
+				return fmt.Errorf("turn %v: assembled cell (%v, %v) is alive=%v, computing the whole board gives alive=%v",
+					turn, x, y, world.Field.Data[y][x].Alive, alive)
+			}
+		}
+	}
+	return nil
+}
+
+func aliveCellsInRow(row []Cell, y int) []util.Cell {
+	var alive []util.Cell
+	for x, cell := range row {
+		if cell.Alive {
+			alive = append(alive, util.Cell{X: x, Y: y})
+		}
+	}
+	return alive
+}
+
+func (world *World) alive() []util.Cell {
+	var alive []util.Cell
+	for y, row := range world.Field.Data {
+		alive = append(alive, aliveCellsInRow(row, y)...)
+	}
+	return alive
+}
+
+// changedLocked returns the channel closed on the next state change. The caller holds mu.
+func (s *session) changedLocked() chan struct{} {
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return s.changed
+}
+
+// notifyLocked wakes the watchers of the broker's state. The caller holds mu.
+func (s *session) notifyLocked() {
+	s.seq++
+	close(s.changedLocked())
+	s.changed = make(chan struct{})
+}
+
+// touch records RPC activity, holding off the idle timeout.
+func (b *BrokerService) touch() {
+	b.mu.Lock()
+	b.lastActive = time.Now()
+	b.mu.Unlock()
+}
+
+// watchIdle waits until the broker has been idle for IdleTimeout, with no run in progress
+// and no RPCs, then shuts down the workers and the broker.
+func (b *BrokerService) watchIdle() {
+	b.touch()
+	for {
+		b.mu.RLock()
+		wait := b.IdleTimeout - time.Since(b.lastActive)
+		b.mu.RUnlock()
+
+		if b.anyRunning() {
+			wait = b.IdleTimeout
+		} else if wait <= 0 {
+			break
+		}
+		time.Sleep(wait)
+	}
+
+	log.Printf("Idle for %v, shutting down", b.IdleTimeout)
+	if err := b.shutdownWorkers(); err != nil {
+		log.Println(err)
+	}
+	b.stop()
+}
+
+// WaitStateChange blocks until the broker's state has moved on from req.Since, such as by a
+// completed turn or a pause, or until the timeout, and returns the state it is in then.
+func (b *BrokerService) WaitStateChange(req BrokerWaitStateChangeRequest, res *BrokerWaitStateChangeResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	timeout := req.Timeout
+	if timeout <= 0 || timeout > MaxStateChangeWait {
+		timeout = MaxStateChangeWait
+	}
+
+	s.mu.Lock()
+	changed := s.changedLocked()
+	unchanged := s.seq == req.Since
+	s.mu.Unlock()
+
+	if unchanged {
+		select {
+		case <-changed:
+		case <-time.After(timeout):
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res.Seq = s.seq
+	res.Turns = s.Turns
+	res.CellsCount = s.CellsCount
+	res.IsPaused = s.isPaused
+	res.Running = s.running
+	return
+}
+
+func (b *BrokerService) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res.Turns = s.Turns
+	res.CellsCount = s.CellsCount
+	return
+}
+
+// Flips returns the cells whose state has changed since the last call, for a live view that
+// animates the board without fetching it whole. A client that polls less often than every
+// turn gets the flips of all the turns since, up to res.Turns.
+func (b *BrokerService) Flips(req BrokerFlipsRequest, res *BrokerFlipsResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res.Turns = s.Turns
+	res.CellsCount = s.CellsCount
+	res.Cells = s.takeFlipsLocked()
+	return
+}
+
+// takeFlipsLocked returns the cells flipped since they were last taken, and starts over. The
+// caller holds mu.
+func (s *session) takeFlipsLocked() []util.Cell {
+	cells := make([]util.Cell, 0, len(s.flipped))
+	for cell := range s.flipped {
+		cells = append(cells, cell)
+	}
+	s.flipped = nil
+	return cells
+}
+
+// setWorldLocked replaces s.World with world, recording the cells that differ between them
+// for Flips, and returns them. The caller holds mu.
+func (s *session) setWorldLocked(world World) []util.Cell {
+	if s.flipped == nil {
+		s.flipped = make(map[util.Cell]struct{})
+	}
+	// A board of another size has no cells in common, so every alive cell flips.
+	resized := s.World.Height != world.Height || s.World.Width != world.Width ||
+		len(s.World.Field.Data) != len(world.Field.Data)
+	var flips []util.Cell
+	for y, row := range world.Field.Data {
+		for x, cell := range row {
+			if cell.Alive == (!resized && s.World.Field.Data[y][x].Alive) {
+				continue
+			}
+			flip := util.Cell{X: x, Y: y}
+			flips = append(flips, flip)
+			if _, ok := s.flipped[flip]; ok {
+				delete(s.flipped, flip)
+			} else {
+				s.flipped[flip] = struct{}{}
+			}
+		}
+	}
+	s.World = world
+	return flips
+}
+
+// RegionCounts returns the alive cells in each worker's region on the last completed turn, to
+// show how unevenly the work is spread across the board.
+func (b *BrokerService) RegionCounts(req BrokerRegionCountsRequest, res *BrokerRegionCountsResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res.Turns = s.Turns
+	res.Counts = append([]int(nil), s.regionCounts...)
+	return
+}
+
+// checkCellsCount returns an error if CellsCount has drifted from the alive cells in the
+// world, which would mean a bug in how the count is tracked. The caller holds mu.
+func (s *session) checkCellsCount() error {
+	if alive := len(s.World.alive()); s.CellsCount != alive {
+		return fmt.Errorf("turn %v: cells count %v does not match the %v alive cells on the board", s.Turns, s.CellsCount, alive)
+	}
+	return nil
+}
+
+// snapshotResponse captures the last completed turn.
+func (s *session) snapshotResponse() BrokerPauseAndSnapshotResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return BrokerPauseAndSnapshotResponse{
+		Turns:      s.Turns,
+		CellsCount: s.CellsCount,
+		World:      s.World,
+	}
+}
+
+func (b *BrokerService) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	b.touch()
+	method := WorkerProcess
+	if b.Coordinator {
+		method = BrokerProcessRegion
+	}
+	if req.Resume {
+		return b.resume(req, res, method)
+	}
+	return b.simulate(req, res, method)
+}
+
+// resume answers a Process re-issued with Resume with the outcome of the session's run,
+// waiting for it if it is still going. If no run was started, the request never arrived, so
+// it is started now.
+func (b *BrokerService) resume(req BrokerProcessRequest, res *BrokerProcessResponse, method string) error {
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	token, finished := s.token, s.finished
+	s.mu.RUnlock()
+	if finished == nil {
+		return b.simulate(req, res, method)
+	}
+	if token != req.Token {
+		return ErrNotController
+	}
+	<-finished
+
+	s.mu.RLock()
+	last := s.last
+	s.mu.RUnlock()
+	*res = last.res
+	if req.Packed.Height > 0 {
+		res.Packed = res.World.pack()
+		res.World = World{}
+	}
+	return last.err
+}
+
+// CoordinatorProcess runs the simulation like Process, but treats this broker's addresses as
+// other brokers, each splitting its share of the board further among its own workers. Each
+// broker keeps its band between turns, so just the halo rows between bands are exchanged
+// through this coordinator every turn, and the board is pulled back only when it is wanted.
+func (b *BrokerService) CoordinatorProcess(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	b.touch()
+	return b.simulate(req, res, BrokerProcessRegion)
+}
+
+// ProcessRegion computes the next state of a region handed out by a coordinator, splitting it
+// among this broker's workers as Process does with a whole board. A resident band is kept for
+// the run's next turn, which sends just its halos, and only its edge rows are returned, as
+// this broker has no peers to swap them with.
+func (b *BrokerService) ProcessRegion(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	b.touch()
+	// A compressed or packed region is answered in kind.
+	compressed := req.Region.Compressed
+	if req.Region, err = req.Region.decompressed(); err != nil {
+		return
+	}
+	packed := req.Region.Packed.Height > 0
+	region, err := req.Region.unpacked()
+	if err != nil {
+		return
+	}
+	switch {
+	case req.Resident != req.EdgesOnly:
+		return ErrEdgesNotResident
+	case req.Resident && req.HaloOnly:
+		if region, err = b.withKeptBand(req, region); err != nil {
+			return
+		}
+	case req.HaloOnly:
+		return ErrNoBand
+	}
+	addresses := b.workerAddresses()
+	if len(addresses) == 0 {
+		return ErrNoWorkers
+	}
+	d := dispatch{
+		dialer:      b.Dialer,
+		addresses:   addresses,
+		retryBudget: b.RetryBudget,
+		slowCall:    b.SlowCall,
+		callTimeout: b.WorkerCallTimeout,
+		pack:        b.PackRegions,
+		compress:    b.CompressRegions,
+		backoff:     b.RetryBackoff,
+		seed:        b.Seed,
+		sleep:       b.sleep,
+	}
+
+	// A band with fewer rows than workers gets one row per region, leaving the rest idle.
+	parts := len(addresses)
+	if parts > region.Height {
+		parts = region.Height
+	}
+	regions := make([]Region, parts)
+	for part := range regions {
+		regions[part] = region.split(part, len(regions))
+	}
+	interior := World{Height: region.Height, Width: region.Width}
+	counts, err := interior.compute(d, regions, req.Turn)
+	if err != nil {
+		return
+	}
+
+	res.Region = region
+	res.Region.Field = interior.Field.Data
+	if req.Resident {
+		rows := interior.Field.Data
+		b.keepBand(req, region.Start, rows)
+		res.Region.Field = [][]Cell{rows[0], rows[len(rows)-1]}
+	}
+	if packed {
+		res.Region = res.Region.packed()
+	}
+	if compressed {
+		if res.Region, err = res.Region.compressed(); err != nil {
+			return
+		}
+	}
+	for _, count := range counts {
+		res.AliveCount += count
+	}
+	return
+}
+
+// newDispatch plans how the regions of a height row board are handed out to addresses,
+// tallying the run in stats.
+func (b *BrokerService) newDispatch(addresses []string, height int, method string, stats *runStats) dispatch {
+	d := dispatch{
+		dialer:      countingDialer{dialer: b.Dialer, bytes: &stats.bytes},
+		stats:       stats,
+		addresses:   addresses,
+		regions:     len(addresses),
+		retryBudget: b.RetryBudget,
+		slowCall:    b.SlowCall,
+		callTimeout: b.WorkerCallTimeout,
+		method:      method,
+		dumpTurn:    b.DumpTurn,
+		pack:        b.PackRegions,
+		compress:    b.CompressRegions,
+		backoff:     b.RetryBackoff,
+		seed:        b.Seed,
+		sleep:       b.sleep,
+	}
+	// Brokers neither advertise region limits nor cache the rows they return, and split
+	// their regions into strips.
+	if method == WorkerProcess {
+		statuses := b.workerStatuses(addresses)
+		d.regions = regionCount(height, len(addresses), maxRegionHeight(statuses))
+		// Tiles are neither weighted nor cached, as both go by whole rows.
+		d.grid = b.Decomposition == Grid
+		if d.regions == len(addresses) && !d.grid {
+			d.weights = workerWeights(statuses)
+			// Strips that move every turn would defeat the rows kept on the workers.
+			if b.Adaptive && !b.HaloOnly && !b.Resident {
+				d.speeds = newSpeeds()
+				if weights := b.measuredWeights(addresses); weights != nil {
+					d.weights = weights
+				}
+			}
+		}
+		if b.HaloOnly && !d.grid {
+			d.cache = newRegionCache()
+		}
+	}
+	return d
+}
+
+// simulate runs the turns of req, computing each region of the board with method.
+func (b *BrokerService) simulate(req BrokerProcessRequest, res *BrokerProcessResponse, method string) (err error) {
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	turns := req.Turns
+	if req.Packed.Height > 0 {
+		if req.World, err = req.Packed.unpack(); err != nil {
+			return
+		}
+		// Answered in kind, with the final board packed.
+		defer func() {
+			res.Packed = res.World.pack()
+			res.World = World{}
+		}()
+	}
+	world := req.World
+	if err = b.Limits.check(world, turns); err != nil {
+		return
+	}
+	if req.Boundary != "" && req.Boundary != Toroidal && req.Boundary != Fixed {
+		return fmt.Errorf("unknown boundary %q", req.Boundary)
+	}
+	if err = req.Rule.check(); err != nil {
+		return
+	}
+	addresses := b.workerAddresses()
+	if len(addresses) == 0 {
+		return ErrNoWorkers
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+	// The outcome is kept for a client that resumes, before finished lets it read it.
+	defer func() {
+		s.mu.Lock()
+		s.last = runResult{res: *res, err: err}
+		s.mu.Unlock()
+	}()
+	if req.Banded {
+		// Kept for FetchBand in place of the answer, which a resumed Process gets too.
+		defer func() {
+			s.mu.Lock()
+			s.final = finalBoard{turns: res.Turns, world: res.World}
+			s.mu.Unlock()
+			res.World = World{}
+		}()
+	}
+	s.mu.Lock()
+	completed := int64(0)
+	if b.Continue {
+		completed = s.Turns
+		if s.World.Height > 0 {
+			world = s.World
+		}
+	}
+	world.boundary = req.Boundary
+	world.rule = req.Rule
+
+	if turns < 0 || turns > math.MaxInt64-completed {
+		s.mu.Unlock()
+		return ErrTurnsOutOfRange
+	}
+	s.finished = finished
+	s.token = req.Token
+	s.final = finalBoard{}
+	// The client starts from the world it sent, which Continue may have replaced.
+	s.World = req.World
+	s.flipped = nil
+	s.setWorldLocked(world)
+	s.Turns = completed
+	s.CellsCount = len(world.alive())
+	s.running = true
+	s.isPaused = false
+	s.startStreamLocked(req.Stream)
+	s.notifyLocked()
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.notifyLocked()
+		s.mu.Unlock()
+		b.touch()
+	}()
+
+	stats := newRunStats()
+	d := b.newDispatch(addresses, world.Height, method, stats)
+	if err = b.warmup(d, world); err != nil {
+		return
+	}
+	defer stats.report(res)
+
+	r := b.newRun(s, req, res, method, d, world, completed)
+	if r.resident != nil {
+		s.setResident(true)
+		defer s.setResident(false)
+	}
+	// The workers keep rows for this run until told it is over.
+	defer r.end()
+	return r.play(turns)
+}
+
+// warmup computes b.Warmup turns of a copy of world before a run, to open connections and
+// warm caches so the run's timing is steady. They count towards no turn total, metric or
+// summary.
+func (b *BrokerService) warmup(d dispatch, world World) error {
+	if b.Warmup <= 0 {
+		return nil
+	}
+	// Rows cached during the warmup must not be mistaken for the run's own.
+	if d.cache != nil {
+		d.cache = newRegionCache()
+	}
+	d.dumpTurn = 0
+	d.dialer = b.Dialer
+	d.stats = nil
+	start := time.Now()
+	for turn := int64(0); turn < b.Warmup; turn++ {
+		b.scheduler.acquire(len(d.addresses))
+		_, err := world.update(d, turn)
+		b.scheduler.release(len(d.addresses))
+		if err != nil {
+			return fmt.Errorf("warmup turn %v: %v", turn, err)
+		}
+	}
+	log.Printf("warmed up with %v turns in %v", b.Warmup, time.Since(start))
+	return nil
+}
+
+func (b *BrokerService) Save(req BrokerSaveRequest, res *BrokerSaveResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	res.Turns, res.World = s.board()
+	return
+}
+
+// GetWorld returns the last completed turn, for clients that poll the whole board.
+func (b *BrokerService) GetWorld(req BrokerGetWorldRequest, res *BrokerGetWorldResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	res.Turns, res.World = s.board()
+	return
+}
+
+// GetThumbnail returns the last completed turn scaled down to fit req.MaxDim, for previewing
+// boards too large to fetch whole. It only reads the board, so the run carries on meanwhile.
+func (b *BrokerService) GetThumbnail(req BrokerGetThumbnailRequest, res *BrokerGetThumbnailResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	if req.MaxDim <= 0 {
+		return fmt.Errorf("invalid thumbnail size %v", req.MaxDim)
+	}
+
+	turns, world := s.board()
+	res.Turns = turns
+	res.Image = world.thumbnail(req.MaxDim)
+	return
+}
+
+// PauseAndSnapshot holds the running simulation at the next turn boundary, captures the
+// world at exactly that turn and lets it carry on, so a saved image matches its turn.
+// Without a running simulation it returns the last completed turn straight away.
+func (b *BrokerService) PauseAndSnapshot(req BrokerPauseAndSnapshotRequest, res *BrokerPauseAndSnapshotResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	*res = s.betweenTurns()
+	return
+}
+
+// betweenTurns captures the last completed turn, from the running simulation at its next turn
+// boundary if there is one.
+func (s *session) betweenTurns() BrokerPauseAndSnapshotResponse {
+	s.mu.RLock()
+	finished := s.finished
+	s.mu.RUnlock()
+	if finished == nil {
+		return s.snapshotResponse()
+	}
+
+	reply := make(chan BrokerPauseAndSnapshotResponse, 1)
+	select {
+	case s.snapshot <- reply:
+		return <-reply
+	case <-finished:
+		return s.snapshotResponse()
+	}
+}
+
+// board returns the last completed turn and its board. A resident run only pulls the board
+// back from its workers when it is wanted, so that waits for its next turn boundary.
+func (s *session) board() (int64, World) {
+	s.mu.RLock()
+	resident := s.residentRun
+	turns, world := s.Turns, s.World
+	s.mu.RUnlock()
+	if resident {
+		snapshot := s.betweenTurns()
+		turns, world = snapshot.Turns, snapshot.World
+	}
+	return turns, world
+}
+
+func (s *session) setResident(resident bool) {
+	s.mu.Lock()
+	s.residentRun = resident
+	s.mu.Unlock()
+}
+
+// authorise checks token against the running simulation's.
+func (s *session) authorise(token string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if token != s.token {
+		return ErrNotController
+	}
+	return nil
+}
+
+// stopRun stops the run in progress, if any, once its current turn is complete, and waits
+// for it to return, so the broker's state is left at a turn boundary.
+func (s *session) stopRun() {
+	s.mu.RLock()
+	finished := s.finished
+	s.mu.RUnlock()
+	if finished == nil {
+		return
+	}
+
+	select {
+	case s.quit <- true:
+		<-finished
+	case <-finished:
+	}
+}
+
+// Quit stops the run in progress at its next turn boundary and clears the board, answering
+// with the turn reached. It may be called at any time, as often as wanted, and returns at
+// once when there is no run to stop.
+func (b *BrokerService) Quit(req BrokerQuitRequest, res *BrokerQuitResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	if err = s.authorise(req.Token); err != nil {
+		return
+	}
+
+	s.stopRun()
+
+	s.mu.Lock()
+	res.Turns = s.Turns
+	// A banded run's final board outlives its session's Quit until it has been fetched.
+	keep := s.final.world.Height > 0
+
+	s.Turns = 0
+	s.CellsCount = 0
+	s.World = World{}
+	s.flipped = nil
+	s.isPaused = false
+	s.startStreamLocked(false)
+	s.notifyLocked()
+	s.mu.Unlock()
+
+	// A session from Start ends here, its run having stopped, so nothing of it is kept.
+	if req.SessionID != "" && !keep {
+		b.endSession(req.SessionID)
+	}
+	return nil
+}
+
+func (b *BrokerService) Shutdown(req BrokerShutdownRequest, res *BrokerShutdownResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	if err = s.authorise(req.Token); err != nil {
+		return
+	}
+
+	// Stop at a turn boundary first, so the run is not left with a half computed turn.
+	s.stopRun()
+
+	s.mu.RLock()
+	res.Turns = s.Turns
+	res.World = s.World
+	s.mu.RUnlock()
+	// The workers are going, so every other session's run stops too.
+	for _, other := range b.allSessions() {
+		other.stopRun()
+	}
+
+	// The broker goes even if some workers could not be reached, and the client is told which.
+	err = b.shutdownWorkers()
+	b.stop()
+	return err
+}
+
+// stop tells main the broker is to exit. It may be called more than once, such as by the idle
+// timeout and a Shutdown at the same time, and never blocks.
+func (b *BrokerService) stop() {
+	b.shutdownOnce.Do(func() { close(b.shutdown) })
+}
+
+// shutdownWorkers asks every worker to shut down, returning the first it could not reach.
+func (b *BrokerService) shutdownWorkers() (err error) {
+	for _, ipAddress := range b.workerAddresses() {
+		client, dialErr := dial(b.Dialer, ipAddress)
+		if dialErr != nil {
+			if err == nil {
+				err = fmt.Errorf("dialing %v: %v", ipAddress, dialErr)
+			}
+			continue
+		}
+
+		request := WorkerShutdownRequest{}
+		response := new(WorkerShutdownResponse)
+		call(client, b.WorkerCallTimeout, WorkerShutdown, request, response)
+		client.Close()
+	}
+	return
+}
+
+func (b *BrokerService) Pause(req BrokerPauseRequest, res *BrokerPauseResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	if err = s.authorise(req.Token); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.isPaused = !s.isPaused
+	isPaused := s.isPaused
+	s.notifyLocked()
+	s.mu.Unlock()
+
+	// A run being paused stops at its next turn boundary, which is the turn reported.
+	if isPaused {
+		res.Turns = s.betweenTurns().Turns
+	} else {
+		s.mu.RLock()
+		res.Turns = s.Turns
+		s.mu.RUnlock()
+	}
+	res.IsPaused = isPaused
+	return
+}
+
+// Step computes exactly one turn of a paused run and leaves it paused, answering with the
+// turn and board it reached. Unless the run is paused it does nothing, with Stepped false, so
+// a step never races the running turns.
+func (b *BrokerService) Step(req BrokerStepRequest, res *BrokerStepResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	if err = s.authorise(req.Token); err != nil {
+		return
+	}
+
+	for {
+		s.mu.RLock()
+		paused, changed, finished := s.isPaused, s.changed, s.finished
+		res.Turns = s.Turns
+		s.mu.RUnlock()
+		if !paused || finished == nil {
+			return nil
+		}
+		reply := make(chan stepResult, 1)
+		select {
+		case s.step <- reply:
+			result := <-reply
+			if result.err != nil {
+				return result.err
+			}
+			*res = result.res
+			return nil
+		case <-changed:
+			// Paused, resumed or a turn on since, so look again.
+		case <-finished:
+			return nil
+		}
+	}
+}
+
+// stepResponse answers a Step with the last completed turn, and the flips up to it.
+func (s *session) stepResponse() BrokerStepResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return BrokerStepResponse{
+		Stepped:    true,
+		Turns:      s.Turns,
+		CellsCount: s.CellsCount,
+		World:      s.World,
+		Cells:      s.takeFlipsLocked(),
+	}
+}
 
 func main() {
 	pAddr := flag.String("port", "8030", "Port to listen on")
+	retries := flag.Int("retries", 3, "Failed worker calls to retry per turn before aborting the run")
+	debug := flag.Bool("debug", false, "Check the alive cells count against the board after every turn")
+	haloOnly := flag.Bool("halo-only", false, "Send workers only the halo rows of regions they computed last turn")
+	adaptive := flag.Bool("adaptive", false, "Size each worker's strip by how fast it computed its last one, instead of splitting the board evenly")
+	resident := flag.Bool("resident", false, "Keep strips on the workers between turns, swapping halo rows between them, and pull the board back only when it is wanted")
+	packRegions := flag.Bool("pack-regions", true, "Send the workers their regions packed a bit per cell, rather than a Cell per cell")
+	compressRegions := flag.Bool("compress", false, "Gzip the regions sent to and from the workers, for slow links when regions are not packed")
+	decomposition := flag.String("decomposition", string(Strips), "How to divide the board among the workers: strips of whole rows, or a grid of tiles")
+	slowCall := flag.Duration("slow", 0, "Log a warning for worker calls slower than this, 0 to disable")
+	workerTimeout := flag.Duration("worker-timeout", 30*time.Second, "Fail a worker call over to another worker after this long without a reply, 0 to wait as long as it takes")
+	coordinator := flag.Bool("coordinator", false, "Treat -workers as brokers, each splitting its share of the board among its own workers")
+	workers := flag.String("workers", "", "Comma separated worker addresses, added to by workers started with -broker")
+	metricsAddr := flag.String("metrics", "", "Address to serve Prometheus metrics on at /metrics, e.g. :9090")
+	metricsPort := flag.String("metrics-port", "", "Port to serve Prometheus metrics on at /metrics, short for -metrics :port")
+	web := flag.Bool("web", false, "Also serve a page viewing the board at / on the -metrics address")
+	warmup := flag.Int64("warmup", 0, "Turns to compute and discard before each run, so its timing excludes connection and cache setup")
+	tps := flag.Int("tps", 0, "Most turns to compute per second, for watching a run at a steady pace, 0 for no limit")
+	healthInterval := flag.Duration("health-interval", 5*time.Second, "How often to ping the workers, removing any that fail -max-failures in a row, 0 to never")
+	maxFailures := flag.Int("max-failures", 3, "Consecutive failed pings after which a worker is removed")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Shut down the broker and its workers after this long without a run or any RPC, 0 to never")
+	dumpTurn := flag.Int64("dump-turn", 0, "Write the regions sent to compute this turn to out/ as pgm files, 0 to disable")
+	maxCells := flag.Int("max-cells", 0, "Largest board in cells a session may process, 0 for no limit")
+	maxTurns := flag.Int64("max-turns", 0, "Most turns a session may ask for, 0 for no limit")
+	maxCompute := flag.Duration("max-compute", 0, "Compute time after which a session is stopped, 0 for no limit")
+	verifyAssembly := flag.Bool("verify-assembly", false, "Check every assembled turn against computing the whole board serially")
+	retryBackoff := flag.Duration("retry-backoff", 0, "Base delay before retrying a failed worker call, doubled per attempt with random jitter, 0 to retry at once")
+	seed := flag.Int64("seed", 0, "Seed for every random choice the broker makes, such as retry jitter, 0 to pick one from the clock")
+	resetOnProcess := flag.Bool("reset-on-process", true, "Start every Process afresh, rather than continuing from the last one's board and turn")
+	checkpointInterval := flag.Int64("checkpoint-interval", 0, "Write the board to the -checkpoint file every this many turns, 0 to disable")
+	checkpointPath := flag.String("checkpoint", "broker.checkpoint", "File checkpoints are written to")
+	resume := flag.String("resume", "", "Checkpoint file to load, which the next Process carries on from")
+	config := flag.String("config", "", "JSON file of flag values, overridden by flags given on the command line")
+	tlsCert := flag.String("tls-cert", "", "Certificate to serve RPCs over TLS with, plain TCP when not given")
+	tlsKey := flag.String("tls-key", "", "Key of the -tls-cert certificate")
+	tlsCA := flag.String("tls-ca", "", "CA certificate the workers must present certificates signed by, to dial them over TLS")
 
 	flag.Parse()
 
+	if *config != "" {
+		if err := util.LoadConfig(flag.CommandLine, *config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	serverTLS, err := util.ServerTLS(*tlsCert, *tlsKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientTLS, err := util.ClientTLS(*tlsCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if d := Decomposition(*decomposition); d != Strips && d != Grid {
+		log.Fatalf("unknown decomposition %q, expected %v or %v", d, Strips, Grid)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	// Logged so a run can be replayed with -seed.
+	log.Printf("seed %v", *seed)
+
 	b := &BrokerService{
-		quit:      make(chan bool),
+		session: session{
+			quit:     make(chan bool),
+			snapshot: make(chan chan BrokerPauseAndSnapshotResponse),
+			step:     make(chan chan stepResult),
+		},
 		shutdown:  make(chan bool),
-		pause:     make(chan bool),
-		isPaused:  false,
-		addresses: []string{"18.234.185.167:8030", "3.93.10.151:8030"},
+		addresses: uniqueAddresses(strings.FieldsFunc(*workers, func(r rune) bool { return r == ',' })),
+
+		RetryBudget: *retries,
+		Debug:       *debug,
+		HaloOnly:    *haloOnly,
+		Resident:    *resident,
+		Adaptive:    *adaptive,
+		SlowCall:    *slowCall,
+		Coordinator: *coordinator,
+		IdleTimeout: *idleTimeout,
+		DumpTurn:    *dumpTurn,
+
+		WorkerCallTimeout: *workerTimeout,
+		CompressRegions:   *compressRegions,
+
+		RetryBackoff: *retryBackoff,
+		Seed:         *seed,
+
+		VerifyAssembly: *verifyAssembly,
+		Decomposition:  Decomposition(*decomposition),
+		PackRegions:    *packRegions,
+		Continue:       !*resetOnProcess,
+		HealthInterval: *healthInterval,
+		Warmup:         *warmup,
+		MaxTPS:         *tps,
+		MaxFailures:    *maxFailures,
+		Limits: Limits{
+			MaxCells:       *maxCells,
+			MaxTurns:       *maxTurns,
+			MaxComputeTime: *maxCompute,
+		},
+
+		CheckpointInterval: *checkpointInterval,
+		CheckpointPath:     *checkpointPath,
+	}
+	if clientTLS != nil {
+		b.Dialer = TLSDialer{Config: clientTLS}
+	}
+
+	if *resume != "" {
+		turn, world, err := loadCheckpoint(*resume)
+		if err != nil {
+			log.Fatalf("resuming from %v: %v", *resume, err)
+		}
+		b.Turns = int64(turn)
+		b.World = world
+		b.CellsCount = len(world.alive())
+		b.Continue = true
+		log.Printf("resuming from turn %v", turn)
+	}
+
+	if *metricsPort != "" {
+		*metricsAddr = ":" + *metricsPort
+	}
+	if *metricsAddr != "" {
+		b.metrics = newMetrics()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", b.metrics)
+		if *web {
+			b.serveWeb(mux)
+		}
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
 	}
 
 	rpc.Register(b)
 
-	listener, _ := net.Listen("tcp", ":"+*pAddr)
+	listener, err := util.Listen(":"+*pAddr, serverTLS)
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer listener.Close()
 
 	wg := sync.WaitGroup{}
@@ -325,6 +2225,13 @@ func main() {
 
 	go rpc.Accept(listener)
 
+	if b.IdleTimeout > 0 {
+		go b.watchIdle()
+	}
+	if b.HealthInterval > 0 {
+		go b.watchHealth(nil)
+	}
+
 	<-b.shutdown
 
 	listener.Close()
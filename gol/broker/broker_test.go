@@ -0,0 +1,2687 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+var (
+	fuzzSeed       = flag.Int64("fuzz.seed", 1, "First seed TestDistributedMatchesSerial runs")
+	fuzzIterations = flag.Int("fuzz.iterations", 100, "How many seeds TestDistributedMatchesSerial runs")
+)
+
+// randomWorld builds a height x width world with roughly a third of its cells alive.
+func randomWorld(height, width int, seed int64) World {
+	rng := rand.New(rand.NewSource(seed))
+	field := Field{Data: make([][]Cell, height), Height: height, Width: width}
+	for y := range field.Data {
+		field.Data[y] = make([]Cell, width)
+		for x := range field.Data[y] {
+			field.Data[y][x] = Cell{X: x, Y: y, Alive: rng.Intn(3) == 0}
+		}
+	}
+	return World{Field: field, Height: height, Width: width}
+}
+
+// step computes the next state of a region's interior rows with the naive kernel the workers
+// use, reading a tile's neighbours from its halo columns rather than wrapping around, and
+// taking a Fixed strip's cells past either end of a row as dead, by the region's Rule.
+func step(region Region) [][]Cell {
+	rule := region.Rule.table()
+	haloX := 0
+	if region.EndX > region.StartX {
+		haloX = DefaultHaloOffset
+	}
+	next := make([][]Cell, region.Height)
+	for y := range next {
+		next[y] = make([]Cell, region.Width)
+		for x := range next[y] {
+			neighbours := 0
+			for j := -1; j <= 1; j++ {
+				for i := -1; i <= 1; i++ {
+					wx := (x + i + region.Width) % region.Width
+					if haloX > 0 {
+						wx = x + i + haloX
+					} else if region.Boundary == Fixed && wx != x+i {
+						continue
+					}
+					if (i != 0 || j != 0) && region.Field[y+DefaultHaloOffset+j][wx].Alive {
+						neighbours++
+					}
+				}
+			}
+			cell := region.Field[y+DefaultHaloOffset][x+haloX]
+			if cell.Alive {
+				cell.Alive = rule[1][neighbours]
+			} else {
+				cell.Alive = rule[0][neighbours]
+			}
+			next[y][x] = cell
+		}
+	}
+	return next
+}
+
+// evolve computes the world after the given number of turns serially.
+func evolve(world World, turns int) World {
+	for turn := 0; turn < turns; turn++ {
+		next := World{Height: world.Height, Width: world.Width}
+		next.Field.Data = step(world.region(0, 1))
+		world = next
+	}
+	return world
+}
+
+// truncatingWorker is a testWorker that drops the last row of every region it returns.
+type truncatingWorker struct {
+	testWorker
+}
+
+func (w *truncatingWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	if err = w.testWorker.Process(req, res); err == nil {
+		res.Region.Field = res.Region.Field[:len(res.Region.Field)-1]
+	}
+	return
+}
+
+// haloWorker is a testWorker that returns its regions with the halo rows left on, either
+// keeping the region's Height or setting it to the rows returned.
+type haloWorker struct {
+	testWorker
+	setHeight bool
+}
+
+func (w *haloWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	if err = w.testWorker.Process(req, res); err == nil {
+		field := append([][]Cell{req.Region.Field[0]}, res.Region.Field...)
+		res.Region.Field = append(field, req.Region.Field[len(req.Region.Field)-1])
+		if w.setHeight {
+			res.Region.Height = len(res.Region.Field)
+		}
+	}
+	return
+}
+
+// slowWorker is a testWorker that takes delay over every call.
+type slowWorker struct {
+	testWorker
+	delay time.Duration
+}
+
+func (w *slowWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	time.Sleep(w.delay)
+	return w.testWorker.Process(req, res)
+}
+
+// testWorker is an in-process stand-in for WorkerService, which like the real worker
+// rejects regions taller than a non-zero maxHeight and answers packed or compressed regions
+// in kind. Its Status says it is ready unless notReady is set.
+type testWorker struct {
+	calls     int32
+	fail      bool
+	maxHeight int
+	cores     int
+	notReady  bool
+}
+
+func (w *testWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	atomic.AddInt32(&w.calls, 1)
+	if w.fail {
+		return errors.New("worker failure")
+	}
+	if w.maxHeight > 0 && req.Region.Height > w.maxHeight {
+		return fmt.Errorf("region of %v rows exceeds the limit of %v", req.Region.Height, w.maxHeight)
+	}
+	compressed := req.Region.Compressed
+	if req.Region, err = req.Region.decompressed(); err != nil {
+		return
+	}
+	packed := req.Region.Packed.Height > 0
+	if req.Region, err = req.Region.unpacked(); err != nil {
+		return
+	}
+	res.Region = req.Region
+	res.Region.Field = step(req.Region)
+	for _, row := range res.Region.Field {
+		res.AliveCount += len(aliveCellsInRow(row, 0))
+	}
+	if packed {
+		res.Region = res.Region.packed()
+	}
+	if compressed {
+		res.Region, err = res.Region.compressed()
+	}
+	return
+}
+
+func (w *testWorker) Status(req WorkerStatusRequest, res *WorkerStatusResponse) (err error) {
+	res.Ready = !w.notReady
+	res.Version = "test"
+	res.CoresAvailable = w.cores
+	res.MaxRegionHeight = w.maxHeight
+	return
+}
+
+// startWorker serves worker under the WorkerService name and returns its address.
+func startWorker(t testing.TB, worker interface{}) string {
+	server := rpc.NewServer()
+	if err := server.RegisterName("WorkerService", worker); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(listener)
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+func newTestBroker(addresses ...string) *BrokerService {
+	return &BrokerService{
+		session: session{
+			quit:     make(chan bool),
+			snapshot: make(chan chan BrokerPauseAndSnapshotResponse),
+			step:     make(chan chan stepResult),
+		},
+		shutdown:  make(chan bool),
+		addresses: addresses,
+		Debug:     true,
+	}
+}
+
+// interior returns the region with its halo rows stripped, as a worker would return it.
+func interior(region Region) Region {
+	region.Field = region.Field[DefaultHaloOffset : region.Height+DefaultHaloOffset]
+	return region
+}
+
+func assertEqualWorld(t testing.TB, given, expected World) {
+	t.Helper()
+	if len(given.Field.Data) != len(expected.Field.Data) {
+		t.Fatalf("expected %d rows, got %d", len(expected.Field.Data), len(given.Field.Data))
+	}
+	for y := range expected.Field.Data {
+		for x := range expected.Field.Data[y] {
+			if given.Field.Data[y][x].Alive != expected.Field.Data[y][x].Alive {
+				t.Fatalf("cell (%d, %d) differs: expected alive=%v", x, y, expected.Field.Data[y][x].Alive)
+			}
+		}
+	}
+}
+
+// AssertTiling checks that regions cover every row of world exactly once, ignoring their
+// halo rows, and reports the first row that is left uncovered or covered twice.
+func AssertTiling(t *testing.T, world World, regions []Region) {
+	t.Helper()
+	covered := make([]int, world.Height)
+	for _, region := range regions {
+		if region.Start < 0 || region.Start > region.End || region.End > world.Height {
+			t.Errorf("region [%d, %d) does not fit the %d rows of the board", region.Start, region.End, world.Height)
+			return
+		}
+		for y := region.Start; y < region.End; y++ {
+			covered[y]++
+		}
+	}
+	for y, count := range covered {
+		if count == 0 {
+			t.Errorf("row %d is not covered by any region", y)
+			return
+		}
+		if count > 1 {
+			t.Errorf("row %d is covered by %d regions", y, count)
+			return
+		}
+	}
+}
+
+func TestRegionTiling(t *testing.T) {
+	for _, height := range []int{1, 2, 3, 16, 17, 64} {
+		for numWorkers := 1; numWorkers <= height && numWorkers <= 16; numWorkers++ {
+			t.Run(fmt.Sprintf("%d-rows-%d-workers", height, numWorkers), func(t *testing.T) {
+				world := randomWorld(height, 8, 0)
+				var regions []Region
+				for w := 0; w < numWorkers; w++ {
+					regions = append(regions, world.region(w, numWorkers))
+				}
+				AssertTiling(t, world, regions)
+			})
+		}
+	}
+}
+
+// TestAssembleOutOfOrder delivers worker results in reverse order and checks the rows are
+// still placed at their Start offsets.
+func TestAssembleOutOfOrder(t *testing.T) {
+	for _, numWorkers := range []int{1, 2, 3, 5, 16} {
+		t.Run(fmt.Sprintf("%d-workers", numWorkers), func(t *testing.T) {
+			world := randomWorld(16, 16, int64(numWorkers))
+
+			var regions []Region
+			regionCh := make(chan regionResult, numWorkers)
+			for w := numWorkers - 1; w >= 0; w-- {
+				region := world.region(w, numWorkers)
+				regions = append(regions, region)
+				regionCh <- regionResult{id: w, region: interior(region)}
+			}
+			AssertTiling(t, world, regions)
+
+			assembled := World{Height: world.Height, Width: world.Width}
+			if err := assembled.assemble(regionCh, nil, numWorkers); err != nil {
+				t.Fatal(err)
+			}
+
+			assertEqualWorld(t, assembled, world)
+		})
+	}
+}
+
+// shuffledWorker is a testWorker that holds each region for the delay given to its Start, and
+// records the Start of every region it finishes, in the order it finishes them.
+type shuffledWorker struct {
+	testWorker
+	delays map[int]time.Duration
+
+	mu       sync.Mutex
+	finished []int
+}
+
+func (w *shuffledWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	time.Sleep(w.delays[req.Region.Start])
+	err = w.testWorker.Process(req, res)
+	w.mu.Lock()
+	w.finished = append(w.finished, req.Region.Start)
+	w.mu.Unlock()
+	return
+}
+
+// TestShuffledCompletion has workers finish their regions in a shuffled order over RPC, and
+// checks the board is still assembled from the rows each region starts at.
+func TestShuffledCompletion(t *testing.T) {
+	const numWorkers = 6
+	world := randomWorld(24, 16, 13)
+	worker := &shuffledWorker{delays: make(map[int]time.Duration)}
+	shuffled := make([]int, numWorkers)
+	var addresses []string
+	for i, rank := range rand.New(rand.NewSource(13)).Perm(numWorkers) {
+		start := world.region(i, numWorkers).Start
+		worker.delays[start] = time.Duration(rank) * 20 * time.Millisecond
+		shuffled[rank] = start
+		addresses = append(addresses, startWorker(t, worker))
+	}
+	b := newTestBroker(addresses...)
+
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 1))
+	if !reflect.DeepEqual(worker.finished, shuffled) {
+		t.Errorf("expected the regions starting at %v to finish in that order, got %v", shuffled, worker.finished)
+	}
+}
+
+// TestAssembleEmptyRegion checks a region returned without its rows fails the turn rather
+// than leaving rows of the board missing.
+func TestAssembleEmptyRegion(t *testing.T) {
+	world := randomWorld(16, 16, 11)
+	top, bottom := interior(world.region(0, 2)), interior(world.region(1, 2))
+	emptied := top
+	emptied.Field = nil
+
+	regionCh := make(chan regionResult, 2)
+	regionCh <- regionResult{id: 1, region: bottom}
+	regionCh <- regionResult{id: 0, region: emptied}
+	assembled := World{Height: world.Height, Width: world.Width}
+	if err := assembled.assemble(regionCh, nil, 2); err == nil {
+		t.Error("expected an empty region to be an error")
+	}
+	if assembled.Field.Data != nil {
+		t.Error("expected the failed assembly to leave the world untouched")
+	}
+}
+
+// TestRetriesExhausted has every worker fail and checks the run aborts once the turn's
+// retry budget is used up, rather than retrying forever.
+func TestRetriesExhausted(t *testing.T) {
+	workers := []*testWorker{{fail: true}, {fail: true}}
+	b := newTestBroker(startWorker(t, workers[0]), startWorker(t, workers[1]))
+	b.RetryBudget = 3
+
+	req := BrokerProcessRequest{Turns: 10, World: randomWorld(16, 16, 1)}
+	err := b.Process(req, new(BrokerProcessResponse))
+	if err != ErrRetriesExhausted {
+		t.Fatalf("expected ErrRetriesExhausted, got %v", err)
+	}
+	if b.Turns != 0 {
+		t.Errorf("expected no completed turns, got %d", b.Turns)
+	}
+
+	// Every worker stops after its first failure past the budget.
+	expected := int32(len(workers) + b.RetryBudget)
+	deadline := time.Now().Add(time.Second)
+	for {
+		calls := atomic.LoadInt32(&workers[0].calls) + atomic.LoadInt32(&workers[1].calls)
+		if calls == expected {
+			break
+		}
+		if calls > expected || time.Now().After(deadline) {
+			t.Fatalf("expected %d worker calls, got %d", expected, calls)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestRetryOtherWorker checks a failed region is recomputed by another worker when the
+// budget allows it.
+func TestRetryOtherWorker(t *testing.T) {
+	world := randomWorld(16, 16, 2)
+
+	b := newTestBroker(startWorker(t, &testWorker{fail: true}), startWorker(t, &testWorker{}))
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, new(BrokerProcessResponse)); err != ErrRetriesExhausted {
+		t.Fatalf("expected ErrRetriesExhausted without a budget, got %v", err)
+	}
+
+	b = newTestBroker(startWorker(t, &testWorker{fail: true}), startWorker(t, &testWorker{}))
+	b.RetryBudget = 1
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	expected := World{Height: world.Height, Width: world.Width}
+	expected.Field.Data = step(world.region(0, 1))
+	assertEqualWorld(t, res.World, expected)
+}
+
+// TestRegister starts a broker with no workers, registers three over RPC, one of them twice,
+// and checks Process hands regions to all three.
+func TestRegister(t *testing.T) {
+	b := newTestBroker()
+	world := randomWorld(16, 16, 4)
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, new(BrokerProcessResponse)); err != ErrNoWorkers {
+		t.Fatalf("expected ErrNoWorkers before any registered, got %v", err)
+	}
+
+	server := rpc.NewServer()
+	if err := server.Register(b); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.Accept(listener)
+	client, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	workers := []*testWorker{{}, {}, {}}
+	var addresses []string
+	for _, worker := range workers {
+		addresses = append(addresses, startWorker(t, worker))
+	}
+	// The repeat of the first worker must not be counted again.
+	expected := []int{1, 2, 3, 3}
+	for i, address := range append(addresses, addresses[0]) {
+		res := new(BrokerRegisterResponse)
+		if err := client.Call("BrokerService.Register", BrokerRegisterRequest{Address: address}, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Workers != expected[i] {
+			t.Errorf("expected %v workers after registering %v, got %v", expected[i], address, res.Workers)
+		}
+	}
+
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 1))
+	for i, worker := range workers {
+		if atomic.LoadInt32(&worker.calls) == 0 {
+			t.Errorf("registered worker %v was sent no regions", i)
+		}
+	}
+}
+
+// TestRegisterNotReady checks a worker whose Status says it is not ready, and one that cannot
+// be reached, are refused rather than handed regions.
+func TestRegisterNotReady(t *testing.T) {
+	b := newTestBroker()
+	for _, address := range []string{startWorker(t, &testWorker{notReady: true}), deadAddress(t)} {
+		if err := b.Register(BrokerRegisterRequest{Address: address}, new(BrokerRegisterResponse)); err == nil {
+			t.Errorf("expected %v to be refused", address)
+		}
+	}
+	if addresses := b.workerAddresses(); len(addresses) != 0 {
+		t.Errorf("expected no workers registered, got %v", addresses)
+	}
+}
+
+// deadAddress returns the address of a listener that has already closed, like a worker that died.
+func deadAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	return listener.Addr().String()
+}
+
+// TestHealthChecks checks a dead worker is removed after MaxFailures pings and the board is
+// then split among the live ones, and that Process fails cleanly once every worker is gone.
+func TestHealthChecks(t *testing.T) {
+	live := &testWorker{}
+	dead := deadAddress(t)
+	b := newTestBroker(dead, startWorker(t, live))
+	b.HealthInterval = 10 * time.Millisecond
+	b.MaxFailures = 2
+	stop := make(chan struct{})
+	defer close(stop)
+	go b.watchHealth(stop)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(b.workerAddresses()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v to be removed, still have %v", dead, b.workerAddresses())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	world := randomWorld(16, 16, 6)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 2, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 2))
+
+	b = newTestBroker(deadAddress(t))
+	b.HealthInterval = 10 * time.Millisecond
+	b.MaxFailures = 1
+	go b.watchHealth(stop)
+	for len(b.workerAddresses()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected every worker to be removed, still have %v", b.workerAddresses())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, res); err != ErrNoWorkers {
+		t.Errorf("expected ErrNoWorkers once every worker is removed, got %v", err)
+	}
+}
+
+// TestRepartition removes a worker mid-run and checks the remaining turns are split among the
+// rest, with the result still correct.
+func TestRepartition(t *testing.T) {
+	first, second := &testWorker{}, &slowWorker{delay: 5 * time.Millisecond}
+	firstAddress := startWorker(t, first)
+	b := newTestBroker(firstAddress, startWorker(t, second))
+	world := randomWorld(16, 16, 7)
+
+	done := make(chan error)
+	res := new(BrokerProcessResponse)
+	go func() { done <- b.Process(BrokerProcessRequest{Turns: 20, World: world}, res) }()
+	for atomic.LoadInt32(&first.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	b.removeWorker(firstAddress)
+	calls := atomic.LoadInt32(&first.calls)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 20))
+	// The turn in progress when it was removed may still reach it, but none after.
+	if after := atomic.LoadInt32(&first.calls); after > calls+1 {
+		t.Errorf("removed worker was sent %v more regions", after-calls)
+	}
+}
+
+// layoutWorker is a testWorker recording the height of the region it was sent for each turn,
+// which holds turn hold until released.
+type layoutWorker struct {
+	testWorker
+	hold    int64
+	held    chan struct{}
+	release chan struct{}
+
+	mu      sync.Mutex
+	heights map[int64]int
+}
+
+func newLayoutWorker(hold int64) *layoutWorker {
+	return &layoutWorker{hold: hold, held: make(chan struct{}), release: make(chan struct{}), heights: make(map[int64]int)}
+}
+
+func (w *layoutWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	w.mu.Lock()
+	w.heights[req.Turn] = req.Region.Height
+	w.mu.Unlock()
+	if req.Turn == w.hold {
+		close(w.held)
+		<-w.release
+	}
+	return w.testWorker.Process(req, res)
+}
+
+func (w *layoutWorker) height(turn int64) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.heights[turn]
+}
+
+// TestRegisterMidRun registers a second worker during turn 10 and checks the board is split
+// between both from the next turn on, with the result still correct.
+func TestRegisterMidRun(t *testing.T) {
+	first := newLayoutWorker(10)
+	b := newTestBroker(startWorker(t, first))
+	world := randomWorld(16, 16, 66)
+
+	done := make(chan error)
+	res := new(BrokerProcessResponse)
+	go func() { done <- b.Process(BrokerProcessRequest{Turns: 20, World: world}, res) }()
+	<-first.held
+	second := newLayoutWorker(-1)
+	if err := b.Register(BrokerRegisterRequest{Address: startWorker(t, second)}, new(BrokerRegisterResponse)); err != nil {
+		t.Fatal(err)
+	}
+	close(first.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 20))
+
+	if h := first.height(10); h != 16 {
+		t.Errorf("expected turn 10 on the first worker alone, got %v of its rows", h)
+	}
+	if h1, h2 := first.height(11), second.height(11); h1 != 8 || h2 != 8 {
+		t.Errorf("expected turn 11 split 8 and 8, got %v and %v", h1, h2)
+	}
+}
+
+// TestNoWorkersMidRun removes the only worker mid-run and checks the run waits, rather than
+// failing, until another registers.
+func TestNoWorkersMidRun(t *testing.T) {
+	first := newLayoutWorker(3)
+	firstAddress := startWorker(t, first)
+	b := newTestBroker(firstAddress)
+	world := randomWorld(16, 16, 67)
+
+	done := make(chan error)
+	res := new(BrokerProcessResponse)
+	go func() { done <- b.Process(BrokerProcessRequest{Turns: 8, World: world}, res) }()
+	<-first.held
+	b.removeWorker(firstAddress)
+	close(first.release)
+
+	time.Sleep(50 * time.Millisecond)
+	waiting := new(BrokerWaitStateChangeResponse)
+	if err := b.WaitStateChange(BrokerWaitStateChangeRequest{}, waiting); err != nil {
+		t.Fatal(err)
+	}
+	if !waiting.Running || waiting.Turns != 4 {
+		t.Fatalf("expected the run waiting after turn 4, got %+v", waiting)
+	}
+
+	if err := b.Register(BrokerRegisterRequest{Address: startWorker(t, &testWorker{})}, new(BrokerRegisterResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 8))
+}
+
+// TestMoreWorkersThanRows runs boards with fewer rows than workers, evenly and by weight, and
+// checks no empty region is sent and the result is still correct.
+func TestMoreWorkersThanRows(t *testing.T) {
+	const height, turns = 3, 4
+	world := randomWorld(height, 8, 8)
+
+	var workers []*testWorker
+	var addresses []string
+	for i := 0; i < 5; i++ {
+		worker := &testWorker{}
+		workers = append(workers, worker)
+		addresses = append(addresses, startWorker(t, worker))
+	}
+	b := newTestBroker(addresses...)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: turns, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, turns))
+
+	calls := int32(0)
+	for _, worker := range workers {
+		calls += atomic.LoadInt32(&worker.calls)
+	}
+	if calls != height*turns {
+		t.Errorf("expected one call per row per turn, %v, got %v", height*turns, calls)
+	}
+
+	// A worker weighted far below the rest gets no rows at all.
+	d := dispatch{addresses: addresses[:3], regions: 3, weights: []float64{1, 1000, 1}, method: WorkerProcess}
+	weighted := world
+	if _, err := weighted.update(d, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, weighted, evolve(world, 1))
+}
+
+// TestSeededBackoff checks two runs with the same seed wait out the same retry delays, and
+// that another seed changes them.
+func TestSeededBackoff(t *testing.T) {
+	world := randomWorld(32, 32, 2)
+	delays := func(seed int64) []time.Duration {
+		b := newTestBroker(startWorker(t, &testWorker{fail: true}), startWorker(t, &testWorker{fail: true}), startWorker(t, &testWorker{}))
+		b.RetryBudget = 20
+		b.RetryBackoff = time.Millisecond
+		b.Seed = seed
+		var mu sync.Mutex
+		var waited []time.Duration
+		b.sleep = func(delay time.Duration) {
+			mu.Lock()
+			waited = append(waited, delay)
+			mu.Unlock()
+		}
+		if err := b.Process(BrokerProcessRequest{Turns: 2, World: world}, new(BrokerProcessResponse)); err != nil {
+			t.Fatal(err)
+		}
+		// Regions retry concurrently, so only the set of delays is reproducible, not their order.
+		sort.Slice(waited, func(i, j int) bool { return waited[i] < waited[j] })
+		return waited
+	}
+
+	first := delays(42)
+	if len(first) == 0 {
+		t.Fatal("expected failed calls to back off")
+	}
+	if second := delays(42); !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed backed off %v, then %v", first, second)
+	}
+	if other := delays(43); reflect.DeepEqual(first, other) {
+		t.Errorf("seeds 42 and 43 both backed off %v", first)
+	}
+}
+
+// TestWorkerKilledMidRun kills one of three workers halfway through a long run, as kill -9
+// would, and checks its regions fail over to the others with the final board still correct.
+func TestWorkerKilledMidRun(t *testing.T) {
+	const turns = 1000
+	world := randomWorld(16, 16, 9)
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("WorkerService", &testWorker{}); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.Accept(listener)
+
+	b := newTestBroker(startWorker(t, &testWorker{}), listener.Addr().String(), startWorker(t, &testWorker{}))
+	b.RetryBudget = 1
+	done := make(chan error)
+	res := new(BrokerProcessResponse)
+	go func() { done <- b.Process(BrokerProcessRequest{Turns: turns, World: world}, res) }()
+
+	for {
+		b.mu.RLock()
+		completed := b.Turns
+		b.mu.RUnlock()
+		if completed >= turns/2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	listener.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if res.Turns != turns {
+		t.Fatalf("expected %v turns, got %v", turns, res.Turns)
+	}
+	assertEqualWorld(t, res.World, evolve(world, turns))
+}
+
+// TestSummedCellsCount checks the alive cells summed from the workers' counts match a scan of
+// the board, on boards of several shapes split between three workers, after several turn counts.
+func TestSummedCellsCount(t *testing.T) {
+	addresses := []string{startWorker(t, &testWorker{}), startWorker(t, &testWorker{}), startWorker(t, &testWorker{})}
+	for _, shape := range [][2]int{{1, 16}, {2, 5}, {7, 30}, {16, 16}, {33, 64}} {
+		for _, turns := range []int64{1, 4, 25} {
+			b := newTestBroker(addresses...)
+			// Debug would catch a mismatch itself, this compares after the run instead.
+			b.Debug = false
+			world := randomWorld(shape[0], shape[1], int64(shape[0]*shape[1])+turns)
+			res := new(BrokerProcessResponse)
+			if err := b.Process(BrokerProcessRequest{Turns: turns, World: world}, res); err != nil {
+				t.Fatal(err)
+			}
+			if alive := len(res.World.alive()); b.CellsCount != alive {
+				t.Errorf("%vx%v after %v turns: summed %v alive cells, the board has %v", shape[1], shape[0], turns, b.CellsCount, alive)
+			}
+		}
+	}
+}
+
+// TestRunStats checks Process returns the traffic of a run, how many regions each worker
+// computed and how many failed over, with one of two workers always failing.
+func TestRunStats(t *testing.T) {
+	const turns = 3
+	failing, good := startWorker(t, &testWorker{fail: true}), startWorker(t, &testWorker{})
+	b := newTestBroker(failing, good)
+	b.RetryBudget = 1
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: turns, World: randomWorld(16, 16, 13)}, res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Bytes <= 0 {
+		t.Errorf("expected traffic to be counted, got %v bytes", res.Bytes)
+	}
+	// Both regions end up on the good worker, the failing one's after a failover each turn.
+	if res.WorkerRegions[good] != 2*turns || res.WorkerRegions[failing] != 0 {
+		t.Errorf("expected %v regions on %v and none on %v, got %v", 2*turns, good, failing, res.WorkerRegions)
+	}
+	if res.Failovers != turns {
+		t.Errorf("expected %v failovers, got %v", turns, res.Failovers)
+	}
+}
+
+// TestWorkerCallTimeout checks a worker that hangs past WorkerCallTimeout has its region failed
+// over to another worker rather than holding up the turn, and that with no other worker the
+// run fails instead of hanging.
+func TestWorkerCallTimeout(t *testing.T) {
+	const turns = 3
+	world := randomWorld(16, 16, 43)
+	hung, good := startWorker(t, &slowWorker{delay: time.Minute}), startWorker(t, &testWorker{})
+
+	b := newTestBroker(hung, good)
+	b.WorkerCallTimeout = 20 * time.Millisecond
+	b.RetryBudget = turns
+	res := new(BrokerProcessResponse)
+	start := time.Now()
+	if err := b.Process(BrokerProcessRequest{Turns: turns, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the hung worker to time out, the run took %v", elapsed)
+	}
+	if res.Failovers != turns || res.WorkerRegions[hung] != 0 {
+		t.Errorf("expected %v failovers and no regions on %v, got %v and %v", turns, hung, res.Failovers, res.WorkerRegions)
+	}
+	assertEqualWorld(t, res.World, evolve(world, turns))
+
+	alone := newTestBroker(hung)
+	alone.WorkerCallTimeout = 20 * time.Millisecond
+	alone.RetryBudget = 1
+	if err := alone.Process(BrokerProcessRequest{Turns: turns, World: world}, new(BrokerProcessResponse)); err != ErrRetriesExhausted {
+		t.Errorf("expected %v with only the hung worker, got %v", ErrRetriesExhausted, err)
+	}
+}
+
+// TestPauseAndSnapshot takes snapshots of a running simulation and checks each one is the
+// world at exactly the turn it reports.
+func TestPauseAndSnapshot(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	seed := randomWorld(16, 16, 3)
+
+	done := make(chan error)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{Turns: 1000000, World: seed}, new(BrokerProcessResponse))
+	}()
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(20 * time.Millisecond)
+		res := new(BrokerPauseAndSnapshotResponse)
+		if err := b.PauseAndSnapshot(BrokerPauseAndSnapshotRequest{}, res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.World.alive()) != res.CellsCount {
+			t.Errorf("snapshot has %d alive cells but reports %d", len(res.World.alive()), res.CellsCount)
+		}
+		assertEqualWorld(t, res.World, evolve(seed, int(res.Turns)))
+	}
+
+	b.Quit(BrokerQuitRequest{}, new(BrokerQuitResponse))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTurnsOutOfRange checks turn counts that would overflow the broker's counter are rejected.
+func TestTurnsOutOfRange(t *testing.T) {
+	for _, field := range []interface{}{BrokerService{}.Turns, BrokerProcessRequest{}.Turns, WorkerProcessRequest{}.Turn} {
+		if _, ok := field.(int64); !ok {
+			t.Fatalf("turn counters must be int64, found %T", field)
+		}
+	}
+
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	b.Continue = true
+	b.Turns = 10
+	world := randomWorld(4, 4, 4)
+	for _, turns := range []int64{-1, math.MaxInt64 - 9, math.MaxInt64} {
+		if err := b.Process(BrokerProcessRequest{Turns: turns, World: world}, new(BrokerProcessResponse)); err != ErrTurnsOutOfRange {
+			t.Errorf("%d turns: expected ErrTurnsOutOfRange, got %v", turns, err)
+		}
+	}
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Turns != 1 {
+		t.Errorf("expected the response to count the 1 turn of this call, got %d", res.Turns)
+	}
+	if b.Turns != 11 {
+		t.Errorf("expected the counter to reach 11, got %d", b.Turns)
+	}
+}
+
+func TestRegionCount(t *testing.T) {
+	for _, height := range []int{1, 2, 3, 16, 17, 64} {
+		for _, maxHeight := range []int{0, 1, 2, 3, 5, 100} {
+			for numWorkers := 1; numWorkers <= 4; numWorkers++ {
+				count := regionCount(height, numWorkers, maxHeight)
+				if count < numWorkers {
+					t.Errorf("%d rows, %d workers, limit %d: %d regions leaves a worker idle", height, numWorkers, maxHeight, count)
+				}
+				if maxHeight == 0 || count > height {
+					continue
+				}
+				world := randomWorld(height, 1, 0)
+				for w := 0; w < count; w++ {
+					if region := world.region(w, count); region.Height > maxHeight {
+						t.Errorf("%d rows, %d workers, limit %d: region %d has %d rows", height, numWorkers, maxHeight, w, region.Height)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestMaxRegionHeight has one worker advertise a small limit and checks the board is split
+// into enough regions that it never receives one it rejects.
+func TestMaxRegionHeight(t *testing.T) {
+	small, large := &testWorker{maxHeight: 3}, &testWorker{}
+	b := newTestBroker(startWorker(t, small), startWorker(t, large))
+
+	if maxHeight := maxRegionHeight(b.workerStatuses(b.addresses)); maxHeight != 3 {
+		t.Fatalf("expected the smallest advertised limit of 3, got %d", maxHeight)
+	}
+
+	world := randomWorld(16, 16, 5)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 2, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 2))
+
+	regions := regionCount(16, 2, 3)
+	if regions < 6 {
+		t.Errorf("expected at least 6 regions of at most 3 rows, got %d", regions)
+	}
+	if calls := small.calls + large.calls; calls != int32(2*regions) {
+		t.Errorf("expected %d worker calls over 2 turns, got %d", 2*regions, calls)
+	}
+}
+
+// TestCellsCountDrift checks the debug check catches a count that no longer matches the board.
+func TestCellsCountDrift(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	if err := b.Process(BrokerProcessRequest{Turns: 20, World: randomWorld(16, 16, 6)}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.checkCellsCount(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.CellsCount++
+	if err := b.checkCellsCount(); err == nil {
+		t.Error("expected an error for a count that drifted from the board")
+	}
+}
+
+// pipeDialer is a Dialer serving every connection in memory from server, whatever the address.
+type pipeDialer struct {
+	server *rpc.Server
+	dials  int32
+}
+
+func (d *pipeDialer) Dial(address string) (net.Conn, error) {
+	atomic.AddInt32(&d.dials, 1)
+	client, server := net.Pipe()
+	go d.server.ServeConn(server)
+	return client, nil
+}
+
+// TestCustomDialer routes every worker connection through an in-memory pipe, to addresses
+// that could not be dialled over TCP.
+func TestCustomDialer(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("WorkerService", &testWorker{}); err != nil {
+		t.Fatal(err)
+	}
+	dialer := &pipeDialer{server: server}
+
+	b := newTestBroker("mesh/worker-0", "mesh/worker-1")
+	b.Dialer = dialer
+
+	world := randomWorld(16, 16, 7)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 3, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 3))
+	if dialer.dials == 0 {
+		t.Error("expected the workers to be dialled through the custom dialer")
+	}
+}
+
+// cachingWorker is a testWorker that serves halo-only requests from the rows it last returned.
+type cachingWorker struct {
+	haloCalls int32
+
+	mu   sync.Mutex
+	rows map[int][][]Cell
+}
+
+func (w *cachingWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	region := req.Region
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if req.HaloOnly {
+		atomic.AddInt32(&w.haloCalls, 1)
+		rows, ok := w.rows[region.Start]
+		if !ok {
+			return errors.New("no cached rows")
+		}
+		region.Field = append(append([][]Cell{region.Field[0]}, rows...), region.Field[1])
+	}
+	res.Region = region
+	res.Region.Field = step(region)
+	for _, row := range res.Region.Field {
+		res.AliveCount += len(aliveCellsInRow(row, 0))
+	}
+	if w.rows == nil {
+		w.rows = make(map[int][][]Cell)
+	}
+	w.rows[region.Start] = res.Region.Field
+	return
+}
+
+// TestHaloOnly checks a run sending halo-only requests matches the serial result.
+func TestHaloOnly(t *testing.T) {
+	workers := []*cachingWorker{{}, {}}
+	b := newTestBroker(startWorker(t, workers[0]), startWorker(t, workers[1]))
+	b.HaloOnly = true
+
+	world := randomWorld(16, 16, 8)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 5, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 5))
+
+	// Every turn after the first reuses both regions.
+	if calls := workers[0].haloCalls + workers[1].haloCalls; calls != 8 {
+		t.Errorf("expected 8 halo-only calls, got %d", calls)
+	}
+}
+
+// residentWorker stands in for a worker keeping its strips between turns, reading its halos
+// from the strips of the peers at their Up and Down addresses directly rather than over RPC.
+type residentWorker struct {
+	peers   map[string]*residentWorker
+	fetches int32
+	// ended counts the EndRun calls, and returned the requests answered with their rows.
+	ended    int32
+	returned int32
+	// maxHeight is the limit Status advertises, and failTurn a turn whose first resident
+	// request fails, or 0 for none.
+	maxHeight int
+	failTurn  int64
+
+	mu sync.Mutex
+	// strips are the strips kept, by their first row.
+	strips map[int]*keptStrip
+	failed bool
+}
+
+type keptStrip struct {
+	up, down           string
+	upStart, downStart int
+	// rows are the rows at the start of each turn, up to latest.
+	rows   map[int64][][]Cell
+	latest int64
+}
+
+func (w *residentWorker) at(start int, turn int64) ([][]Cell, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	strip, ok := w.strips[start]
+	if !ok {
+		return nil, false
+	}
+	rows, ok := strip.rows[turn]
+	return rows, ok
+}
+
+func (w *residentWorker) Status(req WorkerStatusRequest, res *WorkerStatusResponse) (err error) {
+	res.Ready = true
+	res.MaxRegionHeight = w.maxHeight
+	return
+}
+
+func (w *residentWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	region := req.Region
+	if w.maxHeight > 0 && region.Height > w.maxHeight {
+		return fmt.Errorf("region of %v rows exceeds the limit of %v", region.Height, w.maxHeight)
+	}
+	w.mu.Lock()
+	fail := req.Resident && w.failTurn > 0 && req.Turn == w.failTurn && !w.failed
+	w.failed = w.failed || fail
+	w.mu.Unlock()
+	if fail {
+		return fmt.Errorf("failing turn %v", req.Turn)
+	}
+	if region.Field == nil {
+		w.mu.Lock()
+		strip, ok := w.strips[region.Start]
+		var rows [][]Cell
+		if ok {
+			rows, ok = strip.rows[req.Turn]
+		}
+		w.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("no strip kept at row %v for turn %v", region.Start, req.Turn)
+		}
+		// Past the edge of a Fixed board there is no peer, and the halo is dead.
+		top, bottom := make([]Cell, region.Width), make([]Cell, region.Width)
+		aboveOk, belowOk := true, true
+		if strip.up != "" {
+			var above [][]Cell
+			above, aboveOk = w.peers[strip.up].at(strip.upStart, req.Turn)
+			if aboveOk {
+				top = above[len(above)-1]
+			}
+		}
+		if strip.down != "" {
+			var below [][]Cell
+			below, belowOk = w.peers[strip.down].at(strip.downStart, req.Turn)
+			if belowOk {
+				bottom = below[0]
+			}
+		}
+		if !aboveOk || !belowOk {
+			return fmt.Errorf("no halos kept for turn %v", req.Turn)
+		}
+		region.Field = append(append([][]Cell{top}, rows...), bottom)
+	}
+	next := step(region)
+	res.Region = req.Region
+	for _, row := range next {
+		res.AliveCount += len(aliveCellsInRow(row, 0))
+	}
+	if !req.Resident {
+		atomic.AddInt32(&w.returned, 1)
+		res.Region.Field = next
+		return
+	}
+
+	w.mu.Lock()
+	if w.strips == nil {
+		w.strips = make(map[int]*keptStrip)
+	}
+	if req.Region.Field != nil {
+		w.strips[region.Start] = &keptStrip{up: req.Up, down: req.Down, upStart: req.UpStart, downStart: req.DownStart, rows: make(map[int64][][]Cell)}
+	}
+	strip := w.strips[region.Start]
+	strip.rows[req.Turn+1] = next
+	strip.latest = req.Turn + 1
+	w.mu.Unlock()
+	res.Region.Field = nil
+	return
+}
+
+func (w *residentWorker) EndRun(req WorkerEndRunRequest, res *WorkerEndRunResponse) (err error) {
+	atomic.AddInt32(&w.ended, 1)
+	return
+}
+
+func (w *residentWorker) FetchStrip(req WorkerFetchStripRequest, res *WorkerFetchStripResponse) (err error) {
+	atomic.AddInt32(&w.fetches, 1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	strip, ok := w.strips[req.Start]
+	if !ok {
+		return fmt.Errorf("no strip kept at row %v", req.Start)
+	}
+	rows := strip.rows[strip.latest]
+	res.Turn = strip.latest
+	res.Region = Region{Field: rows, Start: req.Start, End: req.Start + len(rows), Height: len(rows), Width: len(rows[0])}
+	return
+}
+
+// TestResident checks a resident run matches the serial result, keeps the board on the
+// workers until the run ends, and pulls it back for a snapshot between turns.
+func TestResident(t *testing.T) {
+	workers := []*residentWorker{{}, {}, {}}
+	b := newTestBroker(startResidentWorkers(t, workers...)...)
+	b.Debug = false
+	b.Resident = true
+
+	world := randomWorld(16, 16, 11)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 10, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 10))
+	if b.CellsCount != len(res.World.alive()) {
+		t.Errorf("expected %d alive cells, got %d", len(res.World.alive()), b.CellsCount)
+	}
+	for i, worker := range workers {
+		if worker.fetches != 1 {
+			t.Errorf("worker %d: expected the board pulled back once, at the end, got %d fetches", i, worker.fetches)
+		}
+	}
+	// The workers are told the run is over without it waiting for them.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		ended := 0
+		for _, worker := range workers {
+			if atomic.LoadInt32(&worker.ended) > 0 {
+				ended++
+			}
+		}
+		if ended == len(workers) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected every worker told the run is over, got %d of %d", ended, len(workers))
+		}
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{Turns: 1000000, World: world}, new(BrokerProcessResponse))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	snapshot := new(BrokerGetWorldResponse)
+	if err := b.GetWorld(BrokerGetWorldRequest{}, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, snapshot.World, evolve(world, int(snapshot.Turns)))
+	b.Quit(BrokerQuitRequest{}, new(BrokerQuitResponse))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startResidentWorkers serves workers as peers of each other.
+func startResidentWorkers(t *testing.T, workers ...*residentWorker) []string {
+	peers := make(map[string]*residentWorker)
+	var addresses []string
+	for _, worker := range workers {
+		worker.peers = peers
+		address := startWorker(t, worker)
+		peers[address] = worker
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// TestResidentHeightLimit checks a resident run splits the board into strips short enough for
+// workers with a height limit, more than one to a worker, and still matches the serial result.
+func TestResidentHeightLimit(t *testing.T) {
+	workers := []*residentWorker{{maxHeight: 3}, {maxHeight: 3}}
+	b := newTestBroker(startResidentWorkers(t, workers...)...)
+	b.Debug = false
+	b.Resident = true
+
+	world := randomWorld(16, 12, 13)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 10, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 10))
+	for i, worker := range workers {
+		if worker.returned != 0 {
+			t.Errorf("worker %d: expected every turn computed resident, got %d regions returned", i, worker.returned)
+		}
+	}
+}
+
+// TestResidentFailover fails one worker's resident request part way through a run, and checks
+// the run computes the turns up to it again from the last board pulled back and carries on.
+func TestResidentFailover(t *testing.T) {
+	workers := []*residentWorker{{}, {failTurn: 4}, {}}
+	b := newTestBroker(startResidentWorkers(t, workers...)...)
+	b.Debug = false
+	b.Resident = true
+
+	world := randomWorld(16, 16, 17)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 10, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 10))
+	if b.CellsCount != len(res.World.alive()) {
+		t.Errorf("expected %d alive cells, got %d", len(res.World.alive()), b.CellsCount)
+	}
+	if !workers[1].failed {
+		t.Error("expected the worker to have failed its turn")
+	}
+	returned := int32(0)
+	for _, worker := range workers {
+		returned += worker.returned
+	}
+	// The board was last pulled back when the run began, so the 5 turns up to the failed one
+	// are computed again, in 3 regions each.
+	if returned != 5*3 {
+		t.Errorf("expected %d regions computed again, got %d", 5*3, returned)
+	}
+}
+
+// TestPackRegions runs a packed board through workers sent packed regions, checking the final
+// board comes back packed and matches the serial result, and that a packed board with too few
+// bits for its size is refused.
+func TestPackRegions(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	b.PackRegions = true
+	world := randomWorld(20, 70, 32)
+
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 8, Packed: world.pack()}, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.World.Height != 0 {
+		t.Error("expected the final board only packed")
+	}
+	given, err := res.Packed.unpack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, given, evolve(world, 8))
+
+	short := world.pack()
+	short.Bits = short.Bits[1:]
+	if err := b.Process(BrokerProcessRequest{Turns: 1, Packed: short}, res); err == nil {
+		t.Error("expected a packed board short of bits to be refused")
+	}
+}
+
+// BenchmarkHaloOnlyBytes compares the encoded size of sending a region whole with sending
+// just its halos, for a quarter of a 512x512 board.
+func BenchmarkHaloOnlyBytes(b *testing.B) {
+	world := randomWorld(512, 512, 9)
+	region := world.region(0, 4)
+	whole := WorkerProcessRequest{Region: region, Turn: 1, Run: 1}
+	halos := WorkerProcessRequest{Region: region.halos(), Turn: 1, HaloOnly: true, Run: 1}
+
+	var wholeBytes, haloBytes int
+	for i := 0; i < b.N; i++ {
+		for _, request := range []*WorkerProcessRequest{&whole, &halos} {
+			var buffer bytes.Buffer
+			if err := gob.NewEncoder(&buffer).Encode(request); err != nil {
+				b.Fatal(err)
+			}
+			if request.HaloOnly {
+				haloBytes = buffer.Len()
+			} else {
+				wholeBytes = buffer.Len()
+			}
+		}
+	}
+	b.ReportMetric(float64(wholeBytes), "whole-bytes")
+	b.ReportMetric(float64(haloBytes), "halo-bytes")
+}
+
+// BenchmarkWarmup runs 50 turns after 10 warmup turns, reporting the measured turns a second,
+// and checks the warmup turns are not counted in the turns the client is told about.
+func BenchmarkWarmup(b *testing.B) {
+	const turns, warmup = 50, 10
+	world := randomWorld(64, 64, 10)
+	var addresses []string
+	for i := 0; i < 4; i++ {
+		server := rpc.NewServer()
+		if err := server.RegisterName("WorkerService", &testWorker{}); err != nil {
+			b.Fatal(err)
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer listener.Close()
+		go server.Accept(listener)
+		addresses = append(addresses, listener.Addr().String())
+	}
+	broker := newTestBroker(addresses...)
+	broker.Debug = false
+	broker.Warmup = warmup
+	expected := evolve(world, turns)
+
+	var computeTime time.Duration
+	for i := 0; i < b.N; i++ {
+		res := new(BrokerProcessResponse)
+		if err := broker.Process(BrokerProcessRequest{Turns: turns, World: world}, res); err != nil {
+			b.Fatal(err)
+		}
+		if res.Turns != turns || broker.Turns != turns {
+			b.Fatalf("expected %v turns reported, got %v, with the broker at %v", turns, res.Turns, broker.Turns)
+		}
+		assertEqualWorld(b, res.World, expected)
+		computeTime += res.ComputeTime
+	}
+	b.ReportMetric(float64(turns*b.N)/computeTime.Seconds(), "turns/s")
+}
+
+// syncBuffer is a bytes.Buffer safe to use as the log output from several goroutines.
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
+}
+
+// TestSlowCallWarning checks calls to a slow worker are logged with the worker and the region,
+// and calls to a fast one are not.
+func TestSlowCallWarning(t *testing.T) {
+	var output syncBuffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	slow := startWorker(t, &slowWorker{delay: 100 * time.Millisecond})
+	fast := startWorker(t, &testWorker{})
+	b := newTestBroker(fast, slow)
+	b.SlowCall = 50 * time.Millisecond
+
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: randomWorld(16, 16, 10)}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	logged := output.String()
+	if !strings.Contains(logged, "WARN slow call: worker "+slow) || !strings.Contains(logged, "region [8, 16) of 16x8") {
+		t.Errorf("expected a warning for the slow worker, got %q", logged)
+	}
+	if strings.Contains(logged, "worker "+fast) {
+		t.Errorf("unexpected warning for the fast worker: %q", logged)
+	}
+}
+
+// TestObserver checks a client without the run's token can read the run but not control it.
+func TestObserver(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	done := make(chan error)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{Turns: 1000000, World: randomWorld(16, 16, 11), Token: "controller"}, new(BrokerProcessResponse))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := b.Quit(BrokerQuitRequest{}, new(BrokerQuitResponse)); err != ErrNotController {
+		t.Errorf("expected an observer's quit to be rejected, got %v", err)
+	}
+	if err := b.Pause(BrokerPauseRequest{Token: "guess"}, new(BrokerPauseResponse)); err != ErrNotController {
+		t.Errorf("expected an observer's pause to be rejected, got %v", err)
+	}
+	if err := b.Shutdown(BrokerShutdownRequest{}, new(BrokerShutdownResponse)); err != ErrNotController {
+		t.Errorf("expected an observer's shutdown to be rejected, got %v", err)
+	}
+
+	report := new(BrokerReportResponse)
+	if err := b.Report(BrokerReportRequest{}, report); err != nil {
+		t.Fatal(err)
+	}
+	world := new(BrokerGetWorldResponse)
+	if err := b.GetWorld(BrokerGetWorldRequest{}, world); err != nil {
+		t.Fatal(err)
+	}
+	if world.World.Height != 16 {
+		t.Errorf("expected the observer to read the 16 row board, got %d rows", world.World.Height)
+	}
+
+	if err := b.Quit(BrokerQuitRequest{Token: "controller"}, new(BrokerQuitResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDuplicateAddresses checks a worker listed twice is only given one region per turn.
+func TestDuplicateAddresses(t *testing.T) {
+	first, second := &testWorker{}, &testWorker{}
+	a, b := startWorker(t, first), startWorker(t, second)
+
+	addresses := uniqueAddresses([]string{a, b, a})
+	if len(addresses) != 2 || addresses[0] != a || addresses[1] != b {
+		t.Fatalf("expected [%v %v], got %v", a, b, addresses)
+	}
+
+	broker := newTestBroker(addresses...)
+	world := randomWorld(16, 16, 12)
+	res := new(BrokerProcessResponse)
+	if err := broker.Process(BrokerProcessRequest{Turns: 1, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 1))
+	if first.calls != 1 || second.calls != 1 {
+		t.Errorf("expected one region per worker, got %d and %d", first.calls, second.calls)
+	}
+}
+
+// startBroker serves b under the BrokerService name and returns its address.
+func startBroker(t *testing.T, b *BrokerService) string {
+	server := rpc.NewServer()
+	if err := server.RegisterName("BrokerService", b); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(listener)
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+func TestSplitTiling(t *testing.T) {
+	world := randomWorld(17, 8, 14)
+	region := world.region(1, 2)
+	for parts := 1; parts <= 5; parts++ {
+		var regions []Region
+		for part := 0; part < parts; part++ {
+			sub := region.split(part, parts)
+			if len(sub.Field) != sub.Height+2*DefaultHaloOffset {
+				t.Fatalf("%d parts: part %d has %d rows for a height of %d", parts, part, len(sub.Field), sub.Height)
+			}
+			regions = append(regions, sub)
+		}
+		AssertTiling(t, World{Height: region.Height}, regions)
+	}
+}
+
+// TestCoordinator splits a board across two brokers, each with its own workers, and checks
+// the result matches a single broker's.
+func TestCoordinator(t *testing.T) {
+	first := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	second := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	coordinator := newTestBroker(startBroker(t, first), startBroker(t, second))
+
+	world := randomWorld(32, 16, 15)
+	res := new(BrokerProcessResponse)
+	if err := coordinator.CoordinatorProcess(BrokerProcessRequest{Turns: 10, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+
+	single := newTestBroker(startWorker(t, &testWorker{}))
+	expected := new(BrokerProcessResponse)
+	if err := single.Process(BrokerProcessRequest{Turns: 10, World: world}, expected); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, expected.World)
+	assertEqualWorld(t, res.World, evolve(world, 10))
+}
+
+// TestCoordinatorBandsKept checks a coordinator's brokers keep their bands between turns, so a
+// long run exchanges little more with them than a single turn, which sends the board out and
+// pulls it back, and still matches the serial result.
+func TestCoordinatorBandsKept(t *testing.T) {
+	first := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	second := newTestBroker(startWorker(t, &testWorker{}))
+	coordinator := newTestBroker(startBroker(t, first), startBroker(t, second))
+	coordinator.Debug = false
+
+	world := randomWorld(256, 32, 16)
+	single := new(BrokerProcessResponse)
+	if err := coordinator.CoordinatorProcess(BrokerProcessRequest{Turns: 1, World: world}, single); err != nil {
+		t.Fatal(err)
+	}
+	res := new(BrokerProcessResponse)
+	if err := coordinator.CoordinatorProcess(BrokerProcessRequest{Turns: 20, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 20))
+	if res.Bytes >= 2*single.Bytes {
+		t.Errorf("expected 20 turns to exchange less than twice the %d bytes of one, got %d", single.Bytes, res.Bytes)
+	}
+}
+
+// TestCoordinatorShortBands hands brokers bands with fewer rows than they have workers, and
+// checks they leave the extra workers idle rather than sending them empty regions.
+func TestCoordinatorShortBands(t *testing.T) {
+	var workers []string
+	for i := 0; i < 5; i++ {
+		workers = append(workers, startWorker(t, &testWorker{}))
+	}
+	coordinator := newTestBroker(startBroker(t, newTestBroker(workers[:4]...)), startBroker(t, newTestBroker(workers...)))
+
+	world := randomWorld(6, 8, 17)
+	res := new(BrokerProcessResponse)
+	if err := coordinator.CoordinatorProcess(BrokerProcessRequest{Turns: 5, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 5))
+}
+
+// TestCoordinatorBandModes checks a broker refuses a band answered with just its edges that it
+// would not keep, a kept band not answered with its edges, and halos for a band it does not
+// keep, rather than computing from rows it does not have.
+func TestCoordinatorBandModes(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	world := randomWorld(8, 8, 18)
+	band := world.region(0, 1)
+	for _, test := range []struct {
+		request WorkerProcessRequest
+		err     error
+	}{
+		{WorkerProcessRequest{Region: band, Run: 1, EdgesOnly: true}, ErrEdgesNotResident},
+		{WorkerProcessRequest{Region: band, Run: 1, Resident: true}, ErrEdgesNotResident},
+		{WorkerProcessRequest{Region: band.halos(), Run: 1, HaloOnly: true}, ErrNoBand},
+		{WorkerProcessRequest{Region: band.halos(), Run: 1, HaloOnly: true, Resident: true, EdgesOnly: true}, ErrNoBand},
+	} {
+		if err := b.ProcessRegion(test.request, new(WorkerProcessResponse)); err != test.err {
+			t.Errorf("resident=%v, halo-only=%v, edges-only=%v: expected %v, got %v", test.request.Resident, test.request.HaloOnly, test.request.EdgesOnly, test.err, err)
+		}
+	}
+}
+
+// TestRegionHeightMismatch checks a region returned short is treated as a failed call rather
+// than reassembled into the board.
+func TestRegionHeightMismatch(t *testing.T) {
+	world := randomWorld(16, 16, 16)
+	b := newTestBroker(startWorker(t, &truncatingWorker{}), startWorker(t, &testWorker{}))
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, new(BrokerProcessResponse)); err != ErrRetriesExhausted {
+		t.Fatalf("expected ErrRetriesExhausted, got %v", err)
+	}
+
+	b = newTestBroker(startWorker(t, &truncatingWorker{}), startWorker(t, &testWorker{}))
+	b.RetryBudget = 1
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 1))
+}
+
+// TestWaitStateChange pauses a run, checks a long-poll on the paused state waits out its
+// timeout, then resumes the run and checks a waiting long-poll returns straight away.
+func TestWaitStateChange(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	done := make(chan error)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{Turns: 1000000, World: randomWorld(16, 16, 17)}, new(BrokerProcessResponse))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := b.Pause(BrokerPauseRequest{}, new(BrokerPauseResponse)); err != nil {
+		t.Fatal(err)
+	}
+	paused := new(BrokerWaitStateChangeResponse)
+	if err := b.WaitStateChange(BrokerWaitStateChangeRequest{}, paused); err != nil {
+		t.Fatal(err)
+	}
+	if !paused.IsPaused || !paused.Running {
+		t.Fatalf("expected a paused run, got %+v", paused)
+	}
+
+	unchanged := new(BrokerWaitStateChangeResponse)
+	if err := b.WaitStateChange(BrokerWaitStateChangeRequest{Since: paused.Seq, Timeout: 50 * time.Millisecond}, unchanged); err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.Seq != paused.Seq {
+		t.Errorf("expected no change while paused, went from %d to %d", paused.Seq, unchanged.Seq)
+	}
+
+	resumed := make(chan *BrokerWaitStateChangeResponse)
+	go func() {
+		res := new(BrokerWaitStateChangeResponse)
+		b.WaitStateChange(BrokerWaitStateChangeRequest{Since: paused.Seq, Timeout: 10 * time.Second}, res)
+		resumed <- res
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := b.Pause(BrokerPauseRequest{}, new(BrokerPauseResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-resumed:
+		if res.Seq == paused.Seq || res.IsPaused {
+			t.Errorf("expected a new resumed state, got %+v", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("long-poll did not return when the run was resumed")
+	}
+
+	b.Quit(BrokerQuitRequest{}, new(BrokerQuitResponse))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestPauseToggles toggles pause ten times in a tight loop, from several goroutines at once,
+// and checks the run ends up running and keeps advancing, then that one more toggle holds it.
+func TestPauseToggles(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	done := make(chan error)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{Turns: 1000000, World: randomWorld(16, 16, 23)}, new(BrokerProcessResponse))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.Pause(BrokerPauseRequest{}, new(BrokerPauseResponse)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	before := new(BrokerWaitStateChangeResponse)
+	if err := b.WaitStateChange(BrokerWaitStateChangeRequest{}, before); err != nil {
+		t.Fatal(err)
+	}
+	if before.IsPaused {
+		t.Fatal("expected ten toggles to leave the run running")
+	}
+	time.Sleep(50 * time.Millisecond)
+	after := new(BrokerWaitStateChangeResponse)
+	if err := b.WaitStateChange(BrokerWaitStateChangeRequest{}, after); err != nil {
+		t.Fatal(err)
+	}
+	if after.Turns <= before.Turns {
+		t.Fatalf("expected the run to keep advancing from turn %d, got turn %d", before.Turns, after.Turns)
+	}
+
+	paused := new(BrokerPauseResponse)
+	if err := b.Pause(BrokerPauseRequest{}, paused); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	held := new(BrokerWaitStateChangeResponse)
+	if err := b.WaitStateChange(BrokerWaitStateChangeRequest{}, held); err != nil {
+		t.Fatal(err)
+	}
+	if !paused.IsPaused || held.Turns != paused.Turns {
+		t.Errorf("expected the run held at turn %d, got %+v", paused.Turns, held)
+	}
+
+	b.Quit(BrokerQuitRequest{}, new(BrokerQuitResponse))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStep checks Step does nothing to a running run, and computes exactly one turn of a
+// paused one, answering with its board and the cells it flipped.
+func TestStep(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	seed := randomWorld(16, 16, 60)
+	done := make(chan error)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{Turns: 1000000, World: seed, Token: "a"}, new(BrokerProcessResponse))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	running := new(BrokerStepResponse)
+	if err := b.Step(BrokerStepRequest{Token: "a"}, running); err != nil {
+		t.Fatal(err)
+	}
+	if running.Stepped {
+		t.Error("expected no step while the run is running")
+	}
+
+	paused := new(BrokerPauseResponse)
+	if err := b.Pause(BrokerPauseRequest{Token: "a"}, paused); err != nil {
+		t.Fatal(err)
+	}
+	b.Flips(BrokerFlipsRequest{}, new(BrokerFlipsResponse))
+	before := evolve(seed, int(paused.Turns))
+	for i := int64(1); i <= 3; i++ {
+		res := new(BrokerStepResponse)
+		if err := b.Step(BrokerStepRequest{Token: "a"}, res); err != nil {
+			t.Fatal(err)
+		}
+		if !res.Stepped || res.Turns != paused.Turns+i {
+			t.Fatalf("expected a step to turn %v, got %v stepped %v", paused.Turns+i, res.Turns, res.Stepped)
+		}
+		after := evolve(before, 1)
+		assertEqualWorld(t, res.World, after)
+		flipped := 0
+		for y := range after.Field.Data {
+			for x := range after.Field.Data[y] {
+				if after.Field.Data[y][x].Alive != before.Field.Data[y][x].Alive {
+					flipped++
+				}
+			}
+		}
+		if len(res.Cells) != flipped || res.CellsCount != len(after.alive()) {
+			t.Errorf("expected %v flips and %v alive, got %v and %v", flipped, len(after.alive()), len(res.Cells), res.CellsCount)
+		}
+		before = after
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	held := new(BrokerWaitStateChangeResponse)
+	if err := b.WaitStateChange(BrokerWaitStateChangeRequest{}, held); err != nil {
+		t.Fatal(err)
+	}
+	if !held.IsPaused || held.Turns != paused.Turns+3 {
+		t.Errorf("expected the run still paused at turn %v, got %+v", paused.Turns+3, held)
+	}
+	if err := b.Step(BrokerStepRequest{Token: "b"}, new(BrokerStepResponse)); err != ErrNotController {
+		t.Errorf("expected ErrNotController stepping with another token, got %v", err)
+	}
+
+	b.Quit(BrokerQuitRequest{Token: "a"}, new(BrokerQuitResponse))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serial computes the world after the given number of turns directly on the torus, or within
+// a Fixed boundary, without any regions or halos, as the reference the distributed path is checked against.
+func serial(world World, turns int) World {
+	height, width := world.Height, world.Width
+	cells := make([][]bool, height)
+	for y := range cells {
+		cells[y] = make([]bool, width)
+		for x := range cells[y] {
+			cells[y][x] = world.Field.Data[y][x].Alive
+		}
+	}
+	for turn := 0; turn < turns; turn++ {
+		next := make([][]bool, height)
+		for y := range next {
+			next[y] = make([]bool, width)
+			for x := range next[y] {
+				neighbours := 0
+				for j := -1; j <= 1; j++ {
+					for i := -1; i <= 1; i++ {
+						wy, wx := (y+j+height)%height, (x+i+width)%width
+						if world.boundary == Fixed && (wy != y+j || wx != x+i) {
+							continue
+						}
+						if (i != 0 || j != 0) && cells[wy][wx] {
+							neighbours++
+						}
+					}
+				}
+				next[y][x] = neighbours == 3 || (cells[y][x] && neighbours == 2)
+			}
+		}
+		cells = next
+	}
+
+	result := World{Field: Field{Data: make([][]Cell, height), Height: height, Width: width}, Height: height, Width: width}
+	for y := range cells {
+		result.Field.Data[y] = make([]Cell, width)
+		for x, alive := range cells[y] {
+			result.Field.Data[y][x] = Cell{X: x, Y: y, Alive: alive}
+		}
+	}
+	return result
+}
+
+// TestFixedBoundary runs a glider across an 8x8 board, where it wraps back to where it started
+// after 32 turns on a torus but crashes into the edge of a Fixed board, with the broker's
+// regions both sent every turn and kept resident on the workers.
+func TestFixedBoundary(t *testing.T) {
+	glider := randomWorld(8, 8, 0)
+	for y := range glider.Field.Data {
+		for x := range glider.Field.Data[y] {
+			glider.Field.Data[y][x].Alive = false
+		}
+	}
+	for _, cell := range []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}} {
+		glider.Field.Data[cell.Y][cell.X].Alive = true
+	}
+	fixed := glider
+	fixed.boundary = Fixed
+	expected := serial(fixed, 32)
+	if len(expected.alive()) == 0 || len(expected.alive()) == 5 {
+		t.Fatalf("expected the glider to leave debris at the edge, got %v", expected.alive())
+	}
+
+	peers := make(map[string]*residentWorker)
+	var stepping, resident []string
+	for i := 0; i < 3; i++ {
+		stepping = append(stepping, startWorker(t, &testWorker{}))
+		worker := &residentWorker{peers: peers}
+		address := startWorker(t, worker)
+		peers[address] = worker
+		resident = append(resident, address)
+	}
+	for _, addresses := range [][]string{stepping, resident} {
+		b := newTestBroker(addresses...)
+		b.Resident = addresses[0] == resident[0]
+		b.VerifyAssembly = true
+
+		res := new(BrokerProcessResponse)
+		if err := b.Process(BrokerProcessRequest{Turns: 32, World: glider}, res); err != nil {
+			t.Fatal(err)
+		}
+		assertEqualWorld(t, res.World, glider)
+
+		if err := b.Process(BrokerProcessRequest{Turns: 32, World: glider, Boundary: Fixed}, res); err != nil {
+			t.Fatal(err)
+		}
+		assertEqualWorld(t, res.World, expected)
+	}
+}
+
+// TestDistributedMatchesSerial runs a seeded sequence of random boards, turn counts and worker
+// counts through the broker and compares each with the serial reference. A failure names its
+// seed, which reproduces it with -fuzz.seed=<seed> -fuzz.iterations=1.
+func TestDistributedMatchesSerial(t *testing.T) {
+	var addresses []string
+	for i := 0; i < 6; i++ {
+		addresses = append(addresses, startWorker(t, &cachingWorker{}))
+	}
+
+	for seed := *fuzzSeed; seed < *fuzzSeed+int64(*fuzzIterations); seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		height, width := 1+rng.Intn(40), 1+rng.Intn(40)
+		turns := rng.Intn(10)
+		numWorkers := 1 + rng.Intn(len(addresses))
+		world := randomWorld(height, width, seed)
+
+		b := newTestBroker(addresses[:numWorkers]...)
+		b.HaloOnly = rng.Intn(2) == 0
+		res := new(BrokerProcessResponse)
+		if err := b.Process(BrokerProcessRequest{Turns: int64(turns), World: world}, res); err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+
+		expected := serial(world, turns)
+		for y := range expected.Field.Data {
+			for x := range expected.Field.Data[y] {
+				if res.World.Field.Data[y][x].Alive != expected.Field.Data[y][x].Alive {
+					t.Fatalf("seed %d: %dx%d board, %d turns, %d workers: cell (%d, %d) differs from the serial result",
+						seed, width, height, turns, numWorkers, x, y)
+				}
+			}
+		}
+	}
+}
+
+// TestNonSquareBoards runs 2 turns of tall and wide boards on 3 workers, split into strips
+// and into a grid, with regions packed and not, and compares each with the serial reference.
+func TestNonSquareBoards(t *testing.T) {
+	var addresses []string
+	for i := 0; i < 3; i++ {
+		addresses = append(addresses, startWorker(t, &testWorker{}))
+	}
+	for _, shape := range [][2]int{{64, 128}, {128, 64}, {512, 256}} {
+		width, height := shape[0], shape[1]
+		world := randomWorld(height, width, int64(width+height))
+		expected := serial(world, 2)
+		for _, decomposition := range []Decomposition{Strips, Grid} {
+			for _, pack := range []bool{false, true} {
+				b := newTestBroker(addresses...)
+				b.Decomposition = decomposition
+				b.PackRegions = pack
+				res := new(BrokerProcessResponse)
+				if err := b.Process(BrokerProcessRequest{Turns: 2, World: world}, res); err != nil {
+					t.Fatalf("%dx%d %v: %v", width, height, decomposition, err)
+				}
+				if res.World.Height != height || res.World.Width != width || len(res.World.Field.Data) != height || len(res.World.Field.Data[0]) != width {
+					t.Fatalf("%dx%d %v: came back %dx%d", width, height, decomposition, res.World.Width, res.World.Height)
+				}
+				assertEqualWorld(t, res.World, expected)
+			}
+		}
+	}
+}
+
+// TestRegions2DHalos checks every tile's halo, corners included, holds the cells around it on
+// the torus, and that the tiles cover the board.
+func TestRegions2DHalos(t *testing.T) {
+	world := randomWorld(17, 13, 18)
+	for _, grid := range [][2]int{{1, 1}, {2, 2}, {3, 2}, {3, 4}} {
+		covered := make(map[[2]int]int)
+		for _, region := range world.regions2D(grid[0], grid[1]) {
+			for y := region.Start; y < region.End; y++ {
+				for x := region.StartX; x < region.EndX; x++ {
+					covered[[2]int{x, y}]++
+				}
+			}
+			for fy, row := range region.Field {
+				for fx, cell := range row {
+					wy := (region.Start - 1 + fy + world.Height) % world.Height
+					wx := (region.StartX - 1 + fx + world.Width) % world.Width
+					if cell != world.Field.Data[wy][wx] {
+						t.Fatalf("%dx%d grid: tile at (%d, %d) has %v at (%d, %d), expected the cell at (%d, %d)",
+							grid[0], grid[1], region.StartX, region.Start, cell, fx, fy, wx, wy)
+					}
+				}
+			}
+		}
+		for y := 0; y < world.Height; y++ {
+			for x := 0; x < world.Width; x++ {
+				if covered[[2]int{x, y}] != 1 {
+					t.Fatalf("%dx%d grid: cell (%d, %d) is covered by %d tiles", grid[0], grid[1], x, y, covered[[2]int{x, y}])
+				}
+			}
+		}
+	}
+}
+
+// TestGridCorners places gliders across a corner shared by four tiles and across the corner
+// of the board, where the diagonal neighbours wrap or, with a Fixed boundary, are dead, and
+// checks a grid decomposition evolves them as the serial reference does.
+func TestGridCorners(t *testing.T) {
+	world := randomWorld(16, 16, 0)
+	for y := range world.Field.Data {
+		for x := range world.Field.Data[y] {
+			world.Field.Data[y][x].Alive = false
+		}
+	}
+	for _, corner := range [][2]int{{8, 8}, {0, 0}} {
+		for _, offset := range [][2]int{{0, -1}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}} {
+			x := (corner[0] + offset[0] + 16) % 16
+			y := (corner[1] + offset[1] + 16) % 16
+			world.Field.Data[y][x].Alive = true
+		}
+	}
+
+	d := dispatch{addresses: []string{startWorker(t, &testWorker{}), startWorker(t, &testWorker{})}}
+	for _, boundary := range []Boundary{Toroidal, Fixed} {
+		world.boundary = boundary
+		for _, grid := range [][2]int{{2, 2}, {4, 4}, {3, 5}} {
+			given := world
+			for turn := 1; turn <= 8; turn++ {
+				if _, err := given.compute(d, given.regions2D(grid[0], grid[1]), int64(turn)); err != nil {
+					t.Fatal(err)
+				}
+				expected := serial(world, turn)
+				for y := range expected.Field.Data {
+					for x := range expected.Field.Data[y] {
+						if given.Field.Data[y][x].Alive != expected.Field.Data[y][x].Alive {
+							t.Fatalf("%v, %dx%d grid, turn %d: cell (%d, %d) differs from the serial result", boundary, grid[0], grid[1], turn, x, y)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestGridDecomposition checks a run tiling the board into a grid ends with the same board as
+// one splitting it into strips, for every worker count up to 6 and both boundaries.
+func TestGridDecomposition(t *testing.T) {
+	var addresses []string
+	for i := 0; i < 6; i++ {
+		addresses = append(addresses, startWorker(t, &testWorker{}))
+	}
+	world := randomWorld(18, 30, 31)
+	for workers := 1; workers <= len(addresses); workers++ {
+		for _, boundary := range []Boundary{Toroidal, Fixed} {
+			request := BrokerProcessRequest{Turns: 12, World: world, Boundary: boundary}
+			strips := newTestBroker(addresses[:workers]...)
+			expected := new(BrokerProcessResponse)
+			if err := strips.Process(request, expected); err != nil {
+				t.Fatal(err)
+			}
+
+			grid := newTestBroker(addresses[:workers]...)
+			grid.Decomposition = Grid
+			given := new(BrokerProcessResponse)
+			if err := grid.Process(request, given); err != nil {
+				t.Fatal(err)
+			}
+			assertEqualWorld(t, given.World, expected.World)
+			if len(grid.regionCounts) != workers {
+				t.Errorf("%d workers: expected %d tiles, got %d", workers, workers, len(grid.regionCounts))
+			}
+		}
+	}
+
+	// A 4 worker grid of a wide board is 1x4, of a square one 2x2, and 5 cannot tile 3x3.
+	for _, shape := range [][5]int{{4, 10, 100, 1, 4}, {4, 30, 30, 2, 2}, {6, 90, 30, 3, 2}} {
+		if rows, cols, ok := gridShape(shape[0], shape[1], shape[2]); !ok || rows != shape[3] || cols != shape[4] {
+			t.Errorf("%d tiles of %dx%d: expected %dx%d, got %dx%d", shape[0], shape[2], shape[1], shape[3], shape[4], rows, cols)
+		}
+	}
+	if _, _, ok := gridShape(5, 3, 3); ok {
+		t.Error("expected 5 tiles not to fit a 3x3 board")
+	}
+}
+
+// shutdownWorker is a testWorker that counts the shutdown requests it receives.
+type shutdownWorker struct {
+	testWorker
+	shutdowns int32
+}
+
+func (w *shutdownWorker) Shutdown(req WorkerShutdownRequest, res *WorkerShutdownResponse) (err error) {
+	atomic.AddInt32(&w.shutdowns, 1)
+	return
+}
+
+// TestIdleTimeout checks the broker stays up through a run and a stream of RPCs lasting
+// longer than the idle timeout, then shuts itself and its workers down once left alone.
+func TestIdleTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	worker := &shutdownWorker{}
+	slow := &slowWorker{delay: 2 * timeout}
+	b := newTestBroker(startWorker(t, worker), startWorker(t, slow))
+	b.IdleTimeout = timeout
+	go b.watchIdle()
+
+	// A single turn on the slow worker keeps a run in progress past the timeout.
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: randomWorld(16, 16, 19)}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		select {
+		case <-b.shutdown:
+			t.Fatal("shut down while active")
+		case <-time.After(timeout / 2):
+		}
+		b.Report(BrokerReportRequest{}, new(BrokerReportResponse))
+	}
+
+	select {
+	case <-b.shutdown:
+	case <-time.After(10 * timeout):
+		t.Fatal("still running after the idle timeout")
+	}
+	if shutdowns := atomic.LoadInt32(&worker.shutdowns); shutdowns != 1 {
+		t.Errorf("expected the worker to be shut down once, got %d", shutdowns)
+	}
+}
+
+// TestShutdownTwice shuts the broker down through the idle timeout and then Shutdown, with
+// main never receiving, and checks neither blocks.
+func TestShutdownTwice(t *testing.T) {
+	b := newTestBroker(startWorker(t, &shutdownWorker{}))
+	b.IdleTimeout = time.Millisecond
+	idled := make(chan struct{})
+	go func() {
+		b.watchIdle()
+		close(idled)
+	}()
+
+	shut := make(chan error, 1)
+	go func() { shut <- b.Shutdown(BrokerShutdownRequest{}, new(BrokerShutdownResponse)) }()
+	select {
+	case <-idled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the idle timeout blocked shutting the broker down")
+	}
+	select {
+	case err := <-shut:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown blocked after the idle timeout shut the broker down")
+	}
+	<-b.shutdown
+}
+
+// TestFlips runs a blinker, whose cells flip back every other turn, and checks Flips returns
+// the changed cells and the turn they bring the board up to, with cells that changed back
+// over the turns since the last call left out.
+func TestFlips(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	world := randomWorld(6, 6, 0)
+	for y := range world.Field.Data {
+		for x := range world.Field.Data[y] {
+			world.Field.Data[y][x].Alive = y == 2 && x >= 1 && x <= 3
+		}
+	}
+
+	for _, test := range []struct {
+		turns    int64
+		expected []util.Cell
+	}{
+		{1, []util.Cell{{X: 1, Y: 2}, {X: 2, Y: 1}, {X: 2, Y: 3}, {X: 3, Y: 2}}},
+		{2, []util.Cell{}},
+		{3, []util.Cell{{X: 1, Y: 2}, {X: 2, Y: 1}, {X: 2, Y: 3}, {X: 3, Y: 2}}},
+	} {
+		if err := b.Process(BrokerProcessRequest{Turns: test.turns, World: world}, new(BrokerProcessResponse)); err != nil {
+			t.Fatal(err)
+		}
+		res := new(BrokerFlipsResponse)
+		if err := b.Flips(BrokerFlipsRequest{}, res); err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(res.Cells, func(i, j int) bool {
+			return res.Cells[i].X < res.Cells[j].X || res.Cells[i].X == res.Cells[j].X && res.Cells[i].Y < res.Cells[j].Y
+		})
+		if res.Turns != test.turns || res.CellsCount != 3 || !reflect.DeepEqual(res.Cells, test.expected) {
+			t.Errorf("%d turns: expected %v flipped at turn %d with 3 alive, got %v at turn %d with %d alive",
+				test.turns, test.expected, test.turns, res.Cells, res.Turns, res.CellsCount)
+		}
+
+		again := new(BrokerFlipsResponse)
+		if err := b.Flips(BrokerFlipsRequest{}, again); err != nil {
+			t.Fatal(err)
+		}
+		if len(again.Cells) != 0 {
+			t.Errorf("%d turns: expected no flips on the next call, got %v", test.turns, again.Cells)
+		}
+	}
+}
+
+// TestRegionCounts checks the per-region alive counts match the regions of the final board
+// and add up to the total alive count.
+func TestRegionCounts(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 5, World: randomWorld(20, 12, 20)}, res); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := new(BrokerRegionCountsResponse)
+	if err := b.RegionCounts(BrokerRegionCountsRequest{}, counts); err != nil {
+		t.Fatal(err)
+	}
+	if counts.Turns != 5 || len(counts.Counts) != 3 {
+		t.Fatalf("expected 3 counts on turn 5, got %v on turn %d", counts.Counts, counts.Turns)
+	}
+	total := 0
+	for part, count := range counts.Counts {
+		region := res.World.region(part, len(counts.Counts))
+		alive := 0
+		for _, row := range interior(region).Field {
+			alive += len(aliveCellsInRow(row, 0))
+		}
+		if count != alive {
+			t.Errorf("region %d: counted %d alive cells, expected %d", part, count, alive)
+		}
+		total += count
+	}
+	if total != len(res.World.alive()) {
+		t.Errorf("region counts add up to %d, expected %d", total, len(res.World.alive()))
+	}
+}
+
+// TestGetThumbnail checks the thumbnail sizes for the distributor's zoom levels on a board
+// that does not divide evenly, and that a block's shade is the share of its cells alive.
+func TestGetThumbnail(t *testing.T) {
+	b := newTestBroker()
+	b.World = randomWorld(300, 200, 21)
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 5; x++ {
+			b.World.Field.Data[y][x].Alive = y == 0
+		}
+	}
+
+	for _, level := range []struct{ maxDim, height, width int }{
+		{64, 60, 40},
+		{128, 100, 67},
+		{256, 150, 100},
+		{512, 300, 200},
+	} {
+		res := new(BrokerGetThumbnailResponse)
+		if err := b.GetThumbnail(BrokerGetThumbnailRequest{MaxDim: level.maxDim}, res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Image) != level.height || len(res.Image[0]) != level.width {
+			t.Errorf("max %d: expected a %dx%d thumbnail, got %dx%d",
+				level.maxDim, level.width, level.height, len(res.Image[0]), len(res.Image))
+		}
+		if level.maxDim == 64 && res.Image[0][0] != 51 {
+			t.Errorf("max %d: a block with a fifth of its cells alive has shade %d", level.maxDim, res.Image[0][0])
+		}
+	}
+
+	if err := b.GetThumbnail(BrokerGetThumbnailRequest{}, new(BrokerGetThumbnailResponse)); err == nil {
+		t.Error("expected an error for a zero thumbnail size")
+	}
+}
+
+// TestHaloRowsReturned checks regions returned with their halo rows are rejected with an error
+// naming the worker, rather than having the halos spliced into the board.
+func TestHaloRowsReturned(t *testing.T) {
+	var output syncBuffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	world := randomWorld(16, 16, 22)
+	for _, setHeight := range []bool{false, true} {
+		worker := startWorker(t, &haloWorker{setHeight: setHeight})
+		b := newTestBroker(worker)
+		if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, new(BrokerProcessResponse)); err != ErrRetriesExhausted {
+			t.Fatalf("expected ErrRetriesExhausted, got %v", err)
+		}
+		if logged := output.String(); !strings.Contains(logged, "worker "+worker+" returned region [0, 16) with its halo rows") {
+			t.Errorf("expected the halo rows to be reported, got %q", logged)
+		}
+
+		b = newTestBroker(worker, startWorker(t, &testWorker{}))
+		b.RetryBudget = 2
+		res := new(BrokerProcessResponse)
+		if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, res); err != nil {
+			t.Fatal(err)
+		}
+		assertEqualWorld(t, res.World, evolve(world, 1))
+	}
+}
+
+// TestDumpTurn checks the regions sent for the target turn are dumped, one per worker, with
+// their halo rows, and that no other turn is.
+func TestDumpTurn(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	workers := []string{startWorker(t, &testWorker{}), startWorker(t, &testWorker{})}
+	b := newTestBroker(workers...)
+	b.DumpTurn = 3
+	world := randomWorld(16, 12, 23)
+	if err := b.Process(BrokerProcessRequest{Turns: 5, World: world}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	dumps, err := filepath.Glob("out/*.pgm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumps) != len(workers) {
+		t.Fatalf("expected a dump for each of %d workers, got %v", len(workers), dumps)
+	}
+
+	input := evolve(world, 2)
+	for part, worker := range workers {
+		region := input.region(part, len(workers))
+		filename := fmt.Sprintf("out/broker-turn-3-worker-%v-region-%v.pgm", strings.Replace(worker, ":", "-", -1), region.Start)
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		image, err := util.ReadPgm(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(image) != len(region.Field) {
+			t.Fatalf("%v: expected %d rows, got %d", filename, len(region.Field), len(image))
+		}
+		for y, row := range region.Field {
+			for x, cell := range row {
+				if (image[y][x] == 255) != cell.Alive {
+					t.Fatalf("%v: cell (%d, %d) differs from the region sent", filename, x, y)
+				}
+			}
+		}
+	}
+}
+
+// TestQuitMidTurn quits and shuts down runs while a slow turn is being computed, and checks the
+// world returned is exactly the last completed turn on both paths.
+func TestQuitMidTurn(t *testing.T) {
+	world := randomWorld(16, 16, 25)
+	for _, shutdown := range []bool{false, true} {
+		b := newTestBroker(startWorker(t, &slowWorker{delay: 40 * time.Millisecond}))
+		done := make(chan *BrokerProcessResponse)
+		go func() {
+			res := new(BrokerProcessResponse)
+			if err := b.Process(BrokerProcessRequest{Turns: 1000, World: world}, res); err != nil {
+				t.Error(err)
+			}
+			done <- res
+		}()
+		// Part way through the third turn.
+		time.Sleep(100 * time.Millisecond)
+
+		var turns int64
+		var saved World
+		if shutdown {
+			res := new(BrokerShutdownResponse)
+			if err := b.Shutdown(BrokerShutdownRequest{}, res); err != nil {
+				t.Fatal(err)
+			}
+			turns, saved = res.Turns, res.World
+		} else {
+			res := new(BrokerQuitResponse)
+			if err := b.Quit(BrokerQuitRequest{}, res); err != nil {
+				t.Fatal(err)
+			}
+			turns = res.Turns
+		}
+
+		processed := <-done
+		if processed.Turns != turns || turns < 2 {
+			t.Fatalf("shutdown=%v: stopped at turn %d, process returned turn %d", shutdown, turns, processed.Turns)
+		}
+		assertEqualWorld(t, processed.World, evolve(world, int(turns)))
+		if shutdown {
+			assertEqualWorld(t, saved, evolve(world, int(turns)))
+		}
+	}
+}
+
+// TestQuitAnyTime calls Quit twice each before any run, during one and after one has finished,
+// and checks every call returns promptly with the turn reached.
+func TestQuitAnyTime(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	world := randomWorld(16, 16, 63)
+	quit := func(when, token string) int64 {
+		t.Helper()
+		res := new(BrokerQuitResponse)
+		errs := make(chan error, 1)
+		go func() { errs <- b.Quit(BrokerQuitRequest{Token: token}, res) }()
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("%v: %v", when, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: Quit blocked", when)
+		}
+		return res.Turns
+	}
+
+	for i := 0; i < 2; i++ {
+		if turns := quit("before a run", ""); turns != 0 {
+			t.Errorf("expected no turns quitting before a run, got %v", turns)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{Turns: 1000000, World: world, Token: "a"}, new(BrokerProcessResponse))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if turns := quit("during a run", "a"); turns < 1 {
+		t.Errorf("expected the turns reached quitting during a run, got %v", turns)
+	}
+	quit("during a run, again", "a")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Process(BrokerProcessRequest{Turns: 5, World: world, Token: "a"}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if turns := quit("after a run", "a"); turns != 5 {
+		t.Errorf("expected the finished run's 5 turns, got %v", turns)
+	}
+	if turns := quit("after a run, again", "a"); turns != 0 {
+		t.Errorf("expected no turns once the run was quit, got %v", turns)
+	}
+}
+
+// swappingWorker is a testWorker that computes its region correctly but labels it as the
+// next region down the board, so the broker assembles the right rows in the wrong place.
+type swappingWorker struct {
+	testWorker
+	boardHeight int
+}
+
+func (w *swappingWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	if err = w.testWorker.Process(req, res); err == nil {
+		res.Region.Start = (req.Region.Start + req.Region.Height) % w.boardHeight
+		res.Region.End = res.Region.Start + req.Region.Height
+	}
+	return
+}
+
+// TestVerifyAssembly checks the serial check passes on a correct run and fails on the first
+// turn when the regions are assembled in swapped positions.
+func TestVerifyAssembly(t *testing.T) {
+	world := randomWorld(16, 16, 29)
+
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	b.VerifyAssembly = true
+	if err := b.Process(BrokerProcessRequest{Turns: 10, World: world}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	swapping := startWorker(t, &swappingWorker{boardHeight: 16})
+	b = newTestBroker(swapping, swapping)
+	b.VerifyAssembly = true
+	err := b.Process(BrokerProcessRequest{Turns: 10, World: world}, new(BrokerProcessResponse))
+	if err == nil || !strings.HasPrefix(err.Error(), "turn 1: assembled cell") {
+		t.Fatalf("expected the swapped assembly to fail on turn 1, got %v", err)
+	}
+}
+
+// TestRule checks a HighLife run computes what the serial check expects of B36/S23, differing
+// from Conway's, and that a rule with impossible neighbour counts is rejected.
+func TestRule(t *testing.T) {
+	world := randomWorld(16, 16, 30)
+	highLife := Rule{Birth: []int{3, 6}, Survival: []int{2, 3}}
+
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	b.VerifyAssembly = true
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 10, World: world, Rule: highLife}, res); err != nil {
+		t.Fatal(err)
+	}
+	conway := evolve(world, 10)
+	if reflect.DeepEqual(res.World.alive(), conway.alive()) {
+		t.Error("expected HighLife to leave a different board from Conway's rule")
+	}
+
+	err := b.Process(BrokerProcessRequest{Turns: 1, World: world, Rule: Rule{Birth: []int{9}}}, res)
+	if err == nil || !strings.Contains(err.Error(), "invalid neighbour count 9") {
+		t.Errorf("expected the rule B9/S to be rejected, got %v", err)
+	}
+}
+
+// TestContinue runs two Process calls in a row, checking the second starts afresh from its own
+// world by default and carries on from the first's board and turn count in continue mode.
+func TestContinue(t *testing.T) {
+	first, second := randomWorld(16, 16, 30), randomWorld(16, 16, 31)
+	for _, continued := range []bool{false, true} {
+		b := newTestBroker(startWorker(t, &testWorker{}))
+		b.Continue = continued
+		if err := b.Process(BrokerProcessRequest{Turns: 3, World: first}, new(BrokerProcessResponse)); err != nil {
+			t.Fatal(err)
+		}
+		res := new(BrokerProcessResponse)
+		if err := b.Process(BrokerProcessRequest{Turns: 2, World: second}, res); err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Turns != 2 {
+			t.Errorf("continue=%v: expected the second call to count its 2 turns, got %d", continued, res.Turns)
+		}
+		if continued {
+			assertEqualWorld(t, res.World, evolve(first, 5))
+			if b.Turns != 5 {
+				t.Errorf("expected the broker to reach turn 5, got %d", b.Turns)
+			}
+		} else {
+			assertEqualWorld(t, res.World, evolve(second, 2))
+			if b.Turns != 2 {
+				t.Errorf("expected the broker to restart at turn 0 and reach 2, got %d", b.Turns)
+			}
+		}
+	}
+}
+
+// capableWorker is a testWorker that advertises a benchmark score and records the height of
+// every region it computes and how many times it is asked for its Status.
+type capableWorker struct {
+	testWorker
+	score    float64
+	statuses int32
+	mu       sync.Mutex
+	heights  []int
+}
+
+func (w *capableWorker) Status(req WorkerStatusRequest, res *WorkerStatusResponse) (err error) {
+	atomic.AddInt32(&w.statuses, 1)
+	w.testWorker.Status(req, res)
+	res.CPUs = 1
+	res.Score = w.score
+	return
+}
+
+func (w *capableWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	w.mu.Lock()
+	w.heights = append(w.heights, req.Region.Height)
+	w.mu.Unlock()
+	return w.testWorker.Process(req, res)
+}
+
+// TestCapabilityWeights checks workers get strips in proportion to the scores they advertise,
+// and that the board is split evenly when one of them advertises none.
+func TestCapabilityWeights(t *testing.T) {
+	world := randomWorld(40, 16, 32)
+	for _, scores := range [][]float64{{1, 2, 1, 4}, {1, 2, 0, 4}} {
+		var workers []*capableWorker
+		var addresses []string
+		for _, score := range scores {
+			worker := &capableWorker{score: score}
+			workers = append(workers, worker)
+			addresses = append(addresses, startWorker(t, worker))
+		}
+		b := newTestBroker(addresses...)
+		res := new(BrokerProcessResponse)
+		if err := b.Process(BrokerProcessRequest{Turns: 2, World: world}, res); err != nil {
+			t.Fatal(err)
+		}
+		assertEqualWorld(t, res.World, evolve(world, 2))
+
+		expected := []int{5, 10, 5, 20}
+		if scores[2] == 0 {
+			expected = []int{10, 10, 10, 10}
+		}
+		for i, worker := range workers {
+			if len(worker.heights) != 2 || worker.heights[0] != expected[i] || worker.heights[1] != expected[i] {
+				t.Errorf("scores %v: worker %d computed regions of %v rows, expected %d", scores, i, worker.heights, expected[i])
+			}
+		}
+	}
+}
+
+// TestCoreWeights checks workers with the same score get strips in proportion to the cores
+// their Status says they compute on.
+func TestCoreWeights(t *testing.T) {
+	world := randomWorld(40, 16, 33)
+	one := &capableWorker{score: 1, testWorker: testWorker{cores: 1}}
+	three := &capableWorker{score: 1, testWorker: testWorker{cores: 3}}
+	b := newTestBroker(startWorker(t, one), startWorker(t, three))
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 1))
+	if len(one.heights) != 1 || one.heights[0] != 10 || len(three.heights) != 1 || three.heights[0] != 30 {
+		t.Errorf("expected strips of 10 and 30 rows, got %v and %v", one.heights, three.heights)
+	}
+	// The limits, score and cores all come from a single Status call to each worker.
+	if one.statuses != 1 || three.statuses != 1 {
+		t.Errorf("expected one Status call to each worker, got %d and %d", one.statuses, three.statuses)
+	}
+}
+
+// TestShutdownDeadWorker checks a worker that cannot be dialled makes Shutdown return an
+// error, after shutting down the live workers and the broker, rather than exiting.
+func TestShutdownDeadWorker(t *testing.T) {
+	live := &shutdownWorker{}
+	dead := deadAddress(t)
+	b := newTestBroker(dead, startWorker(t, live))
+
+	err := b.Shutdown(BrokerShutdownRequest{}, new(BrokerShutdownResponse))
+	if err == nil || !strings.Contains(err.Error(), dead) {
+		t.Errorf("expected an error dialling %v, got %v", dead, err)
+	}
+	if shutdowns := atomic.LoadInt32(&live.shutdowns); shutdowns != 1 {
+		t.Errorf("expected the live worker to be shut down once, got %d", shutdowns)
+	}
+
+	b = newTestBroker(dead)
+	if err := b.Process(BrokerProcessRequest{Turns: 1, World: randomWorld(8, 8, 55)}, new(BrokerProcessResponse)); err == nil {
+		t.Error("expected Process on a dead worker to return an error")
+	}
+}
+
+// TestMaxTPS checks 20 turns limited to 10 a second take about 2 seconds, and that a run
+// waiting for its next turn still pauses and quits straight away.
+func TestMaxTPS(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	b.MaxTPS = 10
+	world := randomWorld(16, 16, 56)
+	start := time.Now()
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 20, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 1900*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("expected 20 turns at 10 a second to take about 2s, took %v", elapsed)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 20))
+
+	b.MaxTPS = 2
+	done := make(chan error)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{Turns: 1000, World: world}, new(BrokerProcessResponse))
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start = time.Now()
+	paused := new(BrokerPauseResponse)
+	if err := b.Pause(BrokerPauseRequest{}, paused); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); !paused.IsPaused || elapsed > 250*time.Millisecond {
+		t.Errorf("expected to pause without waiting for the next tick, took %v", elapsed)
+	}
+	time.Sleep(600 * time.Millisecond)
+	if turns, _ := b.board(); turns != paused.Turns {
+		t.Errorf("expected the run held at turn %v while paused, got turn %v", paused.Turns, turns)
+	}
+
+	start = time.Now()
+	b.Quit(BrokerQuitRequest{}, new(BrokerQuitResponse))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("expected to quit without waiting for the next tick, took %v", elapsed)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+)
+
+// checkpoint is what a checkpoint file holds, gob encoded: a completed turn and the board
+// after it, packed.
+type checkpoint struct {
+	Turn  int
+	World PackedWorld
+}
+
+// saveCheckpoint writes turn and w to path. It writes a temporary file alongside path and
+// renames it over path once complete, so a crash while checkpointing leaves the previous
+// checkpoint rather than half of a new one.
+func saveCheckpoint(path string, turn int, w World) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Removing it after the rename fails harmlessly.
+	defer os.Remove(file.Name())
+
+	if err := gob.NewEncoder(file).Encode(checkpoint{Turn: turn, World: w.pack()}); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// loadCheckpoint reads the turn and board saveCheckpoint wrote to path.
+func loadCheckpoint(path string) (int, World, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, World{}, err
+	}
+	defer file.Close()
+
+	var saved checkpoint
+	if err := gob.NewDecoder(file).Decode(&saved); err != nil {
+		return 0, World{}, err
+	}
+	world, err := saved.World.unpack()
+	if err != nil {
+		return 0, World{}, err
+	}
+	return saved.Turn, world, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCheckpointRoundTrip overwrites a checkpoint and checks the later one loads back whole,
+// with no temporary file left beside it.
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "broker.checkpoint")
+	if err := saveCheckpoint(path, 3, randomWorld(8, 8, 40)); err != nil {
+		t.Fatal(err)
+	}
+	world := randomWorld(16, 24, 41)
+	if err := saveCheckpoint(path, 7, world); err != nil {
+		t.Fatal(err)
+	}
+
+	turn, loaded, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if turn != 7 || loaded.Height != 16 || loaded.Width != 24 {
+		t.Fatalf("expected a 24x16 board at turn 7, got %vx%v at turn %v", loaded.Width, loaded.Height, turn)
+	}
+	assertEqualWorld(t, loaded, world)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the checkpoint in %v, got %v entries", dir, len(entries))
+	}
+
+	if err := saveCheckpoint(filepath.Join(dir, "missing", "broker.checkpoint"), 1, world); err == nil {
+		t.Error("expected a checkpoint in a missing directory to fail")
+	}
+}
+
+// TestCheckpointResume checkpoints a run every 5 turns, then resumes a second broker from the
+// last checkpoint and checks it carries on from that turn.
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broker.checkpoint")
+	world := randomWorld(16, 16, 42)
+
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	b.CheckpointInterval = 5
+	b.CheckpointPath = path
+	if err := b.Process(BrokerProcessRequest{Turns: 12, World: world}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	turn, saved, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if turn != 10 {
+		t.Fatalf("expected the last checkpoint at turn 10, got turn %v", turn)
+	}
+
+	resumed := newTestBroker(startWorker(t, &testWorker{}))
+	resumed.Turns, resumed.World, resumed.Continue = int64(turn), saved, true
+	res := new(BrokerProcessResponse)
+	if err := resumed.Process(BrokerProcessRequest{Turns: 4, World: world}, res); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Turns != 14 {
+		t.Errorf("expected the resumed run to reach turn 14, got %v", resumed.Turns)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 14))
+}
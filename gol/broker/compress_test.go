@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestCompressRegion round trips a region, whole and packed, through compressRegion, and
+// checks a corrupt payload is refused.
+func TestCompressRegion(t *testing.T) {
+	world := randomWorld(24, 16, 58)
+	region := world.region(1, 3)
+	for _, sent := range []Region{region, region.packed()} {
+		compressed, err := sent.compressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressed.Field != nil || compressed.Packed.Height != 0 || compressed.Start != region.Start {
+			t.Fatalf("expected the rows only in the payload, with the rest left in place, got %+v", compressed)
+		}
+		given, err := compressed.decompressed()
+		if err == nil {
+			given, err = given.unpacked()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if given.Start != region.Start || given.End != region.End || len(given.Field) != len(region.Field) {
+			t.Fatalf("expected rows [%v, %v) back, got %v rows of [%v, %v)", region.Start, region.End, len(given.Field), given.Start, given.End)
+		}
+		for y := range region.Field {
+			for x := range region.Field[y] {
+				if given.Field[y][x].Alive != region.Field[y][x].Alive {
+					t.Fatalf("cell (%v, %v) changed in the round trip", x, y)
+				}
+			}
+		}
+	}
+
+	if _, err := decompressRegion([]byte("not gzip")); err == nil {
+		t.Error("expected a payload that is not gzipped to be refused")
+	}
+}
+
+// TestCompressedRegions runs a board with its regions compressed, packed and not, and checks
+// it evolves as it does sent plain.
+func TestCompressedRegions(t *testing.T) {
+	world := randomWorld(32, 24, 59)
+	for _, pack := range []bool{false, true} {
+		b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+		b.PackRegions = pack
+		b.CompressRegions = true
+		res := new(BrokerProcessResponse)
+		if err := b.Process(BrokerProcessRequest{Turns: 5, World: world}, res); err != nil {
+			t.Fatal(err)
+		}
+		assertEqualWorld(t, res.World, evolve(world, 5))
+	}
+}
+
+// gliderGun returns a height x width board, at least 40x12, with a Gosper glider gun in its top
+// left corner and every other cell dead.
+func gliderGun(height, width int) World {
+	world := randomWorld(height, width, 0)
+	for y := range world.Field.Data {
+		for x := range world.Field.Data[y] {
+			world.Field.Data[y][x].Alive = false
+		}
+	}
+	gun := [][2]int{
+		{24, 0}, {22, 1}, {24, 1}, {12, 2}, {13, 2}, {20, 2}, {21, 2}, {34, 2}, {35, 2},
+		{11, 3}, {15, 3}, {20, 3}, {21, 3}, {34, 3}, {35, 3}, {0, 4}, {1, 4}, {10, 4},
+		{16, 4}, {20, 4}, {21, 4}, {0, 5}, {1, 5}, {10, 5}, {14, 5}, {16, 5}, {17, 5},
+		{22, 5}, {24, 5}, {10, 6}, {16, 6}, {24, 6}, {11, 7}, {15, 7}, {12, 8}, {13, 8},
+	}
+	for _, cell := range gun {
+		world.Field.Data[cell[1]+2][cell[0]+2].Alive = true
+	}
+	return world
+}
+
+// slowLinkDialer is a TCP Dialer whose connections take perByte for every byte written or
+// read, like a slow link between the broker and its workers.
+type slowLinkDialer struct {
+	perByte time.Duration
+}
+
+func (d slowLinkDialer) Dial(address string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return slowLinkConn{Conn: conn, perByte: d.perByte}, nil
+}
+
+type slowLinkConn struct {
+	net.Conn
+	perByte time.Duration
+}
+
+func (c slowLinkConn) Write(p []byte) (int, error) {
+	time.Sleep(time.Duration(len(p)) * c.perByte)
+	return c.Conn.Write(p)
+}
+
+func (c slowLinkConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	time.Sleep(time.Duration(n) * c.perByte)
+	return n, err
+}
+
+// BenchmarkCompressedRegions compares the turn time and traffic of a mostly dead glider gun
+// board with its regions compressed and not, packed and not, over a 1MB/s link. Compressing
+// Cell rows cuts both, while packed rows are small enough that gzip only adds to them.
+func BenchmarkCompressedRegions(b *testing.B) {
+	const turns = 5
+	world := gliderGun(128, 128)
+	expected := evolve(world, turns)
+	for name, options := range map[string]struct{ pack, compress bool }{
+		"cells":       {false, false},
+		"cells+gzip":  {false, true},
+		"packed":      {true, false},
+		"packed+gzip": {true, true},
+	} {
+		b.Run(name, func(b *testing.B) {
+			var addresses []string
+			for i := 0; i < 4; i++ {
+				addresses = append(addresses, startWorker(b, &testWorker{}))
+			}
+			broker := newTestBroker(addresses...)
+			broker.Debug = false
+			broker.Dialer = slowLinkDialer{perByte: time.Microsecond}
+			broker.PackRegions = options.pack
+			broker.CompressRegions = options.compress
+
+			var elapsed time.Duration
+			var traffic int64
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				res := new(BrokerProcessResponse)
+				if err := broker.Process(BrokerProcessRequest{Turns: turns, World: world}, res); err != nil {
+					b.Fatal(err)
+				}
+				elapsed += time.Since(start)
+				traffic += res.Bytes
+				assertEqualWorld(b, res.World, expected)
+			}
+			b.ReportMetric(float64(elapsed.Milliseconds())/float64(turns*b.N), "ms/turn")
+			b.ReportMetric(float64(traffic)/float64(turns*b.N), "bytes/turn")
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "errors"
+
+var BrokerFetchRegion = "BrokerService.FetchRegion"
+
+var BrokerEndRun = "BrokerService.EndRun"
+
+// ErrNoBand is returned for a halo-only request from a coordinator this broker keeps no band for.
+var ErrNoBand = errors.New("no band kept for region")
+
+// ErrEdgesNotResident is returned for a band that is resident without being answered with
+// just its edges, or the other way round. A broker keeps bands only for a coordinator to
+// relay their edges, and answering with the edges of a band it did not keep loses the rest.
+var ErrEdgesNotResident = errors.New("edges only without a resident band")
+
+// runRegion identifies a region of one run by the run and the region's first row.
+type runRegion struct {
+	run   int64
+	start int
+}
+
+// keptBand is a band a coordinator's run keeps on this broker between turns, so the
+// coordinator sends it just the band's halo rows and is sent back just its edge rows.
+type keptBand struct {
+	// turn is the turn rows are at the start of.
+	turn int64
+	rows [][]Cell
+}
+
+// withKeptBand rebuilds a halo-only region around the rows of the band kept for it.
+func (b *BrokerService) withKeptBand(req WorkerProcessRequest, region Region) (Region, error) {
+	b.bandsMu.Lock()
+	band, ok := b.bands[runRegion{req.Run, region.Start}]
+	b.bandsMu.Unlock()
+	if !ok || band.turn != req.Turn || len(band.rows) != region.Height || len(region.Field) != 2 {
+		return Region{}, ErrNoBand
+	}
+	field := make([][]Cell, 0, len(band.rows)+2)
+	field = append(field, region.Field[0])
+	field = append(field, band.rows...)
+	field = append(field, region.Field[1])
+	region.Field = field
+	return region, nil
+}
+
+// keepBand keeps the rows computed for a coordinator's band for its next turn.
+func (b *BrokerService) keepBand(req WorkerProcessRequest, start int, rows [][]Cell) {
+	b.bandsMu.Lock()
+	defer b.bandsMu.Unlock()
+	if b.bands == nil {
+		b.bands = make(map[runRegion]keptBand)
+	}
+	b.bands[runRegion{req.Run, start}] = keptBand{turn: req.Turn + 1, rows: rows}
+}
+
+// FetchRegion returns a band kept for a coordinator's run, for the coordinator to pull its
+// board back.
+func (b *BrokerService) FetchRegion(req WorkerFetchStripRequest, res *WorkerFetchStripResponse) (err error) {
+	b.touch()
+	b.bandsMu.Lock()
+	band, ok := b.bands[runRegion{req.Run, req.Start}]
+	b.bandsMu.Unlock()
+	if !ok {
+		return ErrNoBand
+	}
+	res.Turn = band.turn
+	res.Region = Region{Field: band.rows, Start: req.Start, End: req.Start + len(band.rows), Height: len(band.rows)}
+	if len(band.rows) > 0 {
+		res.Region.Width = len(band.rows[0])
+	}
+	if req.Pack {
+		res.Region = res.Region.packed()
+	}
+	return
+}
+
+// EndRun drops the bands kept for a coordinator's run, once the run has finished with them.
+func (b *BrokerService) EndRun(req WorkerEndRunRequest, res *WorkerEndRunResponse) (err error) {
+	b.bandsMu.Lock()
+	defer b.bandsMu.Unlock()
+	for key := range b.bands {
+		if key.run == req.Run {
+			delete(b.bands, key)
+		}
+	}
+	return
+}
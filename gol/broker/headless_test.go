@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// BenchmarkRunHeadless runs the 512x512 image through gol.RunHeadless on a broker served over
+// RPC with 1 to 8 workers, and reports the turns a second each worker count reaches.
+func BenchmarkRunHeadless(b *testing.B) {
+	const turns = 10
+	p := gol.Params{Turns: turns, ImageWidth: 512, ImageHeight: 512, Pattern: "../../images/512x512.pgm"}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%d-workers", workers), func(b *testing.B) {
+			var addresses []string
+			for i := 0; i < workers; i++ {
+				addresses = append(addresses, startWorker(b, &testWorker{}))
+			}
+			broker := newTestBroker(addresses...)
+			broker.Debug = false
+			server := rpc.NewServer()
+			if err := server.Register(broker); err != nil {
+				b.Fatal(err)
+			}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer listener.Close()
+			go server.Accept(listener)
+
+			completed := 0
+			var elapsed float64
+			for i := 0; i < b.N; i++ {
+				ran, took, _, err := gol.RunHeadless(p, listener.Addr().String())
+				if err != nil {
+					b.Fatal(err)
+				}
+				completed += ran
+				elapsed += took.Seconds()
+			}
+			b.ReportMetric(float64(completed)/elapsed, "turns/s")
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// HealthTimeout bounds each health check ping, so a hung worker counts as failing it.
+const HealthTimeout = time.Second
+
+// watchHealth pings every worker each HealthInterval, removing those that fail MaxFailures
+// pings in a row, until stop is closed.
+func (b *BrokerService) watchHealth(stop <-chan struct{}) {
+	failures := make(map[string]int)
+	ticker := time.NewTicker(b.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, address := range b.workerAddresses() {
+			_, err := b.ping(address)
+			if err == nil {
+				delete(failures, address)
+				continue
+			}
+			failures[address]++
+			log.Printf("worker %v failed health check %v of %v: %v", address, failures[address], b.MaxFailures, err)
+			if failures[address] >= b.MaxFailures {
+				b.removeWorker(address)
+				delete(failures, address)
+			}
+		}
+	}
+}
+
+// ErrWorkerNotReady is returned for a worker whose Status says it is not ready for regions,
+// such as one shutting down.
+var ErrWorkerNotReady = errors.New("worker not ready")
+
+// ping asks the worker at address for its Status within HealthTimeout, failing with
+// ErrWorkerNotReady unless it says it is ready.
+func (b *BrokerService) ping(address string) (WorkerStatusResponse, error) {
+	response := new(WorkerStatusResponse)
+	done := make(chan error, 1)
+	go func() {
+		client, err := dial(b.Dialer, address)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer client.Close()
+		done <- client.Call(WorkerStatus, WorkerStatusRequest{}, response)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil && !response.Ready {
+			err = ErrWorkerNotReady
+		}
+		return *response, err
+	case <-time.After(HealthTimeout):
+		return WorkerStatusResponse{}, errors.New("timed out")
+	}
+}
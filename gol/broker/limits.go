@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Limits bound what a single session, one Process call, may ask of the broker. Zero leaves
+// a limit off.
+type Limits struct {
+	// MaxCells is the largest board, in cells, a session may process.
+	MaxCells int
+	// MaxTurns is the most turns a session may ask for.
+	MaxTurns int64
+	// MaxComputeTime is how long a session's turns may spend computing in total before it
+	// is stopped with ErrComputeLimit.
+	MaxComputeTime time.Duration
+}
+
+// ErrBoardTooLarge is returned by Process for a board larger than Limits.MaxCells.
+var ErrBoardTooLarge = errors.New("board exceeds the broker's size limit")
+
+// ErrTooManyTurns is returned by Process when asked for more turns than Limits.MaxTurns.
+var ErrTooManyTurns = errors.New("turn count exceeds the broker's limit")
+
+// ErrComputeLimit is returned by Process once a session's turns have taken longer in total
+// than Limits.MaxComputeTime. The last completed turn is kept for the client to save.
+var ErrComputeLimit = errors.New("session exceeded the broker's compute time limit")
+
+// check returns the error for a session of turns on world that these limits reject.
+func (l Limits) check(world World, turns int64) error {
+	if l.MaxCells > 0 && world.Height*world.Width > l.MaxCells {
+		return ErrBoardTooLarge
+	}
+	if l.MaxTurns > 0 && turns > l.MaxTurns {
+		return ErrTooManyTurns
+	}
+	return nil
+}
+
+// turnQueue hands out turns to the sessions running on the broker, as many at once as the
+// workers have capacity for, so independent sessions compute in parallel and one stalled on
+// a failing worker holds up no other. Past that capacity, turns are handed out in the order
+// they were asked for. A session asks for its next turn only once its last is done, so that
+// order goes round the waiting sessions, and one with a large board can't compute turn after
+// turn while another waits. Its zero value is ready to use.
+type turnQueue struct {
+	mu      sync.Mutex
+	running int
+	waiting []chan struct{}
+}
+
+// acquire waits until the caller may compute a turn, with capacity turns computed at once.
+func (q *turnQueue) acquire(capacity int) {
+	q.mu.Lock()
+	q.admit(capacity)
+	if q.running < capacity || q.running == 0 {
+		q.running++
+		q.mu.Unlock()
+		return
+	}
+	next := make(chan struct{})
+	q.waiting = append(q.waiting, next)
+	q.mu.Unlock()
+	<-next
+}
+
+// release ends the caller's turn, passing it on to the longest waiting session, if any.
+func (q *turnQueue) release(capacity int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	q.admit(capacity)
+}
+
+// admit starts the longest waiting turns while there is capacity for them, or the first when
+// none are running, since a session with no workers still waits its turn for them. The caller
+// holds mu.
+func (q *turnQueue) admit(capacity int) {
+	for len(q.waiting) > 0 && (q.running < capacity || q.running == 0) {
+		close(q.waiting[0])
+		q.waiting = q.waiting[1:]
+		q.running++
+	}
+}
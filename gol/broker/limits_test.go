@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSessionLimits checks sessions over the size and turn limits are rejected up front, and
+// one over its compute time is stopped at a turn boundary.
+func TestSessionLimits(t *testing.T) {
+	b := newTestBroker(startWorker(t, &slowWorker{delay: 10 * time.Millisecond}))
+	b.Limits = Limits{MaxCells: 256, MaxTurns: 100, MaxComputeTime: 35 * time.Millisecond}
+
+	world := randomWorld(16, 16, 26)
+	for _, session := range []struct {
+		world World
+		turns int64
+		err   error
+	}{
+		{randomWorld(17, 16, 26), 1, ErrBoardTooLarge},
+		{world, 101, ErrTooManyTurns},
+		{world, 100, ErrComputeLimit},
+	} {
+		res := new(BrokerProcessResponse)
+		if err := b.Process(BrokerProcessRequest{Turns: session.turns, World: session.world}, res); err != session.err {
+			t.Fatalf("expected %v, got %v", session.err, err)
+		}
+		if session.err == ErrComputeLimit {
+			if res.ComputeTime <= b.Limits.MaxComputeTime || res.Turns < 3 || res.Turns > 5 {
+				t.Errorf("stopped after %d turns taking %v", res.Turns, res.ComputeTime)
+			}
+			assertEqualWorld(t, res.World, evolve(world, int(res.Turns)))
+		}
+	}
+}
+
+// TestFairShare runs a small session alongside a large one on the same slow worker and checks
+// the small one finishes while the large one is still going, its turns interleaved.
+func TestFairShare(t *testing.T) {
+	b := newTestBroker(startWorker(t, &slowWorker{delay: 5 * time.Millisecond}))
+
+	large := make(chan error)
+	go func() {
+		large <- b.Process(BrokerProcessRequest{Turns: 100, World: randomWorld(32, 32, 27)}, new(BrokerProcessResponse))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	small := randomWorld(16, 16, 28)
+	res := new(BrokerProcessResponse)
+	if err := b.Process(BrokerProcessRequest{Turns: 5, World: small}, res); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-large:
+		t.Fatalf("the large session finished first: %v", err)
+	default:
+	}
+	assertEqualWorld(t, res.World, evolve(small, 5))
+	if err := <-large; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stallingWorker is a testWorker whose regions as wide as width wait until release is closed.
+type stallingWorker struct {
+	testWorker
+	width   int
+	release chan struct{}
+}
+
+func (w *stallingWorker) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	if req.Region.Width == w.width {
+		<-w.release
+	}
+	return w.testWorker.Process(req, res)
+}
+
+// TestParallelSessions stalls one session's turn on its workers, and checks another session
+// on the same workers computes its turns meanwhile rather than waiting for it.
+func TestParallelSessions(t *testing.T) {
+	release := make(chan struct{})
+	b := newTestBroker(startWorker(t, &stallingWorker{width: 32, release: release}), startWorker(t, &stallingWorker{width: 32, release: release}))
+	ids := make([]string, 2)
+	for i := range ids {
+		started := new(BrokerStartResponse)
+		if err := b.Start(BrokerStartRequest{}, started); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = started.SessionID
+	}
+
+	stalled := make(chan error)
+	go func() {
+		stalled <- b.Process(BrokerProcessRequest{SessionID: ids[0], Turns: 3, World: randomWorld(32, 32, 29)}, new(BrokerProcessResponse))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	small := randomWorld(16, 16, 30)
+	done := make(chan error)
+	res := new(BrokerProcessResponse)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{SessionID: ids[1], Turns: 5, World: small}, res)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqualWorld(t, res.World, evolve(small, 5))
+	case <-time.After(5 * time.Second):
+		t.Error("the session waited on the stalled one")
+	}
+	close(release)
+	if err := <-stalled; err != nil {
+		t.Fatal(err)
+	}
+}
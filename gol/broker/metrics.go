@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// aliveBuckets are the upper bounds of the alive cells histogram, spanning an empty board to
+// a full 1024x1024 one.
+var aliveBuckets = []float64{0, 10, 100, 1000, 10000, 100000, 1000000}
+
+// durationBuckets are the upper bounds, in seconds, of the turn duration histogram, spanning a
+// small board on local workers to a large one on slow or distant ones.
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// histogram counts observations into buckets by upper bound, as a Prometheus histogram.
+type histogram struct {
+	bounds []float64
+	// counts holds the number of observations at most bounds[i] and more than the previous
+	// bound, with those above every bound last.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	bucket := len(h.bounds)
+	for i, bound := range h.bounds {
+		if value <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.counts[bucket]++
+	h.sum += value
+	h.count++
+}
+
+// write writes the histogram as name in the Prometheus text format, with its cumulative buckets.
+func (h *histogram) write(w io.Writer, name string) {
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", name, bound, cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)]
+	fmt.Fprintf(w, "%v_bucket{le=\"+Inf\"} %v\n", name, cumulative)
+	fmt.Fprintf(w, "%v_sum %v\n", name, h.sum)
+	fmt.Fprintf(w, "%v_count %v\n", name, h.count)
+}
+
+// metrics is what the broker exports at /metrics, in the Prometheus text format.
+type metrics struct {
+	mu sync.Mutex
+
+	turns   int64
+	alive   int
+	workers int
+
+	// alivePerTurn graphs population over time, and turnDuration how long each turn took to
+	// compute across the workers.
+	alivePerTurn histogram
+	turnDuration histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		alivePerTurn: newHistogram(aliveBuckets),
+		turnDuration: newHistogram(durationBuckets),
+	}
+}
+
+// observeTurn records the alive cells at the end of turn, how long it took and how many
+// workers computed it.
+func (m *metrics) observeTurn(turn int64, alive int, duration time.Duration, workers int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.turns = turn
+	m.alive = alive
+	m.workers = workers
+	m.alivePerTurn.observe(float64(alive))
+	m.turnDuration.observe(duration.Seconds())
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP gol_turns_completed Turns completed by the current run.")
+	fmt.Fprintln(w, "# TYPE gol_turns_completed gauge")
+	fmt.Fprintf(w, "gol_turns_completed %v\n", m.turns)
+
+	fmt.Fprintln(w, "# HELP gol_current_turn The turn the board is at.")
+	fmt.Fprintln(w, "# TYPE gol_current_turn gauge")
+	fmt.Fprintf(w, "gol_current_turn %v\n", m.turns)
+
+	fmt.Fprintln(w, "# HELP gol_alive_cells Cells alive after the last completed turn.")
+	fmt.Fprintln(w, "# TYPE gol_alive_cells gauge")
+	fmt.Fprintf(w, "gol_alive_cells %v\n", m.alive)
+
+	fmt.Fprintln(w, "# HELP gol_active_workers Workers the last completed turn was split between.")
+	fmt.Fprintln(w, "# TYPE gol_active_workers gauge")
+	fmt.Fprintf(w, "gol_active_workers %v\n", m.workers)
+
+	fmt.Fprintln(w, "# HELP gol_alive_cells_per_turn Cells alive at the end of each turn.")
+	fmt.Fprintln(w, "# TYPE gol_alive_cells_per_turn histogram")
+	m.alivePerTurn.write(w, "gol_alive_cells_per_turn")
+
+	fmt.Fprintln(w, "# HELP gol_turn_duration_seconds Time taken to compute each turn.")
+	fmt.Fprintln(w, "# TYPE gol_turn_duration_seconds histogram")
+	m.turnDuration.write(w, "gol_turn_duration_seconds")
+}
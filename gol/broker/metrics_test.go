@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestMetricsAliveSeries scrapes the metrics endpoint after a run and checks the gauges hold
+// the last turn, and the alive cells and turn duration histograms saw every turn.
+func TestMetricsAliveSeries(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	b.metrics = newMetrics()
+	server := httptest.NewServer(b.metrics)
+	defer server.Close()
+
+	const turns = 5
+	world := randomWorld(16, 16, 13)
+	if err := b.Process(BrokerProcessRequest{Turns: turns, World: world}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	sum, last, under100 := 0, 0, 0
+	for turn := 1; turn <= turns; turn++ {
+		evolved := evolve(world, turn)
+		last = len(evolved.alive())
+		sum += last
+		if last <= 100 {
+			under100++
+		}
+	}
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		fmt.Sprintf("gol_turns_completed %d", turns),
+		fmt.Sprintf("gol_current_turn %d", turns),
+		fmt.Sprintf("gol_alive_cells %d", last),
+		"gol_active_workers 2",
+		fmt.Sprintf(`gol_alive_cells_per_turn_bucket{le="100"} %d`, under100),
+		fmt.Sprintf(`gol_alive_cells_per_turn_bucket{le="+Inf"} %d`, turns),
+		fmt.Sprintf("gol_alive_cells_per_turn_sum %d", sum),
+		fmt.Sprintf("gol_alive_cells_per_turn_count %d", turns),
+		fmt.Sprintf(`gol_turn_duration_seconds_bucket{le="+Inf"} %d`, turns),
+		fmt.Sprintf("gol_turn_duration_seconds_count %d", turns),
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Register adds a worker to the ones the broker hands regions to, from the next turn on, once
+// its Status says it is ready. A worker registering again, such as after a restart, is not
+// added twice.
+func (b *BrokerService) Register(req BrokerRegisterRequest, res *BrokerRegisterResponse) (err error) {
+	b.touch()
+	if req.Address == "" {
+		return errors.New("register: missing worker address")
+	}
+	status, err := b.ping(req.Address)
+	if err != nil {
+		return fmt.Errorf("register: worker %v: %v", req.Address, err)
+	}
+
+	b.addressesMu.Lock()
+	defer b.addressesMu.Unlock()
+	registered := false
+	for _, address := range b.addresses {
+		if address == req.Address {
+			registered = true
+			break
+		}
+	}
+	if !registered {
+		b.addresses = append(b.addresses, req.Address)
+		log.Printf("worker %v registered, version %v on %v cores", req.Address, status.Version, status.CoresAvailable)
+		if b.added != nil {
+			close(b.added)
+			b.added = nil
+		}
+	}
+	res.Workers = len(b.addresses)
+	return
+}
+
+// workerAddresses returns a copy of the workers' addresses, safe to use while others register.
+func (b *BrokerService) workerAddresses() []string {
+	b.addressesMu.RLock()
+	defer b.addressesMu.RUnlock()
+	return append([]string(nil), b.addresses...)
+}
+
+// noWorkers reports whether there are no workers, along with a channel closed when one
+// registers if so.
+func (b *BrokerService) noWorkers() (<-chan struct{}, bool) {
+	b.addressesMu.Lock()
+	defer b.addressesMu.Unlock()
+	if len(b.addresses) > 0 {
+		return nil, false
+	}
+	if b.added == nil {
+		b.added = make(chan struct{})
+	}
+	return b.added, true
+}
+
+// removeWorker stops handing regions to address, from the next turn on.
+func (b *BrokerService) removeWorker(address string) {
+	b.addressesMu.Lock()
+	defer b.addressesMu.Unlock()
+	for i, registered := range b.addresses {
+		if registered == address {
+			b.addresses = append(b.addresses[:i:i], b.addresses[i+1:]...)
+			log.Printf("worker %v removed, %v left", address, len(b.addresses))
+			return
+		}
+	}
+}
+
+// sameAddresses reports whether a and b list the same workers in the same order.
+func sameAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var WorkerFetchStrip = "WorkerService.FetchStrip"
+
+// residentRun keeps each strip of the board on its own worker between turns. The workers
+// swap halo rows with their neighbours directly, so a turn only brings the broker the alive
+// counts, and the board is pulled back just when it is wanted.
+//
+// A coordinator's brokers keep its bands the same way, but do not know each other, so their
+// halo rows are relayed through the coordinator: each turn a broker is sent its band's halos
+// and sends back its band's new first and last rows.
+type residentRun struct {
+	d   dispatch
+	run int64
+	// regions are the strips, without their rows, region i resident on workers[i].
+	regions []Region
+	workers []string
+	loaded  bool
+
+	// relay is set for a coordinator's run, and edges are then each band's first and last
+	// rows at the start of the next turn.
+	relay bool
+	edges [][2][]Cell
+}
+
+// newResidentRun splits world into d's regions as a turn of any other run would be, so the
+// strips keep within the workers' height limits and suit their weights, and hands them out to
+// the workers round-robin.
+func newResidentRun(d dispatch, world World) *residentRun {
+	parts := d.regions
+	if parts > world.Height {
+		parts = world.Height
+	}
+	r := &residentRun{d: d, run: time.Now().UnixNano(), regions: make([]Region, parts), workers: make([]string, parts),
+		relay: d.method == BrokerProcessRegion}
+	var spans []int
+	if len(d.weights) == parts {
+		spans = weightedSpans(world.Height, d.weights)
+	}
+	for i := range r.regions {
+		start, end := span(i, parts, world.Height)
+		if spans != nil {
+			start, end = spans[i], spans[i+1]
+		}
+		r.regions[i] = Region{Start: start, End: end, Height: end - start, Width: world.Width, Boundary: world.boundary, Rule: world.rule}
+		r.workers[i] = d.addresses[i%len(d.addresses)]
+	}
+	return r
+}
+
+// step computes turn on the workers, handing them their strips of world first if they do not
+// hold them yet, and returns the alive cells in each strip.
+func (r *residentRun) step(world World, turn int64) ([]int, error) {
+	counts := make([]int, len(r.regions))
+	edges := make([][2][]Cell, len(r.regions))
+	errCh := make(chan error, len(r.regions))
+	for i, shape := range r.regions {
+		go func(i int, shape Region) {
+			address := r.workers[i]
+			method := WorkerProcess
+			request := WorkerProcessRequest{Region: shape, Turn: turn, Run: r.run, Resident: true}
+			if r.relay {
+				method = r.d.method
+				request = r.relayed(world, i, turn)
+			} else if !r.loaded {
+				n := len(r.regions)
+				request.Region = world.strip(shape.Start, shape.End)
+				if r.d.pack {
+					request.Region = request.Region.packed()
+				}
+				up, down := (i-1+n)%n, (i+1)%n
+				request.Up, request.UpStart = r.workers[up], r.regions[up].Start
+				request.Down, request.DownStart = r.workers[down], r.regions[down].Start
+				// Past a Fixed boundary there is no neighbour, and the halo is dead.
+				if shape.Boundary == Fixed && i == 0 {
+					request.Up = ""
+				}
+				if shape.Boundary == Fixed && i == n-1 {
+					request.Down = ""
+				}
+			}
+			client, err := dial(r.d.dialer, address)
+			if err != nil {
+				errCh <- fmt.Errorf("worker %v on turn %v: %v", address, turn, err)
+				return
+			}
+			defer client.Close()
+			response := new(WorkerProcessResponse)
+			if err := call(client, r.d.callTimeout, method, request, response); err != nil {
+				errCh <- fmt.Errorf("worker %v on turn %v: %v", address, turn, err)
+				return
+			}
+			if r.relay {
+				region, err := response.Region.unpacked()
+				if err == nil && len(region.Field) != 2 {
+					err = fmt.Errorf("%v rows rather than its first and last", len(region.Field))
+				}
+				if err != nil {
+					errCh <- fmt.Errorf("broker %v on turn %v returned band [%v, %v) with %v", address, turn, shape.Start, shape.End, err)
+					return
+				}
+				edges[i] = [2][]Cell{region.Field[0], region.Field[1]}
+			}
+			r.d.stats.computed(address)
+			counts[i] = response.AliveCount
+			errCh <- nil
+		}(i, shape)
+	}
+
+	var err error
+	for range r.regions {
+		if stripErr := <-errCh; stripErr != nil && err == nil {
+			err = stripErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.loaded = true
+	r.edges = edges
+	return counts, nil
+}
+
+// relayed is the request computing turn of band i of a coordinator's run: the whole band the
+// first time, and after that just its halos, from the edges of the bands above and below.
+func (r *residentRun) relayed(world World, i int, turn int64) WorkerProcessRequest {
+	shape := r.regions[i]
+	request := WorkerProcessRequest{Region: shape, Turn: turn, Run: r.run, Resident: true, EdgesOnly: true}
+	if !r.loaded {
+		request.Region = world.strip(shape.Start, shape.End)
+	} else {
+		// Past a Fixed boundary there is no band, and the halo is dead.
+		n := len(r.regions)
+		top, bottom := make([]Cell, shape.Width), make([]Cell, shape.Width)
+		if shape.Boundary != Fixed || i > 0 {
+			top = r.edges[(i-1+n)%n][1]
+		}
+		if shape.Boundary != Fixed || i < n-1 {
+			bottom = r.edges[(i+1)%n][0]
+		}
+		request.Region.Field = [][]Cell{top, bottom}
+		request.HaloOnly = true
+	}
+	if r.d.pack {
+		request.Region = request.Region.packed()
+	}
+	return request
+}
+
+// end tells the workers to drop the strips, which are of no more use once the run is over.
+func (r *residentRun) end() {
+	r.d.endRun(r.run)
+}
+
+// pull fetches every strip back from its worker, at the start of turn, and assembles them into
+// world.
+func (r *residentRun) pull(world *World, turn int64) error {
+	regionCh := make(chan regionResult, len(r.regions))
+	errCh := make(chan error, len(r.regions))
+	for i := range r.regions {
+		go func(i int) {
+			address := r.workers[i]
+			client, err := dial(r.d.dialer, address)
+			if err != nil {
+				errCh <- fmt.Errorf("fetching the strip on worker %v: %v", address, err)
+				return
+			}
+			defer client.Close()
+			method := WorkerFetchStrip
+			if r.relay {
+				method = BrokerFetchRegion
+			}
+			response := new(WorkerFetchStripResponse)
+			if err := call(client, r.d.callTimeout, method, WorkerFetchStripRequest{Run: r.run, Start: r.regions[i].Start, Pack: r.d.pack}, response); err != nil {
+				errCh <- fmt.Errorf("fetching the strip on worker %v: %v", address, err)
+				return
+			}
+			region, err := response.Region.unpacked()
+			if err != nil {
+				errCh <- fmt.Errorf("fetching the strip on worker %v: %v", address, err)
+				return
+			}
+			if response.Region.Start != r.regions[i].Start {
+				errCh <- fmt.Errorf("worker %v returned the strip at row %v, not %v", address, response.Region.Start, r.regions[i].Start)
+				return
+			}
+			if response.Turn != turn {
+				errCh <- fmt.Errorf("worker %v holds its strip at turn %v, not %v", address, response.Turn, turn)
+				return
+			}
+			regionCh <- regionResult{id: i, region: region}
+		}(i)
+	}
+	return world.assemble(regionCh, errCh, len(r.regions))
+}
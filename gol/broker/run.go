@@ -0,0 +1,356 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// run carries one call to simulate from turn to turn. A resident run leaves the board on the
+// workers between turns, so world is only brought up to date when it is wanted: completed is
+// the last turn computed, worldTurn the turn world is at and boardTurn the turn the session's
+// World is at, and boardTurn <= worldTurn <= completed. Between turns settle brings all three
+// together, and a failed turn puts the session back to boardTurn.
+type run struct {
+	b      *BrokerService
+	s      *session
+	req    BrokerProcessRequest
+	res    *BrokerProcessResponse
+	method string
+	stats  *runStats
+
+	d     dispatch
+	world World
+	// resident keeps the strips on the workers between turns, or is nil when the board is
+	// sent out every turn. A coordinator's brokers always keep their bands.
+	resident *residentRun
+
+	completed, worldTurn, boardTurn int64
+	// turn is how many turns this call has computed.
+	turn int64
+}
+
+// newRun starts a run of req from world, which the session's World and Turns are already at,
+// computing each region with method on the workers of d.
+func (b *BrokerService) newRun(s *session, req BrokerProcessRequest, res *BrokerProcessResponse, method string, d dispatch, world World, completed int64) *run {
+	r := &run{b: b, s: s, req: req, res: res, method: method, stats: d.stats, d: d, world: world,
+		completed: completed, worldTurn: completed, boardTurn: completed}
+	if b.Resident && method == WorkerProcess && !d.grid || method == BrokerProcessRegion {
+		r.resident = newResidentRun(d, world)
+	}
+	return r
+}
+
+// end tells the workers to drop the rows they keep for the run, including the last dispatch's
+// after a repartition.
+func (r *run) end() {
+	r.d.endRun(r.d.cache.id())
+	if r.resident != nil {
+		r.resident.end()
+	}
+}
+
+// fail puts the session's Turns back to the turn its World is at, so the client can still save
+// a board that matches its turn, and returns err.
+func (r *run) fail(err error) error {
+	s := r.s
+	s.mu.Lock()
+	if s.Turns != r.boardTurn {
+		s.Turns = r.boardTurn
+		s.CellsCount = len(s.World.alive())
+		s.notifyLocked()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// recompute brings world up to the start of turn to from the last turn pulled back, after a
+// resident worker failed and left the strips at different turns or lost. The turns fail over
+// like any other run's, and the strips are handed out afresh from world next turn.
+func (r *run) recompute(to int64, cause error) (counts []int, err error) {
+	log.Printf("resident run failed, computing turns %v to %v again: %v", r.worldTurn+1, to, cause)
+	r.resident.end()
+	r.resident = newResidentRun(r.d, r.world)
+	for ; r.worldTurn < to; r.worldTurn++ {
+		if counts, err = r.world.update(r.d, r.worldTurn); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// settle pulls a resident board back between turns, so world and the session's World are the
+// last completed turn.
+func (r *run) settle() error {
+	if r.worldTurn == r.completed {
+		return nil
+	}
+	if err := r.resident.pull(&r.world, r.completed); err != nil {
+		if _, err = r.recompute(r.completed, err); err != nil {
+			return r.fail(err)
+		}
+	}
+	r.worldTurn, r.boardTurn = r.completed, r.completed
+	r.s.mu.Lock()
+	r.s.setWorldLocked(r.world)
+	r.s.mu.Unlock()
+	return nil
+}
+
+// answer replies to a snapshot request, which is only taken between turns, so the snapshot is
+// exactly the last completed turn.
+func (r *run) answer(reply chan BrokerPauseAndSnapshotResponse) error {
+	err := r.settle()
+	reply <- r.s.snapshotResponse()
+	return err
+}
+
+// stop ends the run with the last completed turn.
+func (r *run) stop() error {
+	if err := r.settle(); err != nil {
+		return err
+	}
+	r.res.World = r.world
+	r.res.Turns = r.turn
+	return nil
+}
+
+// step computes a single turn for a paused run, which stays paused with the board brought up to
+// it.
+func (r *run) step(reply chan stepResult) error {
+	err := r.advance()
+	if err == nil {
+		err = r.settle()
+	}
+	if err != nil {
+		reply <- stepResult{err: err}
+		return err
+	}
+	reply <- stepResult{res: r.s.stepResponse()}
+	return nil
+}
+
+// repartition splits the board across current, the workers registered now, once the strips
+// held by the old workers are pulled back.
+func (r *run) repartition(current []string) error {
+	if err := r.settle(); err != nil {
+		return err
+	}
+	if len(current) == 0 {
+		return ErrNoWorkers
+	}
+	log.Printf("repartitioning turn %v across %v workers", r.completed+1, len(current))
+	r.d.endRun(r.d.cache.id())
+	r.d = r.b.newDispatch(current, r.world.Height, r.method, r.stats)
+	if r.resident != nil {
+		r.resident.end()
+		r.resident = newResidentRun(r.d, r.world)
+	}
+	return nil
+}
+
+// compute computes the next turn, on the resident strips or from world, and returns the alive
+// cells in each region. A resident run's world is brought up to the turn only when something
+// needs the board every turn.
+func (r *run) compute() (counts []int, err error) {
+	if r.resident == nil {
+		if counts, err = r.world.update(r.d, r.completed); err == nil {
+			r.worldTurn = r.completed + 1
+		}
+		return
+	}
+	if counts, err = r.resident.step(r.world, r.completed); err != nil {
+		return r.recompute(r.completed+1, err)
+	}
+	if r.worldTurn != r.completed+1 && (r.b.Debug || r.b.VerifyAssembly || r.req.Stream) {
+		if err = r.resident.pull(&r.world, r.completed+1); err != nil {
+			return r.recompute(r.completed+1, err)
+		}
+		r.worldTurn = r.completed + 1
+	}
+	return
+}
+
+// advance computes the next turn and brings the session up to it.
+func (r *run) advance() error {
+	b, s := r.b, r.s
+	// Workers that registered or failed their health checks since the last turn change how
+	// the board is split.
+	if current := b.workerAddresses(); !sameAddresses(current, r.d.addresses) {
+		if err := r.repartition(current); err != nil {
+			return err
+		}
+	}
+	b.scheduler.acquire(len(r.d.addresses))
+	start := time.Now()
+	previous := r.world
+	counts, err := r.compute()
+	elapsed := time.Since(start)
+	r.res.ComputeTime += elapsed
+	b.scheduler.release(len(r.d.addresses))
+	if err == nil && b.VerifyAssembly {
+		err = r.world.verifyAssembly(previous, r.completed+1)
+	}
+	if err != nil {
+		// The session's World still holds the last turn pulled back for the client to save.
+		return r.fail(err)
+	}
+
+	r.completed++
+	s.mu.Lock()
+	s.Turns = r.completed
+	s.regionCounts = counts
+	// The workers count the cells alive in their regions as they compute them, which saves
+	// scanning the whole board again for the total.
+	s.CellsCount = 0
+	for _, count := range counts {
+		s.CellsCount += count
+	}
+	if r.worldTurn == r.completed {
+		flips := s.setWorldLocked(r.world)
+		r.boardTurn = r.completed
+		if r.req.Stream {
+			s.streamLocked(TurnFlips{Turns: r.completed, CellsCount: s.CellsCount, Cells: flips})
+		}
+	}
+	b.metrics.observeTurn(r.completed, s.CellsCount, elapsed, len(r.d.addresses))
+	s.notifyLocked()
+	if b.Debug {
+		err = s.checkCellsCount()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	r.turn++
+	if r.d.speeds != nil {
+		b.reweigh(&r.d)
+	}
+	if b.CheckpointInterval > 0 && r.completed%b.CheckpointInterval == 0 {
+		if err := r.settle(); err != nil {
+			return err
+		}
+		// A failed checkpoint leaves the last one in place, and is no reason to stop.
+		if err := saveCheckpoint(b.checkpointPath(r.req.SessionID), int(r.completed), r.world); err != nil {
+			log.Printf("checkpointing turn %v: %v", r.completed, err)
+		}
+	}
+	if b.Limits.MaxComputeTime > 0 && r.res.ComputeTime > b.Limits.MaxComputeTime {
+		if err := r.stop(); err != nil {
+			return err
+		}
+		return ErrComputeLimit
+	}
+	return nil
+}
+
+// play computes the run's turns up to turns, pausing, waiting for workers, for a streaming
+// client to catch up and for MaxTPS as it goes, and answering snapshots and quits between
+// turns.
+func (r *run) play(turns int64) error {
+	b, s := r.b, r.s
+	// tick paces the turns when they are limited to MaxTPS, and is nil when they are not.
+	var tick <-chan time.Time
+	if b.MaxTPS > 0 && b.MaxTPS <= int(time.Second) {
+		limiter := time.NewTicker(time.Second / time.Duration(b.MaxTPS))
+		defer limiter.Stop()
+		tick = limiter.C
+	}
+
+	for r.turn < turns {
+		s.mu.RLock()
+		paused, changed := s.isPaused, s.changed
+		s.mu.RUnlock()
+		if paused {
+			// Paused, wait for the state to change and look again, still answering snapshots.
+			// However many times Pause toggles meanwhile, the run follows the latest state.
+			select {
+			case <-changed:
+			case reply := <-s.snapshot:
+				if err := r.answer(reply); err != nil {
+					return err
+				}
+			case reply := <-s.step:
+				if err := r.step(reply); err != nil {
+					return err
+				}
+			case <-s.quit:
+				return r.stop()
+			}
+			continue
+		}
+
+		if added, none := b.noWorkers(); none {
+			// Every worker has gone, so the run waits for one to register rather than fail.
+			log.Printf("no workers left, waiting for one to register before turn %v", r.completed+1)
+			select {
+			case <-added:
+			case <-changed:
+			case reply := <-s.snapshot:
+				if err := r.answer(reply); err != nil {
+					return err
+				}
+			case <-s.quit:
+				return r.stop()
+			}
+			continue
+		}
+
+		if r.req.Stream {
+			s.mu.RLock()
+			behind, taken := len(s.stream) >= StreamBacklog, s.streamTaken
+			s.mu.RUnlock()
+			if behind {
+				// The client is StreamBacklog turns behind, so wait for it to take some.
+				select {
+				case <-taken:
+				case <-changed:
+				case reply := <-s.snapshot:
+					if err := r.answer(reply); err != nil {
+						return err
+					}
+				case <-s.quit:
+					return r.stop()
+				}
+				continue
+			}
+		}
+
+		if tick != nil {
+			// Waiting for the next turn's tick, which a pause meanwhile goes back to wait on.
+			select {
+			case <-tick:
+			case <-changed:
+				continue
+			case reply := <-s.snapshot:
+				if err := r.answer(reply); err != nil {
+					return err
+				}
+				continue
+			case <-s.quit:
+				return r.stop()
+			}
+		}
+
+		select {
+		case reply := <-s.snapshot:
+			if err := r.answer(reply); err != nil {
+				return err
+			}
+		case <-s.quit:
+			return r.stop()
+		default:
+			if err := r.advance(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := r.stop(); err != nil {
+		return err
+	}
+	if seconds := r.res.ComputeTime.Seconds(); seconds > 0 {
+		log.Printf("computed %v turns in %v, %.1f turns/s", r.turn, r.res.ComputeTime, float64(r.turn)/seconds)
+	}
+	return nil
+}
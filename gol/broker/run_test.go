@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestRunTurns steps a resident run by hand, checking the board stays on the workers until it
+// is settled, that a worker failing a turn has the turns since the last board pulled back
+// computed again, and that a failed turn puts the session back to the board it holds.
+func TestRunTurns(t *testing.T) {
+	workers := []*residentWorker{{}, {failTurn: 5}}
+	b := newTestBroker(startResidentWorkers(t, workers...)...)
+	b.Debug = false
+	b.Resident = true
+
+	world := randomWorld(16, 16, 19)
+	s := &b.session
+	s.mu.Lock()
+	s.setWorldLocked(world)
+	s.mu.Unlock()
+	d := b.newDispatch(b.addresses, world.Height, WorkerProcess, newRunStats())
+	r := b.newRun(s, BrokerProcessRequest{}, new(BrokerProcessResponse), WorkerProcess, d, world, 0)
+	defer r.end()
+	if r.resident == nil {
+		t.Fatal("expected a resident run")
+	}
+
+	advance := func(turns int) {
+		t.Helper()
+		for i := 0; i < turns; i++ {
+			if err := r.advance(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	check := func(completed, worldTurn, boardTurn int64) {
+		t.Helper()
+		if r.completed != completed || r.worldTurn != worldTurn || r.boardTurn != boardTurn {
+			t.Fatalf("expected turns %d, %d and %d completed, in world and on the board, got %d, %d and %d",
+				completed, worldTurn, boardTurn, r.completed, r.worldTurn, r.boardTurn)
+		}
+		if s.Turns != completed {
+			t.Fatalf("expected the session at turn %d, got %d", completed, s.Turns)
+		}
+	}
+
+	advance(3)
+	check(3, 0, 0)
+	if err := r.settle(); err != nil {
+		t.Fatal(err)
+	}
+	check(3, 3, 3)
+	assertEqualWorld(t, s.World, evolve(world, 3))
+
+	// The worker fails the turn after turn 5, which is computed again with turns 4 and 5 from
+	// the board pulled back at turn 3.
+	advance(3)
+	check(6, 6, 6)
+	if !workers[1].failed {
+		t.Error("expected the worker to have failed its turn")
+	}
+	assertEqualWorld(t, r.world, evolve(world, 6))
+	assertEqualWorld(t, s.World, evolve(world, 6))
+
+	advance(1)
+	check(7, 6, 6)
+	if err := r.fail(errors.New("failed")); err == nil {
+		t.Fatal("expected fail to return its error")
+	}
+	if s.Turns != 6 {
+		t.Errorf("expected a failed turn to put the session back to turn 6, got %d", s.Turns)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// session is one simulation on the broker, with its own board, turn count and controls, so
+// several clients can each run their own at once.
+type session struct {
+	// mu guards Turns, CellsCount and World, which Process updates while other RPCs read them.
+	mu         sync.RWMutex
+	Turns      int64
+	CellsCount int
+	World      World
+	quit       chan bool
+
+	// isPaused holds the run at its next turn boundary until it is cleared, guarded by mu.
+	// The run waits on changed while it is set, so Pause never blocks on it.
+	isPaused bool
+
+	// seq counts the changes to the state watchers see, and changed is closed and
+	// replaced on every change to wake them. Both are guarded by mu, like running.
+	seq     uint64
+	changed chan struct{}
+	running bool
+
+	// token is the Token of the run in progress, guarded by mu. Reads stay open to
+	// observers, but control RPCs must present it.
+	token string
+
+	// regionCounts are the alive cells in each region of the last turn, guarded by mu.
+	regionCounts []int
+
+	// flipped are the cells changed since the last Flips call, guarded by mu. A cell that
+	// changes back drops out again, so flips over several turns are never counted twice.
+	flipped map[util.Cell]struct{}
+
+	// snapshot carries PauseAndSnapshot requests to the running Process loop, which
+	// answers them between turns. finished is closed when that loop returns.
+	snapshot chan chan BrokerPauseAndSnapshotResponse
+	finished chan struct{}
+
+	// step carries Step requests to the Process loop, which only takes them while paused.
+	step chan chan stepResult
+
+	// residentRun is set while a Resident run is in progress, guarded by mu.
+	residentRun bool
+
+	// last is the outcome of the last run to finish, guarded by mu.
+	last runResult
+
+	// final is the last board of a Banded run, guarded by mu, until FetchBand has fetched
+	// its last row.
+	final finalBoard
+
+	// stream are the turns of a run started with Stream that NextTurns has yet to take, and
+	// streamTaken is closed and replaced whenever it takes them, all guarded by mu.
+	stream      []TurnFlips
+	streaming   bool
+	streamTaken chan struct{}
+}
+
+// stepResult is what a paused run answered Step with.
+type stepResult struct {
+	res BrokerStepResponse
+	err error
+}
+
+// runResult is what a run answered Process with.
+type runResult struct {
+	res BrokerProcessResponse
+	err error
+}
+
+func newSession() *session {
+	return &session{
+		quit:     make(chan bool),
+		snapshot: make(chan chan BrokerPauseAndSnapshotResponse),
+		step:     make(chan chan stepResult),
+	}
+}
+
+// ErrUnknownSession is returned for a SessionID the broker did not give out, or has since
+// ended with Quit.
+var ErrUnknownSession = errors.New("unknown session")
+
+// Start begins a session of its own for the caller, whose SessionID it must pass to Process
+// and the RPCs that follow, until Quit ends it.
+func (b *BrokerService) Start(req BrokerStartRequest, res *BrokerStartResponse) (err error) {
+	b.touch()
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+	res.SessionID = hex.EncodeToString(id)
+
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+	if b.sessions == nil {
+		b.sessions = make(map[string]*session)
+	}
+	b.sessions[res.SessionID] = newSession()
+	return
+}
+
+// sessionFor returns the session with the given id, or the broker's own for an empty one.
+func (b *BrokerService) sessionFor(id string) (*session, error) {
+	if id == "" {
+		return &b.session, nil
+	}
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+	s, ok := b.sessions[id]
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+	return s, nil
+}
+
+// endSession forgets the session with the given id, once its run has stopped.
+func (b *BrokerService) endSession(id string) {
+	b.sessionsMu.Lock()
+	delete(b.sessions, id)
+	b.sessionsMu.Unlock()
+}
+
+// allSessions returns the broker's own session followed by every started one.
+func (b *BrokerService) allSessions() []*session {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+	all := []*session{&b.session}
+	for _, s := range b.sessions {
+		all = append(all, s)
+	}
+	return all
+}
+
+// checkpointPath is the file the session with the given id is checkpointed to, CheckpointPath
+// itself for the broker's own.
+func (b *BrokerService) checkpointPath(id string) string {
+	if id == "" {
+		return b.CheckpointPath
+	}
+	return b.CheckpointPath + "." + id
+}
+
+// anyRunning reports whether any session has a run in progress.
+func (b *BrokerService) anyRunning() bool {
+	for _, s := range b.allSessions() {
+		s.mu.RLock()
+		running := s.running
+		s.mu.RUnlock()
+		if running {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestConcurrentSessions runs a different board in each of two sessions at once and checks
+// each ends with its own board evolved.
+func TestConcurrentSessions(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+
+	worlds := []World{randomWorld(16, 16, 50), randomWorld(24, 8, 51)}
+	results := make([]World, len(worlds))
+	errs := make([]error, len(worlds))
+	var wg sync.WaitGroup
+	for i, world := range worlds {
+		started := new(BrokerStartResponse)
+		if err := b.Start(BrokerStartRequest{}, started); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int, world World, id string) {
+			defer wg.Done()
+			res := new(BrokerProcessResponse)
+			errs[i] = b.Process(BrokerProcessRequest{SessionID: id, Turns: 20, World: world}, res)
+			results[i] = res.World
+		}(i, world, started.SessionID)
+	}
+	wg.Wait()
+
+	for i, world := range worlds {
+		if errs[i] != nil {
+			t.Fatalf("session %v: %v", i, errs[i])
+		}
+		assertEqualWorld(t, results[i], evolve(world, 20))
+	}
+}
+
+// TestQuitEndsSession checks Quit forgets a session, so its ID is no longer accepted.
+func TestQuitEndsSession(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+
+	started := new(BrokerStartResponse)
+	if err := b.Start(BrokerStartRequest{}, started); err != nil {
+		t.Fatal(err)
+	}
+	id := started.SessionID
+	if err := b.Process(BrokerProcessRequest{SessionID: id, Turns: 3, World: randomWorld(8, 8, 52), Token: "a"}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Report(BrokerReportRequest{SessionID: id}, new(BrokerReportResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Quit(BrokerQuitRequest{SessionID: id, Token: "a"}, new(BrokerQuitResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.sessions) != 0 {
+		t.Errorf("expected no sessions after Quit, got %v", len(b.sessions))
+	}
+	if err := b.Report(BrokerReportRequest{SessionID: id}, new(BrokerReportResponse)); err != ErrUnknownSession {
+		t.Errorf("expected ErrUnknownSession after Quit, got %v", err)
+	}
+}
+
+// TestUnknownSession checks an ID the broker never gave out is rejected.
+func TestUnknownSession(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	err := b.Process(BrokerProcessRequest{SessionID: "missing", Turns: 1, World: randomWorld(8, 8, 53)}, new(BrokerProcessResponse))
+	if err != ErrUnknownSession {
+		t.Errorf("expected ErrUnknownSession, got %v", err)
+	}
+}
+
+// TestResume checks a resumed Process answers with the session's finished run rather than
+// running it again, and starts the run if it never was.
+func TestResume(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	world := randomWorld(16, 16, 54)
+
+	started := new(BrokerStartResponse)
+	if err := b.Start(BrokerStartRequest{}, started); err != nil {
+		t.Fatal(err)
+	}
+	req := BrokerProcessRequest{SessionID: started.SessionID, Turns: 6, World: world, Token: "a", Resume: true}
+	if err := b.Process(req, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	res := new(BrokerProcessResponse)
+	if err := b.Process(req, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Turns != 6 {
+		t.Errorf("expected the finished run's 6 turns, got %v", res.Turns)
+	}
+	assertEqualWorld(t, res.World, evolve(world, 6))
+
+	req.Token = "b"
+	if err := b.Process(req, new(BrokerProcessResponse)); err != ErrNotController {
+		t.Errorf("expected ErrNotController resuming with another token, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// runStats tallies the traffic of a run and how its regions were shared out, for the summary
+// returned with its result. A nil runStats tallies nothing.
+type runStats struct {
+	bytes     int64
+	failovers int64
+
+	mu      sync.Mutex
+	regions map[string]int64
+}
+
+func newRunStats() *runStats {
+	return &runStats{regions: make(map[string]int64)}
+}
+
+// computed records a region computed by the worker at address.
+func (s *runStats) computed(address string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.regions[address]++
+	s.mu.Unlock()
+}
+
+// failedOver records a region moved on from a failed worker.
+func (s *runStats) failedOver() {
+	if s != nil {
+		atomic.AddInt64(&s.failovers, 1)
+	}
+}
+
+// report copies the tallies so far into res.
+func (s *runStats) report(res *BrokerProcessResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res.Bytes = atomic.LoadInt64(&s.bytes)
+	res.Failovers = atomic.LoadInt64(&s.failovers)
+	res.WorkerRegions = make(map[string]int64, len(s.regions))
+	for address, count := range s.regions {
+		res.WorkerRegions[address] = count
+	}
+}
+
+// countingDialer is a Dialer adding the bytes sent and received over its connections to bytes.
+type countingDialer struct {
+	dialer Dialer
+	bytes  *int64
+}
+
+func (d countingDialer) Dial(address string) (net.Conn, error) {
+	dialer := d.dialer
+	if dialer == nil {
+		dialer = TCPDialer{}
+	}
+	conn, err := dialer.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: conn, bytes: d.bytes}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	bytes *int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.bytes, int64(n))
+	return n, err
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(c.bytes, int64(n))
+	return n, err
+}
@@ -0,0 +1,68 @@
+package main
+
+import "time"
+
+// StreamBacklog is how many turns a streaming run computes ahead of its client before it
+// waits for the client to take them, keeping the two close to lockstep.
+var StreamBacklog = 16
+
+// startStreamLocked clears the turns kept for the last run, and keeps them for the next one
+// when it streams. The caller holds mu.
+func (s *session) startStreamLocked(stream bool) {
+	s.stream = nil
+	s.streaming = stream
+	s.streamTaken = make(chan struct{})
+}
+
+// streamLocked keeps turn for NextTurns. The caller holds mu.
+func (s *session) streamLocked(turn TurnFlips) {
+	s.stream = append(s.stream, turn)
+}
+
+// takeTurnsLocked returns the turns kept since they were last taken, and wakes a run waiting
+// for them to be. The caller holds mu.
+func (s *session) takeTurnsLocked() []TurnFlips {
+	turns := s.stream
+	s.stream = nil
+	close(s.streamTaken)
+	s.streamTaken = make(chan struct{})
+	return turns
+}
+
+// NextTurns returns the turns a streaming run has completed since the last call, each with
+// the cells it flipped, waiting up to req.Timeout for one if there are none yet. A client
+// calling it in a loop sees every turn in order, so a live view can show them one by one,
+// until res.Finished. It may be called before the run starts, and waits for it.
+func (b *BrokerService) NextTurns(req BrokerNextTurnsRequest, res *BrokerNextTurnsResponse) (err error) {
+	b.touch()
+	s, err := b.sessionFor(req.SessionID)
+	if err != nil {
+		return
+	}
+	timeout := req.Timeout
+	if timeout <= 0 || timeout > MaxStateChangeWait {
+		timeout = MaxStateChangeWait
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		s.mu.Lock()
+		// A streaming run that has finished has no more turns to wait for.
+		finished := s.streaming && !s.running
+		if len(s.stream) > 0 || finished {
+			res.Turns = s.takeTurnsLocked()
+			res.Finished = finished
+			s.mu.Unlock()
+			return
+		}
+		changed := s.changedLocked()
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return
+		}
+	}
+}
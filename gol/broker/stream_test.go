@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestNextTurns streams a run and checks NextTurns hands over every turn in order, each with
+// exactly the cells it flipped, and then that the run has finished.
+func TestNextTurns(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}), startWorker(t, &testWorker{}))
+	const turns = 30
+	world := randomWorld(16, 16, 61)
+	done := make(chan error, 1)
+	go func() {
+		// Called before the run starts, as a client's reporter may be.
+		time.Sleep(20 * time.Millisecond)
+		done <- b.Process(BrokerProcessRequest{Turns: turns, World: world, Stream: true}, new(BrokerProcessResponse))
+	}()
+
+	var streamed []TurnFlips
+	for {
+		next := new(BrokerNextTurnsResponse)
+		if err := b.NextTurns(BrokerNextTurnsRequest{Timeout: time.Second}, next); err != nil {
+			t.Fatal(err)
+		}
+		streamed = append(streamed, next.Turns...)
+		if next.Finished {
+			break
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streamed) != turns {
+		t.Fatalf("expected %v turns streamed, got %v", turns, len(streamed))
+	}
+	before := world
+	for i, turn := range streamed {
+		after := evolve(before, 1)
+		if turn.Turns != int64(i+1) || turn.CellsCount != len(after.alive()) {
+			t.Fatalf("expected turn %v with %v alive, got turn %v with %v", i+1, len(after.alive()), turn.Turns, turn.CellsCount)
+		}
+		for _, cell := range turn.Cells {
+			after.Field.Data[cell.Y][cell.X].Alive = !after.Field.Data[cell.Y][cell.X].Alive
+		}
+		// Flipping the turn's cells back must give the turn before it.
+		assertEqualWorld(t, after, before)
+		before = evolve(before, 1)
+	}
+}
+
+// TestStreamBacklog checks a streaming run whose turns are not taken stops StreamBacklog
+// turns ahead, and carries on once they are.
+func TestStreamBacklog(t *testing.T) {
+	defer func(backlog int) { StreamBacklog = backlog }(StreamBacklog)
+	StreamBacklog = 4
+
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Process(BrokerProcessRequest{Turns: 1000000, World: randomWorld(16, 16, 62), Stream: true, Token: "a"}, new(BrokerProcessResponse))
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	held := new(BrokerWaitStateChangeResponse)
+	if err := b.WaitStateChange(BrokerWaitStateChangeRequest{}, held); err != nil {
+		t.Fatal(err)
+	}
+	if held.Turns != 4 {
+		t.Errorf("expected the run held at turn 4 with none taken, got turn %v", held.Turns)
+	}
+
+	next := new(BrokerNextTurnsResponse)
+	if err := b.NextTurns(BrokerNextTurnsRequest{}, next); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Turns) != 4 || next.Turns[3].Turns != 4 {
+		t.Errorf("expected turns 1 to 4, got %v turns", len(next.Turns))
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := b.WaitStateChange(BrokerWaitStateChangeRequest{}, held); err != nil {
+		t.Fatal(err)
+	}
+	if held.Turns != 8 {
+		t.Errorf("expected the run to carry on to turn 8, got turn %v", held.Turns)
+	}
+
+	b.Quit(BrokerQuitRequest{Token: "a"}, new(BrokerQuitResponse))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// defaultWebThumbnail and maxWebThumbnail bound the size of the thumbnails /state serves.
+const (
+	defaultWebThumbnail = 128
+	maxWebThumbnail     = 512
+)
+
+//go:embed web/index.html
+var indexHTML []byte
+
+// webState is the JSON the web UI polls, with the thumbnail rows base64 encoded.
+type webState struct {
+	Turns      int64     `json:"turns"`
+	CellsCount int       `json:"cellsCount"`
+	Running    bool      `json:"running"`
+	Paused     bool      `json:"paused"`
+	Thumbnail  [][]uint8 `json:"thumbnail"`
+}
+
+// serveWeb adds the web UI to mux, a page at / drawing the board from the JSON at /state.
+func (b *BrokerService) serveWeb(mux *http.ServeMux) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexHTML)
+	})
+	mux.HandleFunc("/state", b.serveState)
+}
+
+// serveState writes the last completed turn as a webState, with a thumbnail no larger than
+// the size query parameter.
+func (b *BrokerService) serveState(w http.ResponseWriter, r *http.Request) {
+	size := defaultWebThumbnail
+	if query := r.URL.Query().Get("size"); query != "" {
+		var err error
+		if size, err = strconv.Atoi(query); err != nil || size <= 0 {
+			http.Error(w, "invalid size "+query, http.StatusBadRequest)
+			return
+		}
+	}
+	if size > maxWebThumbnail {
+		size = maxWebThumbnail
+	}
+
+	turns, world := b.board()
+	b.mu.RLock()
+	state := webState{
+		Turns:      turns,
+		CellsCount: len(world.alive()),
+		Running:    b.running,
+		Paused:     b.isPaused,
+		Thumbnail:  world.thumbnail(size),
+	}
+	b.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestWebState runs a few turns and checks the JSON the web UI polls holds the turn reached,
+// the alive count and a thumbnail of the board, and that the page itself is served.
+func TestWebState(t *testing.T) {
+	b := newTestBroker(startWorker(t, &testWorker{}))
+	mux := http.NewServeMux()
+	b.serveWeb(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	world := randomWorld(64, 32, 24)
+	if err := b.Process(BrokerProcessRequest{Turns: 3, World: world}, new(BrokerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.Get(server.URL + "/state?size=16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var state webState
+	if err := json.NewDecoder(response.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	evolved := evolve(world, 3)
+	if alive := len(evolved.alive()); state.Turns != 3 || state.CellsCount != alive {
+		t.Errorf("expected turn 3 with %d alive, got turn %d with %d", alive, state.Turns, state.CellsCount)
+	}
+	if len(state.Thumbnail) != 16 || len(state.Thumbnail[0]) != 8 {
+		t.Errorf("expected a 8x16 thumbnail, got %d rows", len(state.Thumbnail))
+	}
+
+	page, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Body.Close()
+	body, _ := ioutil.ReadAll(page.Body)
+	if !strings.Contains(string(body), "<canvas") {
+		t.Error("expected the page to hold a canvas")
+	}
+}
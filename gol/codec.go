@@ -0,0 +1,346 @@
+package gol
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// rleLineLength is the longest body line ToRLE writes, as recommended by the format.
+const rleLineLength = 70
+
+var rleHeader = regexp.MustCompile(`^x\s*=\s*(\d+)\s*,\s*y\s*=\s*(\d+)`)
+
+func newWorld(height, width int) World {
+	field := Field{Height: height, Width: width}
+	field.cultivate(height, width)
+	for y := range field.Data {
+		for x := range field.Data[y] {
+			field.Data[y][x] = Cell{X: x, Y: y}
+		}
+	}
+	return World{Field: field, Height: height, Width: width}
+}
+
+// PackedWorld is a world reduced to a bitmap of its alive cells, a bit per cell with the cells
+// in row order, which gob encodes at a sixteenth of the size of a Cell per cell.
+type PackedWorld struct {
+	Width  int
+	Height int
+	Bits   []uint64
+}
+
+// Pack returns world as a PackedWorld.
+func Pack(world World) PackedWorld {
+	packed := PackedWorld{Width: world.Width, Height: world.Height, Bits: make([]uint64, (world.Width*world.Height+63)/64)}
+	for y, row := range world.Field.Data {
+		for x, cell := range row {
+			if cell.Alive {
+				i := y*world.Width + x
+				packed.Bits[i/64] |= 1 << uint(i%64)
+			}
+		}
+	}
+	return packed
+}
+
+// Unpack returns the World packed holds.
+func Unpack(packed PackedWorld) World {
+	world := newWorld(packed.Height, packed.Width)
+	for y, row := range world.Field.Data {
+		for x := range row {
+			i := y*packed.Width + x
+			row[x].Alive = packed.Bits[i/64]>>uint(i%64)&1 == 1
+		}
+	}
+	return world
+}
+
+// ParsePGM reads a pgm image into a world, where cells with a value of 255 are alive.
+func ParsePGM(r io.Reader) (World, error) {
+	image, err := util.ReadPgm(r)
+	if err != nil {
+		return World{}, err
+	}
+	height := len(image)
+	width := 0
+	if height > 0 {
+		width = len(image[0])
+	}
+	world := newWorld(height, width)
+	for y, row := range image {
+		for x, value := range row {
+			world.Field.Data[y][x].Alive = value == 255
+		}
+	}
+	return world, nil
+}
+
+// ToPGM writes world as a pgm image with alive cells set to 255.
+func (world *World) ToPGM(w io.Writer) error {
+	image := make([][]uint8, world.Height)
+	for y := range image {
+		image[y] = make([]uint8, world.Width)
+		for x := range image[y] {
+			if world.Field.Data[y][x].Alive {
+				image[y][x] = 255
+			}
+		}
+	}
+	return util.WritePgm(w, image)
+}
+
+// worldImage shows a world as an image, alive cells black on white, reading its cells as the
+// image is encoded rather than copying them into pixels first.
+type worldImage struct {
+	world *World
+}
+
+func (i worldImage) ColorModel() color.Model {
+	return color.GrayModel
+}
+
+func (i worldImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, i.world.Width, i.world.Height)
+}
+
+func (i worldImage) At(x, y int) color.Color {
+	if i.world.Field.Data[y][x].Alive {
+		return color.Gray{Y: 0}
+	}
+	return color.Gray{Y: 255}
+}
+
+// ToPNG writes world as a png image with alive cells black on white.
+func (world *World) ToPNG(w io.Writer) error {
+	return png.Encode(w, worldImage{world})
+}
+
+// ParseRLE reads a pattern in the run length encoded format used by Golly. The world is
+// sized by the pattern's x = .., y = .. header.
+func ParseRLE(r io.Reader) (World, error) {
+	scanner := bufio.NewScanner(r)
+
+	var world World
+	header := false
+	x, y := 0, 0
+	count := ""
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !header {
+			match := rleHeader.FindStringSubmatch(line)
+			if match == nil {
+				return World{}, fmt.Errorf("expected an x = .., y = .. header, got %q", line)
+			}
+			width, _ := strconv.Atoi(match[1])
+			height, _ := strconv.Atoi(match[2])
+			world = newWorld(height, width)
+			header = true
+			continue
+		}
+
+		for _, r := range line {
+			switch {
+			case r >= '0' && r <= '9':
+				count += string(r)
+				continue
+			case r == ' ' || r == '\t':
+				continue
+			}
+
+			run := 1
+			if count != "" {
+				run, _ = strconv.Atoi(count)
+				count = ""
+			}
+
+			switch r {
+			case '!':
+				return world, nil
+			case '$':
+				x = 0
+				y += run
+			case 'b', '.':
+				x += run
+			default:
+				if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+					return World{}, fmt.Errorf("unexpected %q in pattern", r)
+				}
+				if y >= world.Height || x+run > world.Width {
+					return World{}, fmt.Errorf("pattern exceeds its %vx%v header", world.Width, world.Height)
+				}
+				for i := 0; i < run; i++ {
+					world.Field.Data[y][x+i].Alive = true
+				}
+				x += run
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return World{}, err
+	}
+	if !header {
+		return World{}, errors.New("missing x = .., y = .. header")
+	}
+	return World{}, errors.New("pattern is missing its ! terminator")
+}
+
+// ToRLE writes world as a run length encoded pattern, covering the whole board.
+func (world *World) ToRLE(w io.Writer) error {
+	var tokens []string
+	pendingRows := 0
+	for _, row := range world.Field.Data {
+		var rowTokens []string
+		for x := 0; x < len(row); {
+			run := 1
+			for x+run < len(row) && row[x+run].Alive == row[x].Alive {
+				run++
+			}
+			tag := "b"
+			if row[x].Alive {
+				tag = "o"
+			}
+			rowTokens = append(rowTokens, rleToken(run, tag))
+			x += run
+		}
+		// Trailing dead cells are implied by the end of the row.
+		if len(row) > 0 && !row[len(row)-1].Alive {
+			rowTokens = rowTokens[:len(rowTokens)-1]
+		}
+		if len(rowTokens) > 0 {
+			if pendingRows > 0 {
+				tokens = append(tokens, rleToken(pendingRows, "$"))
+			}
+			tokens = append(tokens, rowTokens...)
+			pendingRows = 0
+		}
+		pendingRows++
+	}
+	tokens = append(tokens, "!")
+
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "x = %d, y = %d, rule = B3/S23\n", world.Width, world.Height)
+	length := 0
+	for _, token := range tokens {
+		if length+len(token) > rleLineLength {
+			buffered.WriteString("\n")
+			length = 0
+		}
+		buffered.WriteString(token)
+		length += len(token)
+	}
+	buffered.WriteString("\n")
+	return buffered.Flush()
+}
+
+// LoadWorld reads a world from a pgm file, or a run length encoded one if its extension is .rle.
+func LoadWorld(path string) (World, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return World{}, err
+	}
+	defer file.Close()
+	if strings.EqualFold(filepath.Ext(path), ".rle") {
+		return ParseRLE(file)
+	}
+	return ParsePGM(file)
+}
+
+// centred returns the world in the middle of an otherwise dead height x width board, or an
+// error if it does not fit.
+func (world *World) centred(height, width int) (World, error) {
+	if world.Height > height || world.Width > width {
+		return World{}, fmt.Errorf("a %vx%v pattern does not fit on a %vx%v board", world.Width, world.Height, width, height)
+	}
+	board := newWorld(height, width)
+	top, left := (height-world.Height)/2, (width-world.Width)/2
+	for y, row := range world.Field.Data {
+		for x, cell := range row {
+			board.Field.Data[top+y][left+x].Alive = cell.Alive
+		}
+	}
+	return board, nil
+}
+
+// Diff returns the cells whose state differs between world and other, which must be the same size.
+func (world *World) Diff(other World) ([]util.Cell, error) {
+	if world.Height != other.Height || world.Width != other.Width {
+		return nil, fmt.Errorf("cannot compare a %vx%v board with a %vx%v one", world.Width, world.Height, other.Width, other.Height)
+	}
+	var cells []util.Cell
+	for y := 0; y < world.Height; y++ {
+		for x := 0; x < world.Width; x++ {
+			if world.Field.Data[y][x].Alive != other.Field.Data[y][x].Alive {
+				cells = append(cells, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	return cells, nil
+}
+
+// aliveJSON is the JSON WriteAliveJSON writes, such as {"turns":10,"alive":[{"x":1,"y":2}]}.
+type aliveJSON struct {
+	Turns int         `json:"turns"`
+	Alive []aliveCell `json:"alive"`
+}
+
+type aliveCell struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// WriteAliveJSON writes the alive cells at turns as a JSON object.
+func WriteAliveJSON(w io.Writer, turns int, alive []util.Cell) error {
+	output := aliveJSON{Turns: turns, Alive: make([]aliveCell, len(alive))}
+	for i, cell := range alive {
+		output.Alive[i] = aliveCell{X: cell.X, Y: cell.Y}
+	}
+	return json.NewEncoder(w).Encode(output)
+}
+
+// summaryJSON is the JSON WriteSummaryJSON writes.
+type summaryJSON struct {
+	Turns          int              `json:"turns"`
+	AliveCells     int              `json:"aliveCells"`
+	ElapsedSeconds float64          `json:"elapsedSeconds"`
+	TurnsPerSecond float64          `json:"turnsPerSecond"`
+	Bytes          int64            `json:"bytes"`
+	WorkerRegions  map[string]int64 `json:"workerRegions"`
+	Failovers      int64            `json:"failovers"`
+}
+
+// WriteSummaryJSON writes summary as a JSON object.
+func WriteSummaryJSON(w io.Writer, summary RunSummary) error {
+	return json.NewEncoder(w).Encode(summaryJSON{
+		Turns:          summary.CompletedTurns,
+		AliveCells:     summary.AliveCells,
+		ElapsedSeconds: summary.Elapsed.Seconds(),
+		TurnsPerSecond: summary.TurnsPerSecond,
+		Bytes:          summary.Bytes,
+		WorkerRegions:  summary.WorkerRegions,
+		Failovers:      summary.Failovers,
+	})
+}
+
+func rleToken(run int, tag string) string {
+	if run == 1 {
+		return tag
+	}
+	return strconv.Itoa(run) + tag
+}
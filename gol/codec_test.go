@@ -0,0 +1,147 @@
+package gol
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+func TestParseRLE(t *testing.T) {
+	world, err := ParseRLE(strings.NewReader("#C glider\nx = 4, y = 3, rule = B3/S23\nbo$2bo$3o!\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if world.Width != 4 || world.Height != 3 {
+		t.Fatalf("expected a 4x3 world, got %vx%v", world.Width, world.Height)
+	}
+	expected := []string{".#..", "..#.", "###."}
+	for y, row := range expected {
+		for x, c := range row {
+			if world.Field.Data[y][x].Alive != (c == '#') {
+				t.Errorf("cell (%d, %d) should be alive=%v", x, y, c == '#')
+			}
+		}
+	}
+}
+
+func TestParseRLEMalformed(t *testing.T) {
+	for name, pattern := range map[string]string{
+		"no header":     "bo$2bo$3o!",
+		"no terminator": "x = 3, y = 3\nbo$2bo$3o",
+		"too wide":      "x = 2, y = 1\n3o!",
+		"bad tag":       "x = 3, y = 1\n2o?!",
+	} {
+		if _, err := ParseRLE(strings.NewReader(pattern)); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
+
+func TestRLERoundTrip(t *testing.T) {
+	world := newWorld(40, 100)
+	for y := 0; y < world.Height; y++ {
+		for x := 0; x < world.Width; x++ {
+			world.Field.Data[y][x].Alive = (x*7+y*3)%5 == 0 || y == 20
+		}
+	}
+
+	var rle bytes.Buffer
+	if err := world.ToRLE(&rle); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(rle.String(), "\n") {
+		if len(line) > rleLineLength && !strings.HasPrefix(line, "x") {
+			t.Errorf("line longer than %d characters: %q", rleLineLength, line)
+		}
+	}
+
+	parsed, err := ParseRLE(&rle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := range world.Field.Data {
+		for x := range world.Field.Data[y] {
+			if parsed.Field.Data[y][x].Alive != world.Field.Data[y][x].Alive {
+				t.Fatalf("cell (%d, %d) changed in the round trip", x, y)
+			}
+		}
+	}
+}
+
+// TestCentred places a pattern in the middle of a larger board, and refuses a board too small.
+func TestCentred(t *testing.T) {
+	pattern, err := ParseRLE(strings.NewReader("x = 4, y = 1\n24bo!\n"))
+	if err == nil {
+		t.Fatal("expected a 24 cell run to overflow a 4 cell header")
+	}
+	pattern, err = ParseRLE(strings.NewReader("x = 24, y = 2\n24o$o!\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	board, err := pattern.centred(6, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alive := board.alive()
+	if len(alive) != 25 || alive[0] != (util.Cell{X: 3, Y: 2}) || alive[23] != (util.Cell{X: 26, Y: 2}) || alive[24] != (util.Cell{X: 3, Y: 3}) {
+		t.Errorf("expected 24 cells of row 2 from column 3 and one at (3, 3), got %v", alive)
+	}
+	if _, err := pattern.centred(6, 23); err == nil {
+		t.Error("expected a 24 cell wide pattern not to fit on a 23 cell wide board")
+	}
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	world := newWorld(13, 70)
+	for y := 0; y < world.Height; y++ {
+		for x := 0; x < world.Width; x++ {
+			world.Field.Data[y][x].Alive = (x*5+y*11)%7 < 2
+		}
+	}
+
+	packed := Pack(world)
+	if len(packed.Bits) != (13*70+63)/64 {
+		t.Fatalf("expected %d words, got %d", (13*70+63)/64, len(packed.Bits))
+	}
+	unpacked := Unpack(packed)
+	if unpacked.Width != world.Width || unpacked.Height != world.Height {
+		t.Fatalf("expected a %vx%v world, got %vx%v", world.Width, world.Height, unpacked.Width, unpacked.Height)
+	}
+	for y := range world.Field.Data {
+		for x := range world.Field.Data[y] {
+			if unpacked.Field.Data[y][x] != world.Field.Data[y][x] {
+				t.Fatalf("cell (%d, %d) changed in the round trip", x, y)
+			}
+		}
+	}
+}
+
+// BenchmarkPackedWorld compares the encoded size of a 5120x5120 board sent as cells with the
+// same board packed a bit per cell.
+func BenchmarkPackedWorld(b *testing.B) {
+	world := newWorld(5120, 5120)
+	for y := 0; y < world.Height; y++ {
+		for x := 0; x < world.Width; x++ {
+			world.Field.Data[y][x].Alive = (x*7+y*3)%5 == 0
+		}
+	}
+
+	var cellBytes, packedBytes int
+	for i := 0; i < b.N; i++ {
+		var cells, packed bytes.Buffer
+		if err := gob.NewEncoder(&cells).Encode(world); err != nil {
+			b.Fatal(err)
+		}
+		if err := gob.NewEncoder(&packed).Encode(Pack(world)); err != nil {
+			b.Fatal(err)
+		}
+		cellBytes, packedBytes = cells.Len(), packed.Len()
+	}
+	b.ReportMetric(float64(cellBytes), "cell-bytes")
+	b.ReportMetric(float64(packedBytes), "packed-bytes")
+	b.ReportMetric(float64(cellBytes)/float64(packedBytes), "ratio")
+}
@@ -1,9 +1,16 @@
 package gol
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
-	"net/rpc"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"uk.ac.bris.cs/gameoflife/util"
@@ -12,6 +19,10 @@ import (
 const (
 	DefaultHaloOffset = 1
 	InitialDelay      = 2 * time.Second
+	ReportInterval    = 2 * time.Second
+	DefaultBrokerAddr = "3.80.182.42:8030"
+	// BrokerEnv is the environment variable naming the broker when Params.BrokerAddr is empty.
+	BrokerEnv = "GOL_BROKER"
 )
 
 type distributorChannels struct {
@@ -21,7 +32,13 @@ type distributorChannels struct {
 	ioFilename chan<- string
 	ioOutput   chan<- uint8
 	ioInput    <-chan uint8
+	ioInputErr <-chan error
 	keyPresses <-chan rune
+	done       chan struct{}
+
+	// saving is held while an image is streamed to the io goroutine, so saves from the
+	// reporter, keypresses and signals don't interleave their output.
+	saving *sync.Mutex
 }
 
 type (
@@ -45,67 +62,230 @@ type (
 )
 
 type Reporter struct {
-	EventsCh       chan<- Event
+	EventsCh chan<- Event
+	// InitialDelay is how long after the reporter starts the first report is sent, and
+	// ReportInterval how long after each report the next one is.
+	InitialDelay   time.Duration
 	ReportInterval time.Duration
+	Mode           ReportMode
 	Stop           chan bool
+	Done           <-chan struct{}
+
+	// stopOnce closes Stop, so stopping never blocks and may be repeated.
+	stopOnce sync.Once
+
+	// SaveEvery saves the world on every SaveEvery-th report, when above zero.
+	SaveEvery int
+	channels  distributorChannels
+
+	// StartTurn is added to the turns the broker reports, for a run resumed from an earlier one.
+	StartTurn int
+
+	// TotalTurns is how many turns the run was asked for, or 0 when there is no total to
+	// measure progress against.
+	TotalTurns int
+
+	// SessionID is the broker session the run is in, passed on every report.
+	SessionID string
+
+	// streamed is closed when stream returns, and stopped when start does.
+	streamed chan struct{}
+	stopped  chan struct{}
 }
 
 type (
+	BrokerStartRequest struct{}
+
+	BrokerStartResponse struct {
+		SessionID string
+	}
+
 	BrokerProcessRequest struct {
-		Turns int
-		World World
+		// SessionID is the session from Start, or empty on a broker without sessions.
+		SessionID string
+		Turns     int64
+		// Packed is the board to start from, packed so it is quicker to send.
+		Packed PackedWorld
+		// Token is required by the control RPCs for the rest of the run, so only the
+		// client that started it can pause, quit or shut it down.
+		Token string
+		// Boundary is what lies beyond the edges of the board, Toroidal when empty.
+		Boundary Boundary
+		// Rule is the rule the board evolves by, Conway's when zero.
+		Rule Rule
+		// Resume waits for the session's run instead of starting one, after the connection
+		// to the broker dropped while waiting for it.
+		Resume bool
+		// Stream keeps every turn's flips on the broker for NextTurns.
+		Stream bool
+		// Banded keeps the final board on the broker for FetchBand instead of answering with it.
+		Banded bool
 	}
 
 	BrokerProcessResponse struct {
-		World World
-		Turns int
+		// Packed is the final board of a packed request, and World is empty.
+		Packed PackedWorld
+		World  World
+		// Turns is how many turns this call completed, fewer than requested if it was stopped early.
+		Turns int64
+		// Bytes is the traffic between the broker and its workers during the run.
+		Bytes int64
+		// WorkerRegions is how many regions each worker computed, by address.
+		WorkerRegions map[string]int64
+		// Failovers is how many times a region was moved on from a failed worker.
+		Failovers int64
 	}
 
 	BrokerReportResponse struct {
-		Turns      int
+		Turns      int64
 		CellsCount int
 		World      World
 	}
 
-	BrokerSaveRequest struct{}
+	BrokerSaveRequest struct {
+		SessionID string
+	}
 
 	BrokerSaveResponse struct {
-		Turns int
+		Turns int64
 		World World
 	}
 
-	BrokerQuitRequest struct{}
+	BrokerQuitRequest struct {
+		SessionID string
+		Token     string
+	}
 
 	BrokerQuitResponse struct {
-		Turns int
+		Turns int64
+	}
+
+	BrokerReportRequest struct {
+		SessionID string
 	}
 
-	BrokerReportRequest struct{}
+	BrokerFlipsRequest struct {
+		SessionID string
+	}
+
+	BrokerFlipsResponse struct {
+		// Turns is the turn the flips bring the board up to, however many turns they span.
+		Turns      int64
+		CellsCount int
+		// Cells are the cells whose state has changed since the last call.
+		Cells []util.Cell
+	}
 
 	BrokerShutdownResponse struct {
-		Turns int
+		Turns int64
 	}
 
-	BrokerShutdownRequest struct{}
+	BrokerShutdownRequest struct {
+		SessionID string
+		Token     string
+	}
 
-	BrokerPauseRequest struct{}
+	BrokerPauseRequest struct {
+		SessionID string
+		Token     string
+	}
 
 	BrokerPauseResponse struct {
-		Turns    int
+		Turns    int64
 		IsPaused bool
 	}
 
+	BrokerStepRequest struct {
+		SessionID string
+		Token     string
+	}
+
+	BrokerStepResponse struct {
+		// Stepped is false when the run was not paused, and no turn was computed.
+		Stepped    bool
+		Turns      int64
+		CellsCount int
+		World      World
+		// Cells are the cells flipped since the last Flips call, including the step's.
+		Cells []util.Cell
+	}
+
+	BrokerNextTurnsRequest struct {
+		SessionID string
+		Timeout   time.Duration
+	}
+
+	BrokerNextTurnsResponse struct {
+		// Turns are the turns completed since the last call, oldest first.
+		Turns []TurnFlips
+		// Finished is set once the run has finished and every turn of it has been taken.
+		Finished bool
+	}
+
+	// TurnFlips are the cells a single turn flipped.
+	TurnFlips struct {
+		Turns      int64
+		CellsCount int
+		Cells      []util.Cell
+	}
+
+	BrokerGetWorldRequest struct {
+		SessionID string
+	}
+
+	BrokerGetWorldResponse struct {
+		Turns int64
+		World World
+	}
+
+	BrokerFetchBandRequest struct {
+		SessionID string
+		StartRow  int
+		EndRow    int
+	}
+
+	BrokerFetchBandResponse struct {
+		Turns  int64
+		Height int
+		Width  int
+		Rows   [][]Cell
+	}
+
+	BrokerGetThumbnailRequest struct {
+		SessionID string
+		MaxDim    int
+	}
+
+	BrokerGetThumbnailResponse struct {
+		Turns int64
+		Image [][]uint8
+	}
+
+	BrokerPauseAndSnapshotRequest struct {
+		SessionID string
+	}
+
+	BrokerPauseAndSnapshotResponse struct {
+		Turns      int64
+		CellsCount int
+		World      World
+	}
+
 	BrokerService struct {
-		Turns      int
+		Turns      int64
 		CellsCount int
 		World      World
 	}
 )
 
+var BrokerStart = "BrokerService.Start"
+
 var BrokerProcess = "BrokerService.Process"
 
 var BrokerReport = "BrokerService.Report"
 
+var BrokerFlips = "BrokerService.Flips"
+
 var BrokerSave = "BrokerService.Save"
 
 var BrokerQuit = "BrokerService.Quit"
@@ -114,6 +294,60 @@ var BrokerShutdown = "BrokerService.Shutdown"
 
 var BrokerPause = "BrokerService.Pause"
 
+var BrokerStep = "BrokerService.Step"
+
+var BrokerNextTurns = "BrokerService.NextTurns"
+
+var BrokerPauseAndSnapshot = "BrokerService.PauseAndSnapshot"
+
+var BrokerGetWorld = "BrokerService.GetWorld"
+
+var BrokerGetThumbnail = "BrokerService.GetThumbnail"
+
+var BrokerFetchBand = "BrokerService.FetchBand"
+
+// ThumbnailLevels are the largest sides of the thumbnails saved by the keys '1' to '4'.
+var ThumbnailLevels = []int{64, 128, 256, 512}
+
+// emit sends event to the consumer, unless done has been closed because the consumer is gone,
+// in which case the event is dropped and false is returned.
+func (c distributorChannels) emit(event Event) bool {
+	select {
+	case c.events <- event:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// abort ends a run that could not be carried out, with err as a RunError at turn.
+func (c distributorChannels) abort(turn int, err error) {
+	c.emit(RunError{CompletedTurns: turn, Err: err.Error()})
+	c.emit(StateChange{CompletedTurns: turn, NewState: Quitting})
+	close(c.events)
+}
+
+// brokerAddress is the address of the broker to run on: p.BrokerAddr, or else the BrokerEnv
+// environment variable, or else DefaultBrokerAddr. It must be host:port, where an empty host
+// is this machine.
+func brokerAddress(p Params) (string, error) {
+	addr := p.BrokerAddr
+	if addr == "" {
+		addr = os.Getenv(BrokerEnv)
+	}
+	if addr == "" {
+		addr = DefaultBrokerAddr
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("broker address %q: %v", addr, err)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return "", fmt.Errorf("broker address %q: port must be a number from 1 to 65535", addr)
+	}
+	return addr, nil
+}
+
 func (field *Field) cultivate(height, width int) Field {
 	land := make([][]Cell, height)
 	for i := range land {
@@ -123,18 +357,58 @@ func (field *Field) cultivate(height, width int) Field {
 	return *field
 }
 
-func (world *World) populate(c distributorChannels) {
-	flipped := []util.Cell{}
+// InputTimeout is how long reading the input image from the io goroutine may go without a
+// cell arriving before it is given up on, as an image too short for the board would otherwise
+// be waited on forever. A large image read slowly but steadily is never given up on.
+var InputTimeout = 10 * time.Second
+
+// populate reads the world from the io goroutine, flipping its alive cells at turn when emitFlips
+// is set. It returns the error the io goroutine reports instead of the image, if any.
+func (world *World) populate(c distributorChannels, emitFlips bool, maxFlips, turn int) error {
+	timeout := time.NewTimer(InputTimeout)
+	defer timeout.Stop()
+	// Resetting the timer for every cell would slow the read, so each time it fires it is
+	// only given up on if no cell has arrived since it last fired.
+	read, readAtTimeout := 0, -1
 	for y := 0; y < world.Height; y++ {
 		for x := 0; x < world.Width; x++ {
-			cell := <-c.ioInput
-			world.Field.Data[y][x] = Cell{X: x, Y: y, Alive: cell == 255}
-			if cell == 255 {
-				c.events <- CellFlipped{0, util.Cell{X: x, Y: y}}
-				flipped = append(flipped, util.Cell{X: x, Y: y})
+			var cell uint8
+			for received := false; !received; {
+				select {
+				case cell = <-c.ioInput:
+					received = true
+				case err := <-c.ioInputErr:
+					return err
+				case <-timeout.C:
+					if read == readAtTimeout {
+						return fmt.Errorf("reading the %vx%v input image timed out after %v without a cell, having read %v of its cells",
+							world.Width, world.Height, InputTimeout, read)
+					}
+					readAtTimeout = read
+					timeout.Reset(InputTimeout)
+				}
 			}
+			read++
+			world.Field.Data[y][x] = Cell{X: x, Y: y, Alive: cell == 255}
 		}
 	}
+	if emitFlips {
+		world.flipAlive(c, maxFlips, turn)
+	}
+	return nil
+}
+
+// flipAlive flips the world's alive cells at turn. Past maxFlips flips, if maxFlips is positive,
+// a single TurnRefresh is sent instead.
+func (world *World) flipAlive(c distributorChannels, maxFlips, turn int) {
+	alive := world.alive()
+	if maxFlips > 0 && len(alive) > maxFlips {
+		c.emit(TurnRefresh{turn, alive})
+		return
+	}
+	for _, cell := range alive {
+		c.emit(CellFlipped{turn, cell})
+	}
 }
 
 func aliveCellsInRow(row []Cell, y int) []util.Cell {
@@ -155,35 +429,216 @@ func (world *World) alive() []util.Cell {
 	return alive
 }
 
-func (reporter *Reporter) start(client *rpc.Client) {
-	initialDelay := time.After(InitialDelay)
-	ticker := time.NewTicker(reporter.ReportInterval)
-	defer ticker.Stop()
+// progress returns the Progress event for a run that has completed turns of total,
+// indeterminate when total is unknown or has been overrun.
+func progress(turns int64, total, startTurn int) Progress {
+	event := Progress{CompletedTurns: completedTurn(startTurn, turns), TotalTurns: startTurn + total, Percent: -1}
+	if total > 0 && turns <= int64(total) {
+		event.Percent = float64(turns) * 100 / float64(total)
+	}
+	return event
+}
+
+// completedTurn is the turn a run resumed at startTurn has reached after the broker has
+// completed turns of it. The sum is taken in int64, the width the broker counts turns in, and
+// fits in an int because checkTurns has bounded the run's StartTurn plus Turns, which the
+// broker never completes more of.
+func completedTurn(startTurn int, turns int64) int {
+	return int(int64(startTurn) + turns)
+}
+
+// checkTurns checks the last turn of p, its StartTurn plus Turns, fits in an int, so the
+// turns of its events can't wrap around.
+func checkTurns(p Params) error {
+	// The sum wraps around on overflow, to the other side of StartTurn from the one Turns adds on.
+	if last := p.StartTurn + p.Turns; (p.Turns >= 0) != (last >= p.StartTurn) {
+		return fmt.Errorf("%v turns from turn %v overflows the turn count", p.Turns, p.StartTurn)
+	}
+	return nil
+}
+
+// report asks the broker for the events to emit this interval, ending with the one carrying
+// the turn they were reported at.
+func (reporter *Reporter) report(client *brokerClient) []Event {
+	switch reporter.Mode {
+	case ReportSnapshot:
+		request := BrokerGetWorldRequest{SessionID: reporter.SessionID}
+		response := new(BrokerGetWorldResponse)
+		client.Call(BrokerGetWorld, request, response)
+		return []Event{WorldSnapshot{
+			CompletedTurns: completedTurn(reporter.StartTurn, response.Turns),
+			Alive:          response.World.alive(),
+		}}
+	case ReportFlips:
+		request := BrokerFlipsRequest{SessionID: reporter.SessionID}
+		response := new(BrokerFlipsResponse)
+		client.Call(BrokerFlips, request, response)
+		// The flips may span several turns, so they are all stamped with the last of them.
+		turn := completedTurn(reporter.StartTurn, response.Turns)
+		events := make([]Event, 0, len(response.Cells)+2)
+		for _, cell := range response.Cells {
+			events = append(events, CellFlipped{turn, cell})
+		}
+		return append(events, TurnComplete{turn}, AliveCellsCount{turn, response.CellsCount})
+	}
+
+	request := BrokerReportRequest{SessionID: reporter.SessionID}
+	response := new(BrokerReportResponse)
+	client.Call(BrokerReport, request, response)
+	// log.Printf("Turns: %d, Alive Cells: %d\n", response.Turns, response.CellsCount)
+	return []Event{AliveCellsCount{
+		CompletedTurns: completedTurn(reporter.StartTurn, response.Turns),
+		CellsCount:     response.CellsCount,
+	}}
+}
+
+// stop ends the reports, and waits for start or stream to return, so no event is sent after
+// it. A report in flight is waited for, but stopping a reporter that has already returned
+// never blocks, and stop may be called more than once.
+func (reporter *Reporter) stop() {
+	reporter.stopOnce.Do(func() { close(reporter.Stop) })
+	if reporter.stopped != nil {
+		<-reporter.stopped
+	}
+	if reporter.streamed != nil {
+		<-reporter.streamed
+	}
+}
+
+// stopping reports whether stop has been called, without waiting.
+func (reporter *Reporter) stopping() bool {
+	select {
+	case <-reporter.Stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (reporter *Reporter) start(client *brokerClient) {
+	if reporter.stopped != nil {
+		defer close(reporter.stopped)
+	}
+	// The ticker only starts with the first report, so the reports after it are evenly spaced
+	// from it whatever InitialDelay is.
+	initialDelay := time.NewTimer(reporter.InitialDelay)
+	defer initialDelay.Stop()
+	var tick <-chan time.Time
+	reports := 0
 
 	for {
 		select {
-		case <-initialDelay:
-			// Initial delay elapsed, start reporting
-		case <-ticker.C:
-			request := BrokerReportRequest{}
-			response := new(BrokerReportResponse)
-			client.Call(BrokerReport, request, response)
-			turns := response.Turns
-			cellsCount := response.CellsCount
-			// log.Printf("Turns: %d, Alive Cells: %d\n", turns, cellsCount)
-			reporter.EventsCh <- AliveCellsCount{
-				CompletedTurns: turns,
-				CellsCount:     cellsCount,
-			}
+		case <-initialDelay.C:
+			ticker := time.NewTicker(reporter.ReportInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		case <-tick:
 		case <-reporter.Stop:
 			// Stop signal received, exit the loop
 			return
 		}
+
+		events := reporter.report(client)
+		turns := int64(events[len(events)-1].GetCompletedTurns() - reporter.StartTurn)
+		for _, event := range append(events, progress(turns, reporter.TotalTurns, reporter.StartTurn)) {
+			// A stop that came during the report wins over a consumer ready for it, as both
+			// are otherwise picked from at random.
+			if reporter.stopping() {
+				return
+			}
+			select {
+			case reporter.EventsCh <- event:
+			case <-reporter.Done:
+				// The consumer is gone, nobody is listening for reports
+				return
+			case <-reporter.Stop:
+				return
+			}
+		}
+		reports++
+		reporter.saveEvery(client, reports)
+	}
+}
+
+// saveEvery saves the board if reports is a multiple of SaveEvery, unless the reporter has
+// been stopped, when the run may already have saved its final board.
+func (reporter *Reporter) saveEvery(client *brokerClient, reports int) {
+	if reporter.SaveEvery <= 0 || reports%reporter.SaveEvery != 0 || reporter.stopping() {
+		return
+	}
+	snapshotRequest := BrokerPauseAndSnapshotRequest{SessionID: reporter.SessionID}
+	snapshotResponse := new(BrokerPauseAndSnapshotResponse)
+	client.Call(BrokerPauseAndSnapshot, snapshotRequest, snapshotResponse)
+	if snapshotResponse.World.Height > 0 {
+		snapshotResponse.World.save(completedTurn(reporter.StartTurn, snapshotResponse.Turns), reporter.channels)
+	}
+}
+
+// stream emits every turn of a streaming run as the broker completes it, its CellFlipped
+// events then its TurnComplete, with an AliveCellsCount and Progress timed as start times
+// its reports. It returns once the broker has no more turns to give, or when stopped.
+func (reporter *Reporter) stream(client *brokerClient) {
+	defer close(reporter.streamed)
+	send := func(event Event) bool {
+		if reporter.stopping() {
+			return false
+		}
+		select {
+		case reporter.EventsCh <- event:
+			return true
+		case <-reporter.Done:
+			return false
+		case <-reporter.Stop:
+			return false
+		}
+	}
+	// due is when the next count is, the first InitialDelay in.
+	due := time.Now().Add(reporter.InitialDelay)
+	reports := 0
+
+	for {
+		select {
+		case <-reporter.Stop:
+			return
+		default:
+		}
+		request := BrokerNextTurnsRequest{SessionID: reporter.SessionID, Timeout: reporter.ReportInterval}
+		response := new(BrokerNextTurnsResponse)
+		if err := client.Call(BrokerNextTurns, request, response); err != nil {
+			log.Println("streaming turns:", err)
+			return
+		}
+		for _, turn := range response.Turns {
+			completed := completedTurn(reporter.StartTurn, turn.Turns)
+			for _, cell := range turn.Cells {
+				if !send(CellFlipped{completed, cell}) {
+					return
+				}
+			}
+			if !send(TurnComplete{completed}) {
+				return
+			}
+		}
+		if n := len(response.Turns); n > 0 && !time.Now().Before(due) {
+			last := response.Turns[n-1]
+			completed := completedTurn(reporter.StartTurn, last.Turns)
+			if !send(AliveCellsCount{completed, last.CellsCount}) || !send(progress(last.Turns, reporter.TotalTurns, reporter.StartTurn)) {
+				return
+			}
+			due = time.Now().Add(reporter.ReportInterval)
+			reports++
+			reporter.saveEvery(client, reports)
+		}
+		if response.Finished {
+			return
+		}
 	}
 }
 
+// generateFilename names a saved turn WIDTHxHEIGHTxTURN, matching the WIDTHxHEIGHT images are
+// loaded from, so a saved board can be loaded again.
 func generateFilename(world *World, turn int) string {
-	return fmt.Sprintf("%vx%vx%v", world.Width, world.Width, turn)
+	return fmt.Sprintf("%vx%vx%v", world.Width, world.Height, turn)
 }
 
 func saveWorldToFile(world *World, c distributorChannels) {
@@ -199,127 +654,519 @@ func saveWorldToFile(world *World, c distributorChannels) {
 }
 
 func (world *World) save(turn int, c distributorChannels) {
+	c.saving.Lock()
+	defer c.saving.Unlock()
 	filename := generateFilename(world, turn)
 	c.ioCommand <- ioOutput
 	c.ioFilename <- filename
 	saveWorldToFile(world, c)
-	c.events <- ImageOutputComplete{
+	c.emit(ImageOutputComplete{
 		CompletedTurns: turn,
 		Filename:       filename,
+	})
+}
+
+// savePNG writes the world at turn to the dir directory as a png image, named like the pgm
+// images.
+func (world *World) savePNG(turn int, dir string) error {
+	_ = os.Mkdir(dir, os.ModePerm)
+	file, err := os.Create(filepath.Join(dir, generateFilename(world, turn)+".png"))
+	if err != nil {
+		return err
 	}
+	if err := world.ToPNG(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
-func distributor(p Params, c distributorChannels) {
-	filename := fmt.Sprintf("%vx%v", p.ImageWidth, p.ImageHeight)
+// fetchBands fetches the final height x width board of a Banded run from the broker, rows
+// bands at a time, checking the bands fill the board exactly.
+func fetchBands(client *brokerClient, sessionID string, rows, height, width int) (World, error) {
+	world := World{Height: height, Width: width, Field: Field{Height: height, Width: width}}
+	for start := 0; start < height; start += rows {
+		end := start + rows
+		if end > height {
+			end = height
+		}
+		request := BrokerFetchBandRequest{SessionID: sessionID, StartRow: start, EndRow: end}
+		response := new(BrokerFetchBandResponse)
+		if err := client.Call(BrokerFetchBand, request, response); err != nil {
+			return World{}, err
+		}
+		if response.Height != height || response.Width != width {
+			return World{}, fmt.Errorf("the broker's board is %vx%v, not %vx%v", response.Width, response.Height, width, height)
+		}
+		if len(response.Rows) != end-start {
+			return World{}, fmt.Errorf("asked for rows [%v, %v), got %v rows", start, end, len(response.Rows))
+		}
+		for i, row := range response.Rows {
+			if len(row) != width {
+				return World{}, fmt.Errorf("row %v is %v cells wide, not %v", start+i, len(row), width)
+			}
+		}
+		world.Field.Data = append(world.Field.Data, response.Rows...)
+	}
+	return world, nil
+}
 
-	c.ioCommand <- ioInput
+// saveThumbnail fetches a thumbnail of the current turn no larger than maxDim on either side
+// from the broker and writes it to the out directory.
+func saveThumbnail(client *brokerClient, p Params, sessionID string, maxDim int, c distributorChannels) error {
+	request := BrokerGetThumbnailRequest{SessionID: sessionID, MaxDim: maxDim}
+	response := new(BrokerGetThumbnailResponse)
+	if err := client.Call(BrokerGetThumbnail, request, response); err != nil {
+		return err
+	}
 
-	c.ioFilename <- filename
+	turn := completedTurn(p.StartTurn, response.Turns)
+	filename := fmt.Sprintf("%vx%vx%v-thumbnail-%v", p.ImageWidth, p.ImageHeight, turn, maxDim)
+	_ = os.Mkdir("out", os.ModePerm)
+	file, err := os.Create("out/" + filename + ".pgm")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := util.WritePgm(file, response.Image); err != nil {
+		return err
+	}
 
-	field := Field{
-		Height: p.ImageHeight,
-		Width:  p.ImageWidth,
+	c.emit(ImageOutputComplete{
+		CompletedTurns: turn,
+		Filename:       filename,
+	})
+	return nil
+}
+
+// compareGolden compares the final world with the one in the golden file, emitting a
+// GoldenMismatch if they differ or a RunError if they cannot be compared.
+func (world *World) compareGolden(golden string, turn int, c distributorChannels) {
+	expected, err := LoadWorld(golden)
+	if err == nil {
+		var cells []util.Cell
+		if cells, err = world.Diff(expected); err == nil && len(cells) > 0 {
+			c.emit(GoldenMismatch{CompletedTurns: turn, Golden: golden, Cells: cells})
+		}
+	}
+	if err != nil {
+		c.emit(RunError{CompletedTurns: turn, Err: fmt.Sprintf("comparing with golden %v: %v", golden, err)})
+	}
+}
+
+// writeAliveFile writes the alive cells at turn to path as JSON.
+func writeAliveFile(path string, turn int, alive []util.Cell) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteAliveJSON(file, turn, alive); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// newRunSummary sums up a run that reached turns, from startTurn, in elapsed.
+func newRunSummary(turns, startTurn, alive int, elapsed time.Duration, response *BrokerProcessResponse) RunSummary {
+	summary := RunSummary{
+		CompletedTurns: turns,
+		AliveCells:     alive,
+		Elapsed:        elapsed,
+		Bytes:          response.Bytes,
+		WorkerRegions:  response.WorkerRegions,
+		Failovers:      response.Failovers,
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		summary.TurnsPerSecond = float64(turns-startTurn) / seconds
+	}
+	return summary
+}
+
+func writeSummaryFile(path string, summary RunSummary) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteSummaryJSON(file, summary); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// newToken returns a random token identifying this distributor to the broker.
+func newToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("generating token: %v", err)
+	}
+	return hex.EncodeToString(token), nil
+}
+
+func distributor(p Params, c distributorChannels) {
+	c.done = make(chan struct{})
+
+	// Checked before the board is loaded, so a mistyped address or turn count fails straight away.
+	brokerAddr, err := brokerAddress(p)
+	if err == nil {
+		err = checkTurns(p)
 	}
-	field.cultivate(p.ImageHeight, p.ImageWidth)
+	if err != nil {
+		c.abort(p.StartTurn, err)
+		return
+	}
+
+	var world World
+	if p.Pattern != "" {
+		// The pattern takes the place of the image, in the middle of a board of the same size.
+		pattern, err := LoadWorld(p.Pattern)
+		if err == nil {
+			world, err = pattern.centred(p.ImageHeight, p.ImageWidth)
+		}
+		if err != nil {
+			c.abort(p.StartTurn, fmt.Errorf("loading pattern %v: %v", p.Pattern, err))
+			return
+		}
+		if !p.NoInitialFlips {
+			world.flipAlive(c, p.MaxFlipsPerTurn, p.StartTurn)
+		}
+	} else {
+		filename := fmt.Sprintf("%vx%v", p.ImageWidth, p.ImageHeight)
+
+		c.ioCommand <- ioInput
+
+		c.ioFilename <- filename
 
-	world := World{
-		Field:  field,
-		Height: p.ImageHeight,
-		Width:  p.ImageWidth,
+		field := Field{
+			Height: p.ImageHeight,
+			Width:  p.ImageWidth,
+		}
+		field.cultivate(p.ImageHeight, p.ImageWidth)
+
+		world = World{
+			Field:  field,
+			Height: p.ImageHeight,
+			Width:  p.ImageWidth,
+		}
+		if err := world.populate(c, !p.NoInitialFlips, p.MaxFlipsPerTurn, p.StartTurn); err != nil {
+			c.abort(p.StartTurn, err)
+			return
+		}
 	}
-	world.populate(c)
 
 	reporter := Reporter{
 		EventsCh:       c.events,
-		ReportInterval: InitialDelay,
+		InitialDelay:   InitialDelay,
+		ReportInterval: ReportInterval,
+		Mode:           p.ReportMode,
 		Stop:           make(chan bool),
+		Done:           c.done,
+		SaveEvery:      p.SaveEveryReports,
+		channels:       c,
+		StartTurn:      p.StartTurn,
+		TotalTurns:     p.Turns,
 	}
 
-	client, err := rpc.Dial("tcp", "3.80.182.42:8030")
+	tlsConfig, err := util.ClientTLS(p.TLSCA)
+	if err != nil {
+		c.abort(p.StartTurn, err)
+		return
+	}
+	client, err := dialBroker(brokerAddr, tlsConfig)
 	if err != nil {
-		log.Fatal("dialing:", err)
+		c.abort(p.StartTurn, fmt.Errorf("dialing the broker: %v", err))
+		return
 	}
 	defer client.Close()
 
-	go reporter.start(client)
+	// Only this distributor may control the run, anyone else connecting can just observe it.
+	token, err := newToken()
+	if err != nil {
+		c.abort(p.StartTurn, err)
+		return
+	}
+
+	// The run gets a session of its own, so other clients can run alongside it. A broker
+	// without sessions has just the one run, which an empty SessionID names.
+	var sessionID string
+	startResponse := new(BrokerStartResponse)
+	if err := client.Call(BrokerStart, BrokerStartRequest{}, startResponse); err == nil {
+		sessionID = startResponse.SessionID
+	}
+	reporter.SessionID = sessionID
 
+	if p.ReportMode == ReportTurns {
+		reporter.streamed = make(chan struct{})
+		go reporter.stream(client)
+	} else {
+		reporter.stopped = make(chan struct{})
+		go reporter.start(client)
+	}
+
+	// A save signal is handled like 's', between the keypresses.
+	saveSignals := make(chan os.Signal, 1)
+	if p.SaveOnSignal {
+		notifySave(saveSignals)
+		defer signal.Stop(saveSignals)
+	}
+	// acknowledged reports how long the broker took to answer the control RPC for key.
+	acknowledged := func(key rune, turns int64, start time.Time) {
+		if p.ControlLatency {
+			c.emit(ControlLatency{
+				CompletedTurns: completedTurn(p.StartTurn, turns),
+				Key:            key,
+				RTT:            time.Since(start),
+			})
+		}
+	}
+	// saveSnapshot saves the board for key, or for a signal when key is 0, as a png image for
+	// 'i' and a pgm image otherwise.
+	saveSnapshot := func(key rune) {
+		// Snapshot at a turn boundary, so the image is exactly the turn it is named after.
+		snapshotRequest := BrokerPauseAndSnapshotRequest{SessionID: sessionID}
+		snapshotResponse := new(BrokerPauseAndSnapshotResponse)
+		start := time.Now()
+		client.Call(BrokerPauseAndSnapshot, snapshotRequest, snapshotResponse)
+		if key != 0 {
+			acknowledged(key, snapshotResponse.Turns, start)
+		}
+		world, turn := snapshotResponse.World, completedTurn(p.StartTurn, snapshotResponse.Turns)
+		if world.Height == 0 {
+			return
+		}
+		if key != 'i' {
+			world.save(turn, c)
+			return
+		}
+		if err := world.savePNG(turn, "out"); err != nil {
+			log.Println("saving png:", err)
+			return
+		}
+		c.emit(ImageOutputComplete{
+			CompletedTurns: turn,
+			Filename:       generateFilename(&world, turn) + ".png",
+		})
+	}
+
+	// keys is held while a key is handled, and ended is set under it once events is closed,
+	// so a key pressed as the run ends is never handled by emitting on the closed channel.
+	var keys sync.Mutex
+	ended := false
 	go func() {
+		// quitting is set once 'q' or 'k' has stopped the run. Keys pressed after are still
+		// read, so the consumer never blocks sending them, but do nothing.
+		quitting := false
 		for {
 			select {
-			case key := <-c.keyPresses:
-				if key == 's' {
-					saveRequest := BrokerSaveRequest{}
-					saveResponse := new(BrokerSaveResponse)
-					client.Call(BrokerReport, saveRequest, saveResponse)
-					saveResponse.World.save(saveResponse.Turns, c)
+			case <-saveSignals:
+				keys.Lock()
+				if !ended {
+					saveSnapshot(0)
+				}
+				keys.Unlock()
+			case key, ok := <-c.keyPresses:
+				keys.Lock()
+				if !ok {
+					// The consumer has exited, so stop the run on the broker and let the
+					// distributor wind down without emitting any more events.
+					close(c.done)
+					keys.Unlock()
+					quitRequest := BrokerQuitRequest{SessionID: sessionID, Token: token}
+					quitResponse := new(BrokerQuitResponse)
+					client.Call(BrokerQuit, quitRequest, quitResponse)
+					return
+				} else if quitting || ended {
+					// Nothing left to control.
+				} else if key == 's' || key == 'i' {
+					saveSnapshot(key)
+				} else if key >= '1' && key < '1'+rune(len(ThumbnailLevels)) {
+					if err := saveThumbnail(client, p, sessionID, ThumbnailLevels[key-'1'], c); err != nil {
+						log.Println("saving thumbnail:", err)
+					}
 				} else if key == 'q' {
-					quitRequest := BrokerQuitRequest{}
+					quitRequest := BrokerQuitRequest{SessionID: sessionID, Token: token}
 					quitResponse := new(BrokerQuitResponse)
+					start := time.Now()
 					client.Call(BrokerQuit, quitRequest, quitResponse)
-					c.events <- StateChange{
-						CompletedTurns: quitResponse.Turns,
+					acknowledged(key, quitResponse.Turns, start)
+					c.emit(StateChange{
+						CompletedTurns: completedTurn(p.StartTurn, quitResponse.Turns),
 						NewState:       Quitting,
-					}
-
-					return
+					})
+					quitting = true
 				} else if key == 'k' {
-					shutdownRequest := BrokerShutdownRequest{}
+					shutdownRequest := BrokerShutdownRequest{SessionID: sessionID, Token: token}
 					shutdownResponse := new(BrokerShutdownResponse)
-					client.Call(BrokerShutdown, shutdownRequest, shutdownResponse)
-					c.events <- StateChange{
-						CompletedTurns: shutdownResponse.Turns,
+					start := time.Now()
+					err := client.Call(BrokerShutdown, shutdownRequest, shutdownResponse)
+					acknowledged(key, shutdownResponse.Turns, start)
+					if err != nil {
+						c.emit(Warning{
+							CompletedTurns: completedTurn(p.StartTurn, shutdownResponse.Turns),
+							Message:        fmt.Sprintf("shutting down: %v", err),
+						})
+					}
+					c.emit(StateChange{
+						CompletedTurns: completedTurn(p.StartTurn, shutdownResponse.Turns),
 						NewState:       Quitting,
+					})
+					quitting = true
+				} else if key == 'n' {
+					// Only a paused run steps, so the window is brought up to the turn it
+					// stepped to, which it is then held at.
+					stepRequest := BrokerStepRequest{SessionID: sessionID, Token: token}
+					stepResponse := new(BrokerStepResponse)
+					start := time.Now()
+					err := client.Call(BrokerStep, stepRequest, stepResponse)
+					acknowledged(key, stepResponse.Turns, start)
+					if err != nil {
+						log.Println("stepping:", err)
+					} else if stepResponse.Stepped && p.ReportMode != ReportTurns {
+						// A streamed step comes with the rest of the turns instead.
+						turn := completedTurn(p.StartTurn, stepResponse.Turns)
+						for _, cell := range stepResponse.Cells {
+							c.emit(CellFlipped{turn, cell})
+						}
+						c.emit(TurnComplete{turn})
 					}
-					return
 				} else if key == 'p' {
-					pauseRequest := BrokerPauseRequest{}
+					pauseRequest := BrokerPauseRequest{SessionID: sessionID, Token: token}
 					pauseResponse := new(BrokerPauseResponse)
+					start := time.Now()
 					client.Call(BrokerPause, pauseRequest, pauseResponse)
+					acknowledged(key, pauseResponse.Turns, start)
 					if pauseResponse.IsPaused {
-						c.events <- StateChange{
-							CompletedTurns: pauseResponse.Turns,
+						c.emit(StateChange{
+							CompletedTurns: completedTurn(p.StartTurn, pauseResponse.Turns),
 							NewState:       Paused,
-						}
+						})
 					} else {
-						c.events <- StateChange{
-							CompletedTurns: pauseResponse.Turns,
+						c.emit(StateChange{
+							CompletedTurns: completedTurn(p.StartTurn, pauseResponse.Turns),
 							NewState:       Executing,
-						}
+						})
 					}
 				}
+				keys.Unlock()
 			}
 		}
 	}()
 
+	if p.Interactive && p.Turns == 0 {
+		// Valid headless, but in the window it just saves the seed as if it were a result.
+		c.emit(Warning{
+			CompletedTurns: p.StartTurn,
+			Message:        "no turns to process, the initial world will be saved unchanged",
+		})
+	}
+
+	c.emit(StateChange{
+		CompletedTurns: p.StartTurn,
+		NewState:       Executing,
+	})
+
 	processRequest := BrokerProcessRequest{
-		World: world,
-		Turns: p.Turns,
+		SessionID: sessionID,
+		Packed:    Pack(world),
+		Turns:     int64(p.Turns),
+		Token:     token,
+		Boundary:  p.Boundary,
+		Rule:      p.Rule,
+		Stream:    p.ReportMode == ReportTurns,
+		Banded:    p.BandRows > 0,
 	}
 
 	processResponse := new(BrokerProcessResponse)
 
-	client.Call(BrokerProcess, processRequest, processResponse)
+	started := time.Now()
+	err = client.Call(BrokerProcess, processRequest, processResponse)
+	elapsed := time.Since(started)
+	// The broker may have stopped early, so report the turn it actually reached.
+	completed := processResponse.Turns
+	if err == nil && p.BandRows > 0 {
+		if processResponse.World, err = fetchBands(client, sessionID, p.BandRows, world.Height, world.Width); err != nil {
+			err = fmt.Errorf("fetching the final board: %v", err)
+		}
+	}
+	if err != nil {
+		// The broker aborted the run, salvage the last turn it completed.
+		saveResponse := new(BrokerSaveResponse)
+		client.Call(BrokerSave, BrokerSaveRequest{SessionID: sessionID}, saveResponse)
+		if saveResponse.World.Height == world.Height && saveResponse.World.Width == world.Width {
+			processResponse.World = saveResponse.World
+			completed = saveResponse.Turns
+		} else {
+			// Nothing was completed, so all there is to save is the initial world.
+			processResponse.World = world
+			completed = 0
+		}
+		c.emit(RunError{CompletedTurns: completedTurn(p.StartTurn, completed), Err: err.Error()})
+	} else if processResponse.Packed.Height > 0 {
+		processResponse.World = Unpack(processResponse.Packed)
+	}
 
 	world = processResponse.World
 
-	reporter.Stop <- true
+	if reporter.streamed != nil && err == nil {
+		// Every turn is emitted before the final one, which the broker has finished ahead of.
+		select {
+		case <-reporter.streamed:
+		case <-c.done:
+		}
+	}
+	reporter.stop()
+	if sessionID != "" {
+		// The run is over, so end its session rather than leave it on the broker.
+		client.Call(BrokerQuit, BrokerQuitRequest{SessionID: sessionID, Token: token}, new(BrokerQuitResponse))
+	}
+
+	turns := completedTurn(p.StartTurn, completed)
+	if completed < int64(p.Turns) {
+		// Stopped short of the total, which no longer says how far the run got.
+		stopped := progress(completed, p.Turns, p.StartTurn)
+		stopped.Percent = -1
+		c.emit(stopped)
+	}
+
+	if p.Golden != "" {
+		world.compareGolden(p.Golden, turns, c)
+	}
+
+	alive := world.alive()
+	summary := newRunSummary(turns, p.StartTurn, len(alive), elapsed, processResponse)
+	c.emit(summary)
+	if p.SummaryOut != "" {
+		if err := writeSummaryFile(p.SummaryOut, summary); err != nil {
+			c.emit(Warning{CompletedTurns: turns, Message: fmt.Sprintf("writing the run summary to %v: %v", p.SummaryOut, err)})
+		}
+	}
+
+	c.emit(FinalTurnComplete{
+		CompletedTurns: turns,
+		Alive:          alive,
+	})
 
-	c.events <- FinalTurnComplete{
-		CompletedTurns: p.Turns,
-		Alive:          world.alive(),
+	if p.AliveOut != "" {
+		if err := writeAliveFile(p.AliveOut, turns, alive); err != nil {
+			c.emit(Warning{CompletedTurns: turns, Message: fmt.Sprintf("writing alive cells to %v: %v", p.AliveOut, err)})
+		}
 	}
 
-	world.save(p.Turns, c)
+	world.save(turns, c)
 
 	// Make sure that the Io has finished any output before exiting.
 	c.ioCommand <- ioCheckIdle
 	<-c.ioIdle
 
-	c.events <- StateChange{
-		CompletedTurns: p.Turns,
+	c.emit(StateChange{
+		CompletedTurns: turns,
 		NewState:       Quitting,
-	}
+	})
 
 	// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
+	keys.Lock()
+	ended = true
 	close(c.events)
+	keys.Unlock()
 }
@@ -0,0 +1,1626 @@
+package gol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// seed returns a height x width image where every cell for which alive returns true is set.
+func seed(height, width int, alive func(x, y int) bool) []uint8 {
+	image := make([]uint8, 0, height*width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if alive(x, y) {
+				image = append(image, 255)
+			} else {
+				image = append(image, 0)
+			}
+		}
+	}
+	return image
+}
+
+// input streams image into a channel, as the io goroutine does when reading a pgm file.
+func input(image []uint8) <-chan uint8 {
+	ioInput := make(chan uint8)
+	go func() {
+		for _, b := range image {
+			ioInput <- b
+		}
+	}()
+	return ioInput
+}
+
+// startTestIo stands in for the io goroutine, serving image on input and discarding output.
+func startTestIo(p Params, image []uint8) distributorChannels {
+	return startCapturingIo(p, image, nil)
+}
+
+// startCapturingIo is startTestIo sending every image output on saved, unless it is nil.
+func startCapturingIo(p Params, image []uint8, saved chan<- []uint8) distributorChannels {
+	commands := make(chan ioCommand)
+	idle := make(chan bool)
+	filenames := make(chan string)
+	output := make(chan uint8)
+	input := make(chan uint8)
+
+	go func() {
+		for command := range commands {
+			switch command {
+			case ioInput:
+				<-filenames
+				for _, b := range image {
+					input <- b
+				}
+			case ioOutput:
+				<-filenames
+				written := make([]uint8, p.ImageHeight*p.ImageWidth)
+				for i := range written {
+					written[i] = <-output
+				}
+				if saved != nil {
+					saved <- written
+				}
+			case ioCheckIdle:
+				idle <- true
+			}
+		}
+	}()
+
+	return distributorChannels{
+		ioCommand:  commands,
+		ioIdle:     idle,
+		ioFilename: filenames,
+		ioOutput:   output,
+		ioInput:    input,
+		saving:     new(sync.Mutex),
+	}
+}
+
+// fakeBroker is a BrokerService stand-in whose Process blocks until Quit is first called.
+type fakeBroker struct {
+	quit     chan bool
+	quitOnce sync.Once
+}
+
+func (b *fakeBroker) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	<-b.quit
+	res.World = Unpack(req.Packed)
+	return
+}
+
+func (b *fakeBroker) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	return
+}
+
+func (b *fakeBroker) Quit(req BrokerQuitRequest, res *BrokerQuitResponse) (err error) {
+	b.quitOnce.Do(func() { close(b.quit) })
+	return
+}
+
+// abortingBroker is a BrokerService stand-in that aborts every run after a number of turns.
+type abortingBroker struct {
+	turns   int64
+	partial World
+}
+
+func (b *abortingBroker) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	return errors.New("worker retries exhausted")
+}
+
+func (b *abortingBroker) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	return
+}
+
+func (b *abortingBroker) Save(req BrokerSaveRequest, res *BrokerSaveResponse) (err error) {
+	res.Turns = b.turns
+	res.World = b.partial
+	return
+}
+
+// extinctBroker is a BrokerService stand-in whose runs die out, stopping at turn stopAt.
+type extinctBroker struct {
+	stopAt int64
+}
+
+func (b *extinctBroker) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	res.World = newWorld(req.Packed.Height, req.Packed.Width)
+	res.Turns = b.stopAt
+	return
+}
+
+func (b *extinctBroker) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	return
+}
+
+// reportingBroker is a BrokerService stand-in answering the reporter's polls.
+type reportingBroker struct {
+	world World
+}
+
+func (b *reportingBroker) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	res.World = Unpack(req.Packed)
+	res.Turns = req.Turns
+	return
+}
+
+func (b *reportingBroker) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	res.Turns = 5
+	res.CellsCount = len(b.world.alive())
+	return
+}
+
+func (b *reportingBroker) Flips(req BrokerFlipsRequest, res *BrokerFlipsResponse) (err error) {
+	res.Turns = 5
+	res.CellsCount = len(b.world.alive())
+	res.Cells = b.world.alive()
+	return
+}
+
+func (b *reportingBroker) GetWorld(req BrokerGetWorldRequest, res *BrokerGetWorldResponse) (err error) {
+	res.Turns = 5
+	res.World = b.world
+	return
+}
+
+func (b *reportingBroker) PauseAndSnapshot(req BrokerPauseAndSnapshotRequest, res *BrokerPauseAndSnapshotResponse) (err error) {
+	res.Turns = 5
+	res.World = b.world
+	return
+}
+
+// startFakeBroker serves broker under the BrokerService name and returns its address.
+func startFakeBroker(t *testing.T, broker interface{}) string {
+	server := rpc.NewServer()
+	if err := server.RegisterName("BrokerService", broker); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(listener)
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+func checkerboard(x, y int) bool { return (x+y)%2 == 0 }
+
+func TestPopulateFlips(t *testing.T) {
+	const height, width = 16, 16
+	image := seed(height, width, checkerboard)
+
+	for _, emitFlips := range []bool{true, false} {
+		t.Run(fmt.Sprintf("flips=%v", emitFlips), func(t *testing.T) {
+			events := make(chan Event, height*width)
+			world := newWorld(height, width)
+			world.populate(distributorChannels{events: events, ioInput: input(image)}, emitFlips, 0, 0)
+			close(events)
+
+			flips := 0
+			for event := range events {
+				if _, ok := event.(CellFlipped); ok {
+					flips++
+				}
+			}
+
+			expected := 0
+			if emitFlips {
+				expected = len(world.alive())
+			}
+			if flips != expected {
+				t.Errorf("expected %d CellFlipped events, got %d", expected, flips)
+			}
+		})
+	}
+}
+
+// TestMaxFlips checks a load flipping more cells than the limit sends one TurnRefresh with
+// every alive cell instead, and one within it still sends each flip.
+func TestMaxFlips(t *testing.T) {
+	const height, width = 16, 16
+	image := seed(height, width, checkerboard)
+	alive := height * width / 2
+
+	for _, maxFlips := range []int{alive - 1, alive} {
+		t.Run(fmt.Sprintf("max=%v", maxFlips), func(t *testing.T) {
+			events := make(chan Event, height*width)
+			world := newWorld(height, width)
+			world.populate(distributorChannels{events: events, ioInput: input(image)}, true, maxFlips, 3)
+			close(events)
+
+			flips, refreshes := 0, 0
+			for event := range events {
+				switch e := event.(type) {
+				case CellFlipped:
+					flips++
+				case TurnRefresh:
+					refreshes++
+					if e.CompletedTurns != 3 || len(e.Alive) != alive {
+						t.Errorf("expected a refresh of %v alive cells at turn 3, got %v at turn %v", alive, len(e.Alive), e.CompletedTurns)
+					}
+				}
+			}
+
+			if maxFlips < alive && (flips != 0 || refreshes != 1) {
+				t.Errorf("expected just a refresh above the limit, got %v flips and %v refreshes", flips, refreshes)
+			}
+			if maxFlips >= alive && (flips != alive || refreshes != 0) {
+				t.Errorf("expected %v flips within the limit, got %v flips and %v refreshes", alive, flips, refreshes)
+			}
+		})
+	}
+}
+
+// TestGenerateFilename checks a non-square board is named width first, then height.
+func TestGenerateFilename(t *testing.T) {
+	world := World{Width: 512, Height: 256}
+	if filename := generateFilename(&world, 0); filename != "512x256x0" {
+		t.Errorf("expected 512x256x0, got %v", filename)
+	}
+}
+
+// TestNonSquareImages runs tall and wide images through a broker handing the board straight
+// back, and checks the final cells and the saved image come back where they were loaded, with
+// the image named width first.
+func TestNonSquareImages(t *testing.T) {
+	for _, shape := range [][2]int{{64, 128}, {128, 64}, {512, 256}} {
+		width, height := shape[0], shape[1]
+		image := seed(height, width, func(x, y int) bool { return (x+2*y)%5 == 0 || x == width-1 })
+		p := Params{Turns: 2, ImageWidth: width, ImageHeight: height, NoInitialFlips: true}
+		p.BrokerAddr = startFakeBroker(t, &reportingBroker{world: newWorld(height, width)})
+
+		events := make(chan Event, 1000)
+		saved := make(chan []uint8, 1)
+		c := startCapturingIo(p, image, saved)
+		c.events = events
+		go distributor(p, c)
+
+		var final []util.Cell
+		filename := ""
+		for event := range events {
+			switch e := event.(type) {
+			case FinalTurnComplete:
+				final = e.Alive
+			case ImageOutputComplete:
+				filename = e.Filename
+			}
+		}
+
+		var expected []util.Cell
+		for i, value := range image {
+			if value == 255 {
+				expected = append(expected, util.Cell{X: i % width, Y: i / width})
+			}
+		}
+		if !reflect.DeepEqual(final, expected) {
+			t.Errorf("%dx%d: expected the %d loaded cells back, got %d", width, height, len(expected), len(final))
+		}
+		if name := fmt.Sprintf("%dx%dx2", width, height); filename != name {
+			t.Errorf("%dx%d: expected the board saved as %v, got %v", width, height, name, filename)
+		}
+		if written := <-saved; !bytes.Equal(written, image) {
+			t.Errorf("%dx%d: expected the saved image to match the loaded one", width, height)
+		}
+	}
+}
+
+// BenchmarkPopulate measures loading a dense seed with and without the initial CellFlipped events.
+func BenchmarkPopulate(b *testing.B) {
+	const height, width = 512, 512
+	image := seed(height, width, func(x, y int) bool { return true })
+
+	for _, emitFlips := range []bool{true, false} {
+		b.Run(fmt.Sprintf("flips=%v", emitFlips), func(b *testing.B) {
+			events := make(chan Event, 1000)
+			done := make(chan bool)
+			go func() {
+				for range events {
+				}
+				done <- true
+			}()
+
+			for i := 0; i < b.N; i++ {
+				world := newWorld(height, width)
+				world.populate(distributorChannels{events: events, ioInput: input(image)}, emitFlips, 0, 0)
+			}
+
+			close(events)
+			<-done
+		})
+	}
+}
+
+// TestConsumerExit closes keyPresses mid-run and stops reading events, as the SDL loop does
+// when its window is closed, and checks that the distributor quits the broker and returns.
+func TestConsumerExit(t *testing.T) {
+	broker := &fakeBroker{quit: make(chan bool)}
+	p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16, BrokerAddr: startFakeBroker(t, broker)}
+	image := seed(p.ImageHeight, p.ImageWidth, checkerboard)
+
+	events := make(chan Event)
+	keyPresses := make(chan rune)
+	c := startTestIo(p, image)
+	c.events = events
+	c.keyPresses = keyPresses
+
+	finished := make(chan bool)
+	go func() {
+		distributor(p, c)
+		finished <- true
+	}()
+
+	for flips := 0; flips < len(image)/2; flips++ {
+		<-events
+	}
+	close(keyPresses)
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("distributor did not shut down after the consumer exited")
+	}
+
+	select {
+	case <-broker.quit:
+	default:
+		t.Error("broker was not asked to quit")
+	}
+}
+
+// runDistributor runs the distributor against a test io goroutine and collects every event.
+func runDistributor(p Params, image []uint8, keyPresses <-chan rune) []Event {
+	events := make(chan Event, 1000)
+	c := startTestIo(p, image)
+	c.events = events
+	c.keyPresses = keyPresses
+	go distributor(p, c)
+
+	var collected []Event
+	for event := range events {
+		collected = append(collected, event)
+	}
+	return collected
+}
+
+// TestRunAborted checks that a run aborted by the broker is surfaced as a RunError and that
+// the last completed turn is saved.
+func TestRunAborted(t *testing.T) {
+	partial := newWorld(16, 16)
+	partial.Field.Data[3][4].Alive = true
+	broker := &abortingBroker{turns: 7, partial: partial}
+	p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16, BrokerAddr: startFakeBroker(t, broker)}
+
+	var runError, final, output bool
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		switch e := event.(type) {
+		case RunError:
+			runError = e.CompletedTurns == 7
+		case FinalTurnComplete:
+			final = e.CompletedTurns == 7 && len(e.Alive) == 1
+		case ImageOutputComplete:
+			output = e.CompletedTurns == 7 && e.Filename == "16x16x7"
+		}
+	}
+
+	if !runError {
+		t.Error("expected a RunError event at turn 7")
+	}
+	if !final {
+		t.Error("expected a FinalTurnComplete with the partial world at turn 7")
+	}
+	if !output {
+		t.Error("expected the partial world to be saved as 16x16x7")
+	}
+}
+
+// TestStoppedEarly checks the final events carry the turn the broker stopped at rather than
+// the number of turns asked for.
+func TestStoppedEarly(t *testing.T) {
+	p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16}
+	p.BrokerAddr = startFakeBroker(t, &extinctBroker{stopAt: 37})
+
+	final := false
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		switch e := event.(type) {
+		case FinalTurnComplete:
+			final = true
+			if e.CompletedTurns != 37 || len(e.Alive) != 0 {
+				t.Errorf("expected an empty board at turn 37, got %d alive at turn %d", len(e.Alive), e.CompletedTurns)
+			}
+		case ImageOutputComplete:
+			if e.Filename != "16x16x37" {
+				t.Errorf("expected the board to be saved as 16x16x37, got %v", e.Filename)
+			}
+		}
+	}
+	if !final {
+		t.Error("expected a FinalTurnComplete event")
+	}
+}
+
+// TestStartTurn resumes a run at turn 100 and checks the turn numbering carries on from there.
+func TestStartTurn(t *testing.T) {
+	p := Params{Turns: 10, ImageWidth: 16, ImageHeight: 16, StartTurn: 100}
+	p.BrokerAddr = startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)})
+
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		switch e := event.(type) {
+		case CellFlipped, StateChange:
+			if turns := e.GetCompletedTurns(); turns != 100 && turns != 110 {
+				t.Errorf("expected %v at turn 100 or 110, got %d", e, turns)
+			}
+		case FinalTurnComplete:
+			if e.CompletedTurns != 110 {
+				t.Errorf("expected the run to finish at turn 110, got %d", e.CompletedTurns)
+			}
+		case ImageOutputComplete:
+			if e.Filename != "16x16x110" {
+				t.Errorf("expected the board to be saved as 16x16x110, got %v", e.Filename)
+			}
+		}
+	}
+
+	client, err := dialBroker(p.BrokerAddr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	reporter := Reporter{StartTurn: 100}
+	events := reporter.report(client)
+	if turns := events[len(events)-1].GetCompletedTurns(); turns != 105 {
+		t.Errorf("expected the broker's turn 5 to be reported as turn 105, got %d", turns)
+	}
+}
+
+// TestExecutingEvent checks that a run starts with an Executing state change at turn 0, after
+// the initial cells are flipped and before the run's other state changes.
+func TestExecutingEvent(t *testing.T) {
+	p := Params{Turns: 10, ImageWidth: 16, ImageHeight: 16}
+	p.BrokerAddr = startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)})
+
+	var states []StateChange
+	flipped := false
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		switch e := event.(type) {
+		case CellFlipped:
+			flipped = true
+			if len(states) > 0 {
+				t.Fatal("CellFlipped emitted after the run started executing")
+			}
+		case StateChange:
+			states = append(states, e)
+		}
+	}
+
+	if !flipped {
+		t.Error("expected the initial cells to be flipped")
+	}
+	if len(states) == 0 || states[0].NewState != Executing || states[0].CompletedTurns != 0 {
+		t.Fatalf("expected the first state change to be Executing at turn 0, got %v", states)
+	}
+	if last := states[len(states)-1]; last.NewState != Quitting || last.CompletedTurns != 10 {
+		t.Errorf("expected the last state change to be Quitting at turn 10, got %v", last)
+	}
+}
+
+// TestZeroTurnsWarning checks that a run of zero turns is warned about only when interactive.
+func TestZeroTurnsWarning(t *testing.T) {
+	for _, p := range []Params{
+		{Turns: 0, Interactive: true},
+		{Turns: 0, Interactive: false},
+		{Turns: 1, Interactive: true},
+	} {
+		p.ImageWidth, p.ImageHeight = 16, 16
+		p.BrokerAddr = startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)})
+
+		warnings := 0
+		for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+			if _, ok := event.(Warning); ok {
+				warnings++
+			}
+		}
+
+		expected := 0
+		if p.Interactive && p.Turns == 0 {
+			expected = 1
+		}
+		if warnings != expected {
+			t.Errorf("%d turns, interactive=%v: expected %d warnings, got %d", p.Turns, p.Interactive, expected, warnings)
+		}
+	}
+}
+
+func TestReportMode(t *testing.T) {
+	world := newWorld(16, 16)
+	world.Field.Data[1][2].Alive = true
+	world.Field.Data[3][4].Alive = true
+	client, err := dialBroker(startFakeBroker(t, &reportingBroker{world: world}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, mode := range []ReportMode{"", ReportCount, ReportSnapshot, ReportFlips} {
+		events := make(chan Event)
+		reporter := Reporter{
+			EventsCh:       events,
+			ReportInterval: 10 * time.Millisecond,
+			Mode:           mode,
+			Stop:           make(chan bool),
+			stopped:        make(chan struct{}),
+		}
+		go reporter.start(client)
+
+		event := <-events
+		var flipped []util.Cell
+		for mode == ReportFlips {
+			flip, ok := event.(CellFlipped)
+			if !ok {
+				break
+			}
+			if flip.CompletedTurns != 5 {
+				t.Errorf("mode %q: expected flips stamped with turn 5, got %v", mode, flip.CompletedTurns)
+			}
+			flipped = append(flipped, flip.Cell)
+			event = <-events
+		}
+		if mode == ReportFlips {
+			if len(flipped) != 2 {
+				t.Errorf("mode %q: expected 2 flips, got %v", mode, flipped)
+			}
+			if e, ok := event.(TurnComplete); !ok || e.CompletedTurns != 5 {
+				t.Errorf("mode %q: expected the flips to end with TurnComplete at turn 5, got %v", mode, event)
+			}
+			event = <-events
+		}
+		reporter.stop()
+
+		switch e := event.(type) {
+		case AliveCellsCount:
+			if mode == ReportSnapshot {
+				t.Errorf("mode %q: expected a WorldSnapshot, got %T", mode, event)
+			} else if e.CellsCount != 2 || e.CompletedTurns != 5 {
+				t.Errorf("mode %q: unexpected count %v at turn %v", mode, e.CellsCount, e.CompletedTurns)
+			}
+		case WorldSnapshot:
+			if mode != ReportSnapshot {
+				t.Errorf("mode %q: expected an AliveCellsCount, got %T", mode, event)
+			} else if len(e.Alive) != 2 || e.CompletedTurns != 5 {
+				t.Errorf("mode %q: unexpected snapshot %v at turn %v", mode, e.Alive, e.CompletedTurns)
+			}
+		default:
+			t.Errorf("mode %q: unexpected %T", mode, event)
+		}
+	}
+}
+
+// streamingBroker is a BrokerService stand-in whose run of 3 turns finishes at once, handing
+// its turns to NextTurns a call at a time, each turn flipping the cell at (turn, turn).
+type streamingBroker struct {
+	mu     sync.Mutex
+	stream bool
+	turns  int64
+}
+
+func (b *streamingBroker) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	b.mu.Lock()
+	b.stream = req.Stream
+	b.mu.Unlock()
+	res.World = Unpack(req.Packed)
+	res.Turns = 3
+	return
+}
+
+func (b *streamingBroker) NextTurns(req BrokerNextTurnsRequest, res *BrokerNextTurnsResponse) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.turns++
+	res.Turns = []TurnFlips{{Turns: b.turns, Cells: []util.Cell{{X: int(b.turns), Y: int(b.turns)}}}}
+	res.Finished = b.turns == 3
+	return
+}
+
+func (b *streamingBroker) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	return
+}
+
+func (b *streamingBroker) Quit(req BrokerQuitRequest, res *BrokerQuitResponse) (err error) {
+	return
+}
+
+// TestReportTurns streams a run whose Process returns before its turns have been taken, and
+// checks every turn is emitted in order, its flips before its TurnComplete, and all of them
+// before the final turn.
+func TestReportTurns(t *testing.T) {
+	broker := &streamingBroker{}
+	p := Params{Turns: 3, ImageWidth: 16, ImageHeight: 16, BrokerAddr: startFakeBroker(t, broker), ReportMode: ReportTurns, NoInitialFlips: true}
+
+	var flipped []int
+	completed := 0
+	final := false
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		switch e := event.(type) {
+		case CellFlipped:
+			if e.CompletedTurns != completed+1 || e.Cell.X != completed+1 {
+				t.Errorf("expected the flip of turn %v, got %v at turn %v", completed+1, e.Cell, e.CompletedTurns)
+			}
+			flipped = append(flipped, e.CompletedTurns)
+		case TurnComplete:
+			if e.CompletedTurns != completed+1 {
+				t.Errorf("expected TurnComplete for turn %v, got %v", completed+1, e.CompletedTurns)
+			}
+			completed = e.CompletedTurns
+		case FinalTurnComplete:
+			if completed != 3 {
+				t.Errorf("expected every turn completed before the final one, got %v", completed)
+			}
+			final = true
+		}
+	}
+	if !final || len(flipped) != 3 {
+		t.Errorf("expected 3 flips and the final turn, got %v and %v", flipped, final)
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if !broker.stream {
+		t.Error("expected the run to be asked to stream")
+	}
+}
+
+// TestReportTiming checks the first report is sent InitialDelay after the reporter starts,
+// rather than a ReportInterval after that, and the next one ReportInterval after it.
+func TestReportTiming(t *testing.T) {
+	const initialDelay, interval = 50 * time.Millisecond, 300 * time.Millisecond
+	client, err := dialBroker(startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	events := make(chan Event)
+	reporter := Reporter{
+		EventsCh:       events,
+		InitialDelay:   initialDelay,
+		ReportInterval: interval,
+		Stop:           make(chan bool),
+		stopped:        make(chan struct{}),
+	}
+	start := time.Now()
+	go reporter.start(client)
+
+	var reported []time.Duration
+	for len(reported) < 2 {
+		if _, ok := (<-events).(AliveCellsCount); ok {
+			reported = append(reported, time.Since(start))
+		}
+	}
+	reporter.stop()
+
+	if reported[0] < initialDelay || reported[0] > initialDelay+interval/2 {
+		t.Errorf("expected the first report after %v, got it after %v", initialDelay, reported[0])
+	}
+	if gap := reported[1] - reported[0]; gap < interval*9/10 {
+		t.Errorf("expected the second report %v after the first, got it %v after", interval, gap)
+	}
+}
+
+// TestSingleInitialReport runs a reporter whose initial delay and interval are equal, as they
+// are by default, and checks exactly one report is sent as the initial delay passes rather
+// than one for it and another for the ticker straight after.
+func TestSingleInitialReport(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	client, err := dialBroker(startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	events := make(chan Event, 100)
+	reporter := Reporter{
+		EventsCh:       events,
+		InitialDelay:   delay,
+		ReportInterval: delay,
+		Stop:           make(chan bool),
+		stopped:        make(chan struct{}),
+	}
+	go reporter.start(client)
+	time.Sleep(delay * 3 / 2)
+	reporter.stop()
+
+	reports := 0
+	for len(events) > 0 {
+		if _, ok := (<-events).(AliveCellsCount); ok {
+			reports++
+		}
+	}
+	if reports != 1 {
+		t.Errorf("expected one report by %v, got %v", delay*3/2, reports)
+	}
+}
+
+// bandedBroker is a BrokerService stand-in whose run leaves the board it was sent as it is,
+// to be fetched a band at a time. short drops the last row of every band.
+type bandedBroker struct {
+	short bool
+
+	mu     sync.Mutex
+	banded bool
+	world  World
+	bands  [][2]int
+}
+
+func (b *bandedBroker) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.banded = req.Banded
+	b.world = Unpack(req.Packed)
+	res.Turns = req.Turns
+	return
+}
+
+func (b *bandedBroker) FetchBand(req BrokerFetchBandRequest, res *BrokerFetchBandResponse) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bands = append(b.bands, [2]int{req.StartRow, req.EndRow})
+	res.Height, res.Width = b.world.Height, b.world.Width
+	res.Rows = b.world.Field.Data[req.StartRow:req.EndRow]
+	if b.short {
+		res.Rows = res.Rows[:len(res.Rows)-1]
+	}
+	return
+}
+
+func (b *bandedBroker) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	return
+}
+
+// TestBandRows fetches a final board 16 rows high in bands of 6, and checks the bands cover
+// it, the last one short, and a band missing rows is reported rather than saved.
+func TestBandRows(t *testing.T) {
+	for _, short := range []bool{false, true} {
+		broker := &bandedBroker{short: short}
+		p := Params{Turns: 3, ImageWidth: 16, ImageHeight: 16, BrokerAddr: startFakeBroker(t, broker), BandRows: 6, NoInitialFlips: true}
+
+		var final *FinalTurnComplete
+		var runErr *RunError
+		for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+			switch e := event.(type) {
+			case FinalTurnComplete:
+				final = &e
+			case RunError:
+				runErr = &e
+			}
+		}
+
+		broker.mu.Lock()
+		if !broker.banded {
+			t.Errorf("short=%v: expected the run to be asked for a banded board", short)
+		}
+		bands := broker.bands
+		broker.mu.Unlock()
+		if short {
+			if runErr == nil || !strings.Contains(runErr.Err, "fetching the final board") {
+				t.Errorf("expected a band missing rows to be reported, got %v", runErr)
+			}
+			continue
+		}
+		if runErr != nil {
+			t.Fatal(runErr.Err)
+		}
+		if expected := [][2]int{{0, 6}, {6, 12}, {12, 16}}; !reflect.DeepEqual(bands, expected) {
+			t.Errorf("expected bands %v, got %v", expected, bands)
+		}
+		if final == nil || final.CompletedTurns != 3 || len(final.Alive) != 16*16/2 {
+			t.Errorf("expected the fetched board at turn 3, got %+v", final)
+		}
+	}
+}
+
+// TestSaveEveryReports checks that every third report saves the board.
+func TestSaveEveryReports(t *testing.T) {
+	p := Params{ImageWidth: 16, ImageHeight: 16}
+	client, err := dialBroker(startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	events := make(chan Event)
+	c := startTestIo(p, nil)
+	c.events = events
+	reporter := Reporter{
+		EventsCh:       events,
+		ReportInterval: 5 * time.Millisecond,
+		Stop:           make(chan bool),
+		SaveEvery:      3,
+		stopped:        make(chan struct{}),
+		channels:       c,
+	}
+	go reporter.start(client)
+
+	// The save for the ninth report completes before the tenth report is sent.
+	reports, saves := 0, 0
+	for reports < 10 {
+		switch (<-events).(type) {
+		case AliveCellsCount:
+			reports++
+		case ImageOutputComplete:
+			saves++
+		}
+	}
+	reporter.stop()
+
+	if saves != 3 {
+		t.Errorf("expected 3 saves over 10 reports, got %d", saves)
+	}
+}
+
+// TestGolden compares a run's final board with a matching and a mismatching golden file.
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	extinct := newWorld(16, 16)
+	diverged := newWorld(16, 16)
+	diverged.Field.Data[2][5].Alive = true
+	diverged.Field.Data[9][1].Alive = true
+
+	for _, golden := range []struct {
+		name  string
+		world World
+		cells []util.Cell
+	}{
+		{"match.pgm", extinct, nil},
+		{"mismatch.rle", diverged, []util.Cell{{X: 5, Y: 2}, {X: 1, Y: 9}}},
+	} {
+		path := filepath.Join(dir, golden.name)
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Ext(path) == ".rle" {
+			err = golden.world.ToRLE(file)
+		} else {
+			err = golden.world.ToPGM(file)
+		}
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16, Golden: path}
+		p.BrokerAddr = startFakeBroker(t, &extinctBroker{stopAt: 100})
+
+		var mismatch *GoldenMismatch
+		for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+			switch e := event.(type) {
+			case GoldenMismatch:
+				mismatch = &e
+			case RunError:
+				t.Fatalf("%v: %v", golden.name, e)
+			}
+		}
+		if golden.cells == nil {
+			if mismatch != nil {
+				t.Errorf("%v: unexpected mismatch %v", golden.name, mismatch)
+			}
+			continue
+		}
+		if mismatch == nil {
+			t.Fatalf("%v: expected a GoldenMismatch", golden.name)
+		}
+		if fmt.Sprint(mismatch.Cells) != fmt.Sprint(golden.cells) || mismatch.CompletedTurns != 100 {
+			t.Errorf("%v: expected %v to differ at turn 100, got %v at turn %d",
+				golden.name, golden.cells, mismatch.Cells, mismatch.CompletedTurns)
+		}
+	}
+}
+
+// TestPattern seeds a run from an rle glider instead of the image and checks it is flipped and
+// run centred on the board.
+func TestPattern(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "glider.rle")
+	if err := os.WriteFile(path, []byte("#N Glider\nx = 3, y = 3, rule = B3/S23\nbo$2bo$3o!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := Params{Turns: 1, ImageWidth: 16, ImageHeight: 12, Pattern: path}
+	p.BrokerAddr = startFakeBroker(t, &reportingBroker{})
+
+	expected := []util.Cell{{X: 7, Y: 4}, {X: 8, Y: 5}, {X: 6, Y: 6}, {X: 7, Y: 6}, {X: 8, Y: 6}}
+	var flipped []util.Cell
+	var final *FinalTurnComplete
+	for _, event := range runDistributor(p, nil, nil) {
+		switch e := event.(type) {
+		case CellFlipped:
+			flipped = append(flipped, e.Cell)
+		case FinalTurnComplete:
+			final = &e
+		}
+	}
+	if fmt.Sprint(flipped) != fmt.Sprint(expected) {
+		t.Errorf("expected the glider flipped at %v, got %v", expected, flipped)
+	}
+	if final == nil || fmt.Sprint(final.Alive) != fmt.Sprint(expected) {
+		t.Errorf("expected the glider run at %v, got %v", expected, final)
+	}
+}
+
+// TestProgress checks the percentage at several points of a run, including one resumed from an
+// earlier turn, and that an unknown or overrun total is reported as indeterminate.
+func TestProgress(t *testing.T) {
+	for _, point := range []struct {
+		turns, total, startTurn int
+		percent                 float64
+	}{
+		{0, 200, 0, 0},
+		{50, 200, 0, 25},
+		{1, 3, 0, 100.0 / 3},
+		{200, 200, 0, 100},
+		{50, 200, 1000, 25},
+		{10, 0, 0, -1},
+		{201, 200, 0, -1},
+	} {
+		event := progress(int64(point.turns), point.total, point.startTurn)
+		if event.Percent != point.percent || event.Indeterminate() != (point.percent < 0) {
+			t.Errorf("%d of %d turns: expected %v%%, got %v%%", point.turns, point.total, point.percent, event.Percent)
+		}
+		if event.CompletedTurns != point.startTurn+point.turns || event.TotalTurns != point.startTurn+point.total {
+			t.Errorf("%d of %d turns from %d: got %d of %d", point.turns, point.total, point.startTurn, event.CompletedTurns, event.TotalTurns)
+		}
+	}
+
+	// A run stopped early by the broker ends with indeterminate progress.
+	p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16}
+	p.BrokerAddr = startFakeBroker(t, &extinctBroker{stopAt: 37})
+	stopped := false
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		if e, ok := event.(Progress); ok {
+			stopped = e.Indeterminate() && e.CompletedTurns == 37
+		}
+	}
+	if !stopped {
+		t.Error("expected indeterminate progress at turn 37")
+	}
+}
+
+// TestAliveOut checks the JSON written with -alive-out holds the final turn and exactly the
+// alive cells of the final board.
+func TestAliveOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alive.json")
+	p := Params{Turns: 7, ImageWidth: 16, ImageHeight: 16, AliveOut: path}
+	p.BrokerAddr = startFakeBroker(t, &reportingBroker{})
+
+	var final FinalTurnComplete
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		if e, ok := event.(FinalTurnComplete); ok {
+			final = e
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var output struct {
+		Turns int
+		Alive []struct{ X, Y int }
+	}
+	if err := json.NewDecoder(file).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+
+	if output.Turns != 7 {
+		t.Errorf("expected turn 7, got %d", output.Turns)
+	}
+	if len(output.Alive) != len(final.Alive) || len(final.Alive) != 128 {
+		t.Fatalf("expected %d alive cells, got %d", len(final.Alive), len(output.Alive))
+	}
+	for i, cell := range final.Alive {
+		if output.Alive[i].X != cell.X || output.Alive[i].Y != cell.Y {
+			t.Fatalf("cell %d: expected (%d, %d), got (%d, %d)", i, cell.X, cell.Y, output.Alive[i].X, output.Alive[i].Y)
+		}
+	}
+}
+
+// TestReporterStop stops a reporter that has already returned, and one stopped twice, and
+// checks neither blocks.
+func TestReporterStop(t *testing.T) {
+	client, err := dialBroker(startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	done := make(chan struct{})
+	reporter := Reporter{
+		EventsCh:       make(chan Event),
+		ReportInterval: time.Millisecond,
+		Stop:           make(chan bool),
+		stopped:        make(chan struct{}),
+		Done:           done,
+	}
+	returned := make(chan struct{})
+	go func() {
+		reporter.start(client)
+		close(returned)
+	}()
+	// The consumer going away ends the reports before the run does.
+	close(done)
+	<-returned
+
+	stopped := make(chan struct{})
+	go func() {
+		reporter.stop()
+		reporter.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stopping a reporter that had returned blocked")
+	}
+}
+
+// stallingReportBroker is a reportingBroker whose Report waits until release is closed,
+// once it has said it is reporting.
+type stallingReportBroker struct {
+	reportingBroker
+	reporting chan struct{}
+	release   chan struct{}
+	once      sync.Once
+}
+
+func (b *stallingReportBroker) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	b.once.Do(func() { close(b.reporting) })
+	<-b.release
+	return b.reportingBroker.Report(req, res)
+}
+
+// TestReporterStopInFlight stops a reporter while a report is in flight, with a consumer ready
+// for its events, and checks stop waits for the reporter to return and nothing is sent once
+// it has.
+func TestReporterStopInFlight(t *testing.T) {
+	broker := &stallingReportBroker{reporting: make(chan struct{}), release: make(chan struct{})}
+	client, err := dialBroker(startFakeBroker(t, broker), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	events := make(chan Event)
+	reporter := Reporter{
+		EventsCh:       events,
+		ReportInterval: time.Millisecond,
+		Stop:           make(chan bool),
+		stopped:        make(chan struct{}),
+		SaveEvery:      1,
+	}
+	go reporter.start(client)
+	<-broker.reporting
+
+	stopped := make(chan struct{})
+	go func() {
+		reporter.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stop returned with a report still in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// A consumer is ready for the report, which must not be sent once stop has begun.
+	received := make(chan Event, 10)
+	go func() {
+		for event := range events {
+			received <- event
+		}
+	}()
+	close(broker.release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop did not return once the report finished")
+	}
+	close(events)
+	if len(received) > 0 {
+		t.Errorf("expected nothing sent after stop, got %v", <-received)
+	}
+}
+
+// slowPauseBroker is a fakeBroker whose Pause takes delay to be acknowledged.
+type slowPauseBroker struct {
+	fakeBroker
+	delay time.Duration
+}
+
+func (b *slowPauseBroker) Pause(req BrokerPauseRequest, res *BrokerPauseResponse) (err error) {
+	time.Sleep(b.delay)
+	res.IsPaused = true
+	return
+}
+
+// TestControlLatency presses 'p' against a broker slow to pause and checks the round trip
+// is reported, and covers the injected delay.
+func TestControlLatency(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	broker := &slowPauseBroker{fakeBroker: fakeBroker{quit: make(chan bool)}, delay: delay}
+	p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16, BrokerAddr: startFakeBroker(t, broker), ControlLatency: true, NoInitialFlips: true}
+
+	events := make(chan Event, 1000)
+	keyPresses := make(chan rune)
+	c := startTestIo(p, seed(p.ImageHeight, p.ImageWidth, checkerboard))
+	c.events = events
+	c.keyPresses = keyPresses
+	go distributor(p, c)
+
+	keyPresses <- 'p'
+	var latency *ControlLatency
+	timeout := time.After(5 * time.Second)
+	for latency == nil {
+		select {
+		case event := <-events:
+			if e, ok := event.(ControlLatency); ok {
+				latency = &e
+			}
+		case <-timeout:
+			t.Fatal("no ControlLatency event for the pause")
+		}
+	}
+	close(keyPresses)
+	for range events {
+	}
+
+	if latency.Key != 'p' || latency.RTT < delay {
+		t.Errorf("expected 'p' acknowledged after at least %v, got %q after %v", delay, latency.Key, latency.RTT)
+	}
+}
+
+// steppingBroker is a fakeBroker paused at turn 7, whose Step flips cells and moves on a turn.
+type steppingBroker struct {
+	fakeBroker
+	cells []util.Cell
+}
+
+func (b *steppingBroker) Step(req BrokerStepRequest, res *BrokerStepResponse) (err error) {
+	res.Stepped = true
+	res.Turns = 8
+	res.Cells = b.cells
+	return
+}
+
+// TestStepKey presses 'n' and checks the cells the step flipped are emitted for the turn it
+// reached, followed by its TurnComplete.
+func TestStepKey(t *testing.T) {
+	cells := []util.Cell{{X: 1, Y: 2}, {X: 3, Y: 4}}
+	broker := &steppingBroker{fakeBroker: fakeBroker{quit: make(chan bool)}, cells: cells}
+	p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16, StartTurn: 10, BrokerAddr: startFakeBroker(t, broker), NoInitialFlips: true}
+
+	events := make(chan Event, 1000)
+	keyPresses := make(chan rune)
+	c := startTestIo(p, seed(p.ImageHeight, p.ImageWidth, checkerboard))
+	c.events = events
+	c.keyPresses = keyPresses
+	go distributor(p, c)
+
+	keyPresses <- 'n'
+	var flipped []CellFlipped
+	timeout := time.After(5 * time.Second)
+	for complete := false; !complete; {
+		select {
+		case event := <-events:
+			switch e := event.(type) {
+			case CellFlipped:
+				flipped = append(flipped, e)
+			case TurnComplete:
+				if e.CompletedTurns != 18 {
+					t.Errorf("expected the step to complete turn 18, got %v", e.CompletedTurns)
+				}
+				complete = true
+			}
+		case <-timeout:
+			t.Fatal("no TurnComplete for the step")
+		}
+	}
+	close(keyPresses)
+	for range events {
+	}
+
+	if len(flipped) != len(cells) {
+		t.Fatalf("expected %v cells flipped, got %v", len(cells), flipped)
+	}
+	for i, e := range flipped {
+		if e.Cell != cells[i] || e.CompletedTurns != 18 {
+			t.Errorf("expected %v flipped at turn 18, got %v at turn %v", cells[i], e.Cell, e.CompletedTurns)
+		}
+	}
+}
+
+// TestQuitTwice presses 'q' and then more keys, and checks none of them blocks and the run
+// is only quit once.
+func TestQuitTwice(t *testing.T) {
+	broker := &fakeBroker{quit: make(chan bool)}
+	p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16, BrokerAddr: startFakeBroker(t, broker), NoInitialFlips: true}
+
+	events := make(chan Event, 1000)
+	keyPresses := make(chan rune)
+	c := startTestIo(p, seed(p.ImageHeight, p.ImageWidth, checkerboard))
+	c.events = events
+	c.keyPresses = keyPresses
+	go distributor(p, c)
+
+	for _, key := range []rune{'q', 'q', 'p', 'q'} {
+		select {
+		case keyPresses <- key:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("pressing %q blocked", key)
+		}
+	}
+
+	quitting := 0
+	for event := range events {
+		if e, ok := event.(StateChange); ok && e.NewState == Quitting {
+			quitting++
+		} else if ok && e.NewState == Paused {
+			t.Error("expected keys after 'q' to do nothing, got a pause")
+		}
+	}
+	close(keyPresses)
+	// One for 'q', and one as the run ends.
+	if quitting != 2 {
+		t.Errorf("expected the run quit once, got %v Quitting events", quitting)
+	}
+}
+
+// savingBroker is a fakeBroker part way through a run of world, which it reports and snapshots.
+type savingBroker struct {
+	fakeBroker
+	turns int64
+	world World
+}
+
+func (b *savingBroker) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	res.Turns = b.turns
+	res.CellsCount = len(b.world.alive())
+	return
+}
+
+func (b *savingBroker) PauseAndSnapshot(req BrokerPauseAndSnapshotRequest, res *BrokerPauseAndSnapshotResponse) (err error) {
+	res.Turns = b.turns
+	res.World = b.world
+	return
+}
+
+// TestSaveKey presses 's' part way through a run and checks the image written holds as many
+// alive cells as the broker reports, rather than an empty board.
+func TestSaveKey(t *testing.T) {
+	world := newWorld(16, 16)
+	for y := 0; y < 16; y += 3 {
+		for x := y % 2; x < 16; x += 2 {
+			world.Field.Data[y][x].Alive = true
+		}
+	}
+	broker := &savingBroker{fakeBroker: fakeBroker{quit: make(chan bool)}, turns: 4, world: world}
+	p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16, NoInitialFlips: true}
+	p.BrokerAddr = startFakeBroker(t, broker)
+
+	events := make(chan Event, 1000)
+	keyPresses := make(chan rune)
+	// Room for the image saved for 's' and the one saved when the run ends.
+	saved := make(chan []uint8, 2)
+	c := startCapturingIo(p, seed(16, 16, checkerboard), saved)
+	c.events = events
+	c.keyPresses = keyPresses
+	go distributor(p, c)
+
+	keyPresses <- 's'
+	var image []uint8
+	select {
+	case image = <-saved:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was saved for 's'")
+	}
+	close(keyPresses)
+	for range events {
+	}
+
+	alive := 0
+	for _, value := range image {
+		if value == 255 {
+			alive++
+		}
+	}
+	if expected := len(world.alive()); alive != expected {
+		t.Errorf("expected the saved image to hold the broker's %v alive cells, got %v", expected, alive)
+	}
+}
+
+// TestSavePNG saves a non-square board as a png image and checks it has a dark pixel for every
+// alive cell and no other.
+func TestSavePNG(t *testing.T) {
+	world := newWorld(21, 37)
+	for y := 0; y < world.Height; y++ {
+		for x := 0; x < world.Width; x++ {
+			world.Field.Data[y][x].Alive = (x*3+y*7)%4 == 0
+		}
+	}
+	dir := t.TempDir()
+	if err := world.savePNG(9, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "37x21x9.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	decoded, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := decoded.Bounds(); bounds.Dx() != 37 || bounds.Dy() != 21 {
+		t.Fatalf("expected a 37x21 image, got %vx%v", bounds.Dx(), bounds.Dy())
+	}
+	dark := 0
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			if gray := color.GrayModel.Convert(decoded.At(x, y)).(color.Gray); gray.Y != 255 {
+				dark++
+				if !world.Field.Data[y][x].Alive {
+					t.Fatalf("pixel (%d, %d) is dark for a dead cell", x, y)
+				}
+			}
+		}
+	}
+	if expected := len(world.alive()); dark != expected {
+		t.Errorf("expected %v dark pixels, one per alive cell, got %v", expected, dark)
+	}
+}
+
+// summaryBroker is a BrokerService stand-in that completes every run with fixed statistics.
+type summaryBroker struct {
+	reportingBroker
+}
+
+func (b *summaryBroker) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	time.Sleep(10 * time.Millisecond)
+	res.World = Unpack(req.Packed)
+	res.Turns = req.Turns
+	res.Bytes = 4096
+	res.WorkerRegions = map[string]int64{"a:8030": 6, "b:8030": 4}
+	res.Failovers = 1
+	return
+}
+
+// TestRunSummary checks a short run ends with a RunSummary carrying the broker's statistics
+// and the client's timing, and that it is written to SummaryOut.
+func TestRunSummary(t *testing.T) {
+	world := newWorld(16, 16)
+	p := Params{Turns: 5, ImageWidth: 16, ImageHeight: 16, StartTurn: 2, NoInitialFlips: true}
+	p.BrokerAddr = startFakeBroker(t, &summaryBroker{reportingBroker{world: world}})
+	p.SummaryOut = filepath.Join(t.TempDir(), "summary.json")
+
+	var summary *RunSummary
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		if e, ok := event.(RunSummary); ok {
+			summary = &e
+		}
+	}
+	if summary == nil {
+		t.Fatal("no RunSummary was sent")
+	}
+	if summary.CompletedTurns != 7 || summary.AliveCells != 16*16/2 || summary.Bytes != 4096 || summary.Failovers != 1 {
+		t.Errorf("expected 7 turns, %v alive cells, 4096 bytes and 1 failover, got %+v", 16*16/2, *summary)
+	}
+	if summary.WorkerRegions["a:8030"] != 6 || summary.WorkerRegions["b:8030"] != 4 {
+		t.Errorf("expected the broker's per worker counts, got %v", summary.WorkerRegions)
+	}
+	if summary.Elapsed < 10*time.Millisecond || summary.TurnsPerSecond <= 0 || summary.TurnsPerSecond > 5/0.01 {
+		t.Errorf("expected 5 turns over at least 10ms, got %v turns/s over %v", summary.TurnsPerSecond, summary.Elapsed)
+	}
+
+	file, err := os.Open(p.SummaryOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var written struct {
+		Turns         int              `json:"turns"`
+		WorkerRegions map[string]int64 `json:"workerRegions"`
+	}
+	if err := json.NewDecoder(file).Decode(&written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Turns != 7 || len(written.WorkerRegions) != 2 {
+		t.Errorf("expected the summary file to hold turn 7 and 2 workers, got %+v", written)
+	}
+}
+
+// TestTurnOverflow checks a run whose last turn doesn't fit in an int is rejected before it
+// starts, rather than reporting turns that have wrapped around.
+func TestTurnOverflow(t *testing.T) {
+	maxInt := int(^uint(0) >> 1)
+	minInt := -maxInt - 1
+	for _, test := range []struct {
+		turns, startTurn int
+		valid            bool
+	}{
+		{10, 100, true},
+		{maxInt - 100, 100, true},
+		{maxInt - 99, 100, false},
+		{maxInt, maxInt, false},
+		{0, maxInt, true},
+		{-1, minInt, false},
+	} {
+		err := checkTurns(Params{Turns: test.turns, StartTurn: test.startTurn})
+		if test.valid != (err == nil) {
+			t.Errorf("%d turns from turn %d: expected valid=%v, got %v", test.turns, test.startTurn, test.valid, err)
+		}
+	}
+
+	p := Params{Turns: maxInt, ImageWidth: 16, ImageHeight: 16, StartTurn: 100, NoInitialFlips: true}
+	p.BrokerAddr = startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)})
+	var runError, final bool
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		switch e := event.(type) {
+		case RunError:
+			runError = e.CompletedTurns == 100
+		case FinalTurnComplete:
+			final = true
+		}
+	}
+	if !runError || final {
+		t.Errorf("expected just a RunError at turn 100, got a RunError %v and a final turn %v", runError, final)
+	}
+}
+
+// TestBrokerAddress checks the address is taken from Params, then the environment, then the
+// default, and that a malformed one is rejected.
+func TestBrokerAddress(t *testing.T) {
+	defer os.Setenv(BrokerEnv, os.Getenv(BrokerEnv))
+
+	tests := []struct {
+		param, env, expected string
+		valid                bool
+	}{
+		{"broker:8030", "env:8030", "broker:8030", true},
+		{"", "env:8030", "env:8030", true},
+		{"", "", DefaultBrokerAddr, true},
+		{":8030", "", ":8030", true},
+		{"broker", "", "", false},
+		{"broker:port", "", "", false},
+		{"broker:70000", "", "", false},
+		{"", "env", "", false},
+	}
+	for _, test := range tests {
+		os.Setenv(BrokerEnv, test.env)
+		addr, err := brokerAddress(Params{BrokerAddr: test.param})
+		if test.valid && (err != nil || addr != test.expected) {
+			t.Errorf("%q with %v=%q: expected %q, got %q, %v", test.param, BrokerEnv, test.env, test.expected, addr, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%q with %v=%q: expected an error, got %q", test.param, BrokerEnv, test.env, addr)
+		}
+	}
+}
+
+// TestUnreachableBroker checks a malformed or unreachable broker ends the run with a RunError
+// rather than the process.
+func TestUnreachableBroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	for _, addr := range []string{"127.0.0.1", closed} {
+		p := Params{Turns: 5, ImageWidth: 16, ImageHeight: 16, BrokerAddr: addr, NoInitialFlips: true}
+		var runError, final bool
+		for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+			switch event.(type) {
+			case RunError:
+				runError = true
+			case FinalTurnComplete:
+				final = true
+			}
+		}
+		if !runError || final {
+			t.Errorf("%v: expected just a RunError, got a RunError %v and a final turn %v", addr, runError, final)
+		}
+	}
+}
+
+// TestMissingPattern checks a pattern that cannot be loaded ends the run with a RunError
+// rather than the process.
+func TestMissingPattern(t *testing.T) {
+	p := Params{Turns: 5, ImageWidth: 16, ImageHeight: 16, NoInitialFlips: true}
+	p.BrokerAddr = startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)})
+	p.Pattern = filepath.Join(t.TempDir(), "missing.rle")
+
+	var runError, final bool
+	for _, event := range runDistributor(p, nil, nil) {
+		switch event.(type) {
+		case RunError:
+			runError = true
+		case FinalTurnComplete:
+			final = true
+		}
+	}
+	if !runError || final {
+		t.Errorf("expected just a RunError, got a RunError %v and a final turn %v", runError, final)
+	}
+}
+
+// TestShortInput feeds the distributor fewer bytes than the board has cells, and checks the
+// run ends with a RunError once InputTimeout passes rather than hanging.
+func TestShortInput(t *testing.T) {
+	defer func(timeout time.Duration) { InputTimeout = timeout }(InputTimeout)
+	InputTimeout = 100 * time.Millisecond
+
+	p := Params{Turns: 5, ImageWidth: 16, ImageHeight: 16, NoInitialFlips: true}
+	p.BrokerAddr = startFakeBroker(t, &reportingBroker{world: newWorld(16, 16)})
+
+	done := make(chan []Event)
+	go func() { done <- runDistributor(p, seed(16, 16, checkerboard)[:100], nil) }()
+	select {
+	case events := <-done:
+		var runError, final bool
+		for _, event := range events {
+			switch event.(type) {
+			case RunError:
+				runError = true
+			case FinalTurnComplete:
+				final = true
+			}
+		}
+		if !runError || final {
+			t.Errorf("expected just a RunError, got a RunError %v and a final turn %v", runError, final)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("still reading the input after 2s")
+	}
+}
+
+// TestSlowInput feeds the input image slower than InputTimeout overall but never pausing for
+// that long between cells, and checks it is all read rather than timed out.
+func TestSlowInput(t *testing.T) {
+	defer func(timeout time.Duration) { InputTimeout = timeout }(InputTimeout)
+	InputTimeout = 50 * time.Millisecond
+
+	input := make(chan uint8)
+	go func() {
+		for _, cell := range seed(4, 4, checkerboard) {
+			time.Sleep(10 * time.Millisecond)
+			input <- cell
+		}
+	}()
+
+	world := newWorld(4, 4)
+	if err := world.populate(distributorChannels{ioInput: input}, false, 0, 0); err != nil {
+		t.Fatalf("expected the slow input to be read, got %v", err)
+	}
+	want := seed(4, 4, checkerboard)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if world.Field.Data[y][x].Alive != (want[y*4+x] == 255) {
+				t.Fatalf("cell (%v, %v) read wrongly", x, y)
+			}
+		}
+	}
+}
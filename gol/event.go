@@ -2,6 +2,8 @@ package gol
 
 import (
 	"fmt"
+	"time"
+
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -21,6 +23,22 @@ type AliveCellsCount struct { // implements Event
 	CellsCount     int
 }
 
+// WorldSnapshot is an Event carrying every alive cell of the board.
+// This Event is sent every 2s in place of AliveCellsCount when the reporter is in snapshot mode.
+type WorldSnapshot struct { // implements Event
+	CompletedTurns int
+	Alive          []util.Cell
+}
+
+// Progress is an Event reporting how far through the requested turns the run is, sent along
+// with every report. Percent is negative when progress cannot be known, such as for a run
+// with no requested total or one that stopped early.
+type Progress struct { // implements Event
+	CompletedTurns int
+	TotalTurns     int
+	Percent        float64
+}
+
 // ImageOutputComplete is an Event notifying the user about the completion of output.
 // This Event should be sent every time an image has been saved.
 type ImageOutputComplete struct { // implements Event
@@ -52,9 +70,19 @@ type CellFlipped struct { // implements Event
 	Cell           util.Cell
 }
 
+// TurnRefresh is an Event sent in place of a turn's CellFlipped events when more cells changed
+// than Params.MaxFlipsPerTurn allows. The GUI should redraw the board from Alive, every cell
+// alive at the end of the turn, rather than apply individual flips.
+type TurnRefresh struct { // implements Event
+	CompletedTurns int
+	Alive          []util.Cell
+}
+
 // TurnComplete is an Event notifying the GUI about turn completion.
 // SDL will render a frame when this event is sent.
-// All CellFlipped events must be sent *before* TurnComplete.
+// All CellFlipped events must be sent *before* TurnComplete. In ReportTurns mode one is sent
+// for every turn, in order, and the CellFlipped events of turn n all come after
+// TurnComplete{n-1} and before TurnComplete{n}.
 type TurnComplete struct { // implements Event
 	CompletedTurns int
 }
@@ -67,6 +95,49 @@ type FinalTurnComplete struct {
 	Alive          []util.Cell
 }
 
+// RunError is an Event notifying the user that the broker aborted the run, or that it could
+// not be started. CompletedTurns is the last turn completed before the error, which is the turn
+// that gets saved if the run started.
+type RunError struct {
+	CompletedTurns int
+	Err            string
+}
+
+// Warning is an Event notifying the user of a run that is valid but probably not what they wanted.
+type Warning struct {
+	CompletedTurns int
+	Message        string
+}
+
+// GoldenMismatch is an Event notifying the user that the final board differs from the golden
+// board given in Params.Golden. Cells are the coordinates of the cells that differ.
+type GoldenMismatch struct {
+	CompletedTurns int
+	Golden         string
+	Cells          []util.Cell
+}
+
+// ControlLatency is an Event reporting how long the broker took to acknowledge a control
+// keypress, from sending its RPC to the reply. It is only sent when Params.ControlLatency is set.
+type ControlLatency struct {
+	CompletedTurns int
+	Key            rune
+	RTT            time.Duration
+}
+
+// RunSummary is an Event summing up a finished run, sent just before FinalTurnComplete.
+// Elapsed is the wall time of the run on the broker as the client saw it, and WorkerRegions
+// how many regions each worker computed, by address.
+type RunSummary struct {
+	CompletedTurns int
+	AliveCells     int
+	Elapsed        time.Duration
+	TurnsPerSecond float64
+	Bytes          int64
+	WorkerRegions  map[string]int64
+	Failovers      int64
+}
+
 // String methods allow the different types of Events and States to be printed.
 
 func (state State) String() string {
@@ -98,6 +169,30 @@ func (event AliveCellsCount) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event WorldSnapshot) String() string {
+	return fmt.Sprintf("Snapshot of %v alive cells", len(event.Alive))
+}
+
+func (event WorldSnapshot) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event Progress) String() string {
+	if event.Indeterminate() {
+		return fmt.Sprintf("Progress unknown at turn %v", event.CompletedTurns)
+	}
+	return fmt.Sprintf("Progress %.1f%% (%v/%v)", event.Percent, event.CompletedTurns, event.TotalTurns)
+}
+
+func (event Progress) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+// Indeterminate reports whether the total is unknown, so a UI should show activity rather than a bar.
+func (event Progress) Indeterminate() bool {
+	return event.Percent < 0
+}
+
 func (event ImageOutputComplete) String() string {
 	return fmt.Sprintf("File %v output complete", event.Filename)
 }
@@ -114,6 +209,14 @@ func (event CellFlipped) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event TurnRefresh) String() string {
+	return fmt.Sprintf("")
+}
+
+func (event TurnRefresh) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event TurnComplete) String() string {
 	return fmt.Sprintf("")
 }
@@ -130,6 +233,56 @@ func (event FinalTurnComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event ControlLatency) String() string {
+	return fmt.Sprintf("Key %q acknowledged in %v", event.Key, event.RTT)
+}
+
+func (event ControlLatency) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event RunSummary) String() string {
+	return fmt.Sprintf("Ran to turn %v in %v, %.1f turns/s, %v bytes over %v workers, %v failovers",
+		event.CompletedTurns, event.Elapsed, event.TurnsPerSecond, event.Bytes, len(event.WorkerRegions), event.Failovers)
+}
+
+func (event RunSummary) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event RunError) String() string {
+	return fmt.Sprintf("Run aborted: %v", event.Err)
+}
+
+func (event RunError) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event Warning) String() string {
+	return fmt.Sprintf("Warning: %v", event.Message)
+}
+
+func (event Warning) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event GoldenMismatch) String() string {
+	summary := fmt.Sprintf("%v cells differ from %v", len(event.Cells), event.Golden)
+	const shown = 10
+	for i, cell := range event.Cells {
+		if i == shown {
+			summary += fmt.Sprintf(" and %v more", len(event.Cells)-shown)
+			break
+		}
+		summary += fmt.Sprintf(" (%v, %v)", cell.X, cell.Y)
+	}
+	return summary
+}
+
+func (event GoldenMismatch) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 // This might all seem like weird syntax to you...
 // You have however seen something similar to it before in first year.
 
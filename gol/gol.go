@@ -1,5 +1,35 @@
 package gol
 
+import "sync"
+
+// ReportMode selects what the reporter emits every interval.
+type ReportMode string
+
+const (
+	// ReportCount emits AliveCellsCount events, which only need the count from the broker.
+	ReportCount ReportMode = "count"
+	// ReportSnapshot emits WorldSnapshot events carrying the whole board.
+	ReportSnapshot ReportMode = "snapshot"
+	// ReportFlips emits a CellFlipped event for every cell changed since the last report,
+	// then a TurnComplete and an AliveCellsCount, so a live view animates the board.
+	ReportFlips ReportMode = "flips"
+	// ReportTurns streams every turn as the broker completes it, its CellFlipped events then
+	// its TurnComplete, so a live view steps through the turns one by one. AliveCellsCount
+	// events still follow every interval.
+	ReportTurns ReportMode = "turns"
+)
+
+// Boundary is what lies beyond the edges of the board.
+type Boundary string
+
+const (
+	// Toroidal wraps each edge of the board round to the opposite one. It is the default.
+	Toroidal Boundary = "toroidal"
+	// Fixed surrounds the board with cells that are always dead, so patterns leaving the
+	// board die at its edge.
+	Fixed Boundary = "fixed"
+)
+
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns       int
@@ -7,9 +37,75 @@ type Params struct {
 	ImageWidth  int
 	ImageHeight int
 	BrokerAddr  string
+
+	// TLSCA is a CA certificate file the broker's certificate must be signed by, to reach it
+	// over TLS. Empty dials it over plain TCP.
+	TLSCA string
+
+	// Boundary is what lies beyond the edges of the board, Toroidal when empty.
+	Boundary Boundary
+
+	// Rule is the rule the board evolves by, Conway's B3/S23 when zero.
+	Rule Rule
+
+	// NoInitialFlips skips the CellFlipped events for the cells alive in the loaded image.
+	NoInitialFlips bool
+
+	// MaxFlipsPerTurn caps the CellFlipped events sent for a turn. A turn changing more cells
+	// sends a single TurnRefresh with the whole board instead. Zero sends every flip.
+	MaxFlipsPerTurn int
+
+	// BandRows fetches the final board from the broker in bands of this many rows once the run
+	// is over, rather than in one response, for boards too large for one. Zero fetches it
+	// whole.
+	BandRows int
+
+	// ReportMode is what gets reported every interval, AliveCellsCount unless set to
+	// ReportSnapshot, ReportFlips or ReportTurns.
+	ReportMode ReportMode
+
+	// SaveEveryReports saves the board on every n-th report, for a sparse timelapse of long
+	// unattended runs. Zero disables it.
+	SaveEveryReports int
+
+	// Interactive is set when a user is watching the run, such as in the SDL window, and
+	// enables warnings about runs that would be fine headless.
+	Interactive bool
+
+	// StartTurn is the turn the loaded image was saved at, so a resumed run's events and
+	// filenames carry on from it rather than starting again at 0.
+	StartTurn int
+
+	// GzipOutput saves images gzipped as out/<name>.pgm.gz instead of raw pgm files.
+	GzipOutput bool
+
+	// SaveOnSignal saves the board whenever the process receives SIGUSR1, as 's' does,
+	// without interrupting the run. It has no effect on Windows.
+	SaveOnSignal bool
+
+	// AliveOut is a file the final turn and its alive cells are written to as JSON, for tools
+	// that only want coordinates. Empty skips it.
+	AliveOut string
+
+	// ControlLatency emits a ControlLatency event for every control keypress, timing the
+	// round trip of its RPC to the broker.
+	ControlLatency bool
+
+	// SummaryOut is a file the RunSummary is written to as JSON. Empty skips it.
+	SummaryOut string
+
+	// Pattern is an rle or pgm file the board is seeded from instead of the input image,
+	// centred on a board of ImageWidth by ImageHeight. Empty reads the image.
+	Pattern string
+
+	// Golden is a pgm or rle file the final board is compared against, with any difference
+	// reported as a GoldenMismatch. Empty skips the comparison.
+	Golden string
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
+// The consumer of events can close keyPresses to signal that it has exited, after which
+// the run is stopped on the broker and no further events are sent.
 func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 
 	ioCommand := make(chan ioCommand)
@@ -17,6 +113,7 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 	ioFileName := make(chan string)
 	ioOutput := make(chan uint8)
 	ioInput := make(chan uint8)
+	ioInputErr := make(chan error)
 
 	ioChannels := ioChannels{
 		command:  ioCommand,
@@ -24,6 +121,7 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		filename: ioFileName,
 		output:   ioOutput,
 		input:    ioInput,
+		inputErr: ioInputErr,
 	}
 	go startIo(p, ioChannels)
 
@@ -34,7 +132,9 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		ioFilename: ioFileName,
 		ioOutput:   ioOutput,
 		ioInput:    ioInput,
+		ioInputErr: ioInputErr,
 		keyPresses: keyPresses,
+		saving:     new(sync.Mutex),
 	}
 
 	distributor(p, distributorChannels)
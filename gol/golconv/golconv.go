@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// read loads a world from a .pgm or .rle file.
+func read(path string) (gol.World, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return gol.World{}, err
+	}
+	defer file.Close()
+
+	switch filepath.Ext(path) {
+	case ".pgm":
+		return gol.ParsePGM(file)
+	case ".rle":
+		return gol.ParseRLE(file)
+	default:
+		return gol.World{}, fmt.Errorf("%v: unknown format, expected .pgm or .rle", path)
+	}
+}
+
+// write saves world to a .pgm or .rle file.
+func write(path string, world gol.World) error {
+	var encode func(*os.File) error
+	switch filepath.Ext(path) {
+	case ".pgm":
+		encode = func(file *os.File) error { return world.ToPGM(file) }
+	case ".rle":
+		encode = func(file *os.File) error { return world.ToRLE(file) }
+	default:
+		return fmt.Errorf("%v: unknown format, expected .pgm or .rle", path)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encode(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func convert(in, out string) error {
+	world, err := read(in)
+	if err != nil {
+		return err
+	}
+	return write(out, world)
+}
+
+// main converts a board between the pgm and rle formats, e.g. golconv -in x.pgm -out x.rle
+func main() {
+	in := flag.String("in", "", "File to convert, .pgm or .rle")
+	out := flag.String("out", "", "File to write, .pgm or .rle")
+	flag.Parse()
+
+	if *in == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := convert(*in, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "golconv:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+var patterns = map[string]string{
+	"glider":  "x = 5, y = 5, rule = B3/S23\nbo$2bo$3o!\n",
+	"blinker": "x = 3, y = 3\n$3o!\n",
+	"gun": `#N Gosper glider gun
+x = 36, y = 9, rule = B3/S23
+24bo$22bobo$12b2o6b2o12b2o$11bo3bo4b2o12b2o$2o8bo5bo3b2o$2o8bo3bob2o4b
+obo$10bo5bo7bo$11bo3bo$12b2o!
+`,
+}
+
+func sameBoard(a, b gol.World) bool {
+	if a.Height != b.Height || a.Width != b.Width {
+		return false
+	}
+	for y := range a.Field.Data {
+		for x := range a.Field.Data[y] {
+			if a.Field.Data[y][x].Alive != b.Field.Data[y][x].Alive {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TestRoundTrip converts each pattern rle -> pgm -> rle and pgm -> rle -> pgm.
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	for name, pattern := range patterns {
+		t.Run(name, func(t *testing.T) {
+			original, err := gol.ParseRLE(strings.NewReader(pattern))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rle := filepath.Join(dir, name+".rle")
+			pgm := filepath.Join(dir, name+".pgm")
+			rleAgain := filepath.Join(dir, name+"-again.rle")
+			pgmAgain := filepath.Join(dir, name+"-again.pgm")
+			if err := ioutil.WriteFile(rle, []byte(pattern), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, step := range [][2]string{{rle, pgm}, {pgm, rleAgain}, {rleAgain, pgmAgain}} {
+				if err := convert(step[0], step[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, path := range []string{pgm, rleAgain, pgmAgain} {
+				converted, err := read(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !sameBoard(converted, original) {
+					t.Errorf("%v does not match the original pattern", filepath.Base(path))
+				}
+			}
+
+			first, _ := ioutil.ReadFile(pgm)
+			second, _ := ioutil.ReadFile(pgmAgain)
+			if string(first) != string(second) {
+				t.Error("pgm -> rle -> pgm changed the image")
+			}
+		})
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if err := convert("board.png", "board.rle"); err == nil {
+		t.Error("expected an error for an unknown input format")
+	}
+}
@@ -0,0 +1,102 @@
+package gol
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// RunHeadless runs p on the broker at addr, or at the broker p names when addr is empty, with
+// no events, keypresses or io goroutine, for scripting throughput measurements. It returns the
+// turns the broker completed, how long its Process call took and the alive cells left at the
+// end. The board is the p.Pattern file, or else the image under images/ that Run would load.
+func RunHeadless(p Params, addr string) (turns int, elapsed time.Duration, finalAlive int, err error) {
+	if addr != "" {
+		p.BrokerAddr = addr
+	}
+	brokerAddr, err := brokerAddress(p)
+	if err != nil {
+		return
+	}
+	world, err := headlessWorld(p)
+	if err != nil {
+		return
+	}
+
+	tlsConfig, err := util.ClientTLS(p.TLSCA)
+	if err != nil {
+		return
+	}
+	client, err := dialBroker(brokerAddr, tlsConfig)
+	if err != nil {
+		err = fmt.Errorf("dialing the broker: %v", err)
+		return
+	}
+	defer client.Close()
+
+	token, err := newToken()
+	if err != nil {
+		return
+	}
+	var sessionID string
+	startResponse := new(BrokerStartResponse)
+	if client.Call(BrokerStart, BrokerStartRequest{}, startResponse) == nil {
+		sessionID = startResponse.SessionID
+	}
+
+	processRequest := BrokerProcessRequest{
+		SessionID: sessionID,
+		Packed:    Pack(world),
+		Turns:     int64(p.Turns),
+		Token:     token,
+		Boundary:  p.Boundary,
+		Rule:      p.Rule,
+		Banded:    p.BandRows > 0,
+	}
+	processResponse := new(BrokerProcessResponse)
+	started := time.Now()
+	err = client.Call(BrokerProcess, processRequest, processResponse)
+	elapsed = time.Since(started)
+	turns = int(processResponse.Turns)
+	if err == nil && p.BandRows > 0 {
+		if processResponse.World, err = fetchBands(client, sessionID, p.BandRows, world.Height, world.Width); err != nil {
+			err = fmt.Errorf("fetching the final board: %v", err)
+		}
+	} else if err == nil && processResponse.Packed.Height > 0 {
+		processResponse.World = Unpack(processResponse.Packed)
+	}
+	if sessionID != "" {
+		client.Call(BrokerQuit, BrokerQuitRequest{SessionID: sessionID, Token: token}, new(BrokerQuitResponse))
+	}
+	if err != nil {
+		return
+	}
+	finalAlive = len(processResponse.World.alive())
+	return
+}
+
+// headlessWorld loads the board RunHeadless starts from, p.Pattern centred on a board of p's
+// size, or else the image of p's size under images/.
+func headlessWorld(p Params) (World, error) {
+	if p.Pattern != "" {
+		pattern, err := LoadWorld(p.Pattern)
+		if err == nil {
+			pattern, err = pattern.centred(p.ImageHeight, p.ImageWidth)
+		}
+		if err != nil {
+			return World{}, fmt.Errorf("loading pattern %v: %v", p.Pattern, err)
+		}
+		return pattern, nil
+	}
+	path := filepath.Join("images", fmt.Sprintf("%vx%v.pgm", p.ImageWidth, p.ImageHeight))
+	world, err := LoadWorld(path)
+	if err != nil {
+		return World{}, err
+	}
+	if world.Width != p.ImageWidth || world.Height != p.ImageHeight {
+		return World{}, fmt.Errorf("%v is %vx%v, not the %vx%v asked for", path, world.Width, world.Height, p.ImageWidth, p.ImageHeight)
+	}
+	return world, nil
+}
@@ -0,0 +1,39 @@
+package gol
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRunHeadless runs a glider pattern on a broker handing the board straight back, and
+// checks the turns and alive cells come back without any events or io goroutine.
+func TestRunHeadless(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "glider.rle")
+	if err := os.WriteFile(path, []byte("x = 3, y = 3\nbo$2bo$3o!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := Params{Turns: 7, ImageWidth: 16, ImageHeight: 12, Pattern: path}
+	turns, elapsed, alive, err := RunHeadless(p, startFakeBroker(t, &reportingBroker{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if turns != 7 || alive != 5 || elapsed <= 0 {
+		t.Errorf("expected 7 turns leaving 5 alive cells, got %v turns and %v cells in %v", turns, alive, elapsed)
+	}
+
+	// Without a pattern the board is read from images/, which has no 16x12 image.
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	p.Pattern = ""
+	if _, _, _, err := RunHeadless(p, startFakeBroker(t, &reportingBroker{})); err == nil {
+		t.Error("expected a missing image to be an error")
+	}
+}
@@ -1,6 +1,8 @@
 package gol
 
 import (
+	"bufio"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -16,6 +18,8 @@ type ioChannels struct {
 	filename <-chan string
 	output   <-chan uint8
 	input    chan<- uint8
+	// inputErr is sent why the image could not be read, instead of its bytes.
+	inputErr chan<- error
 }
 
 // ioState is the internal ioState of the io goroutine.
@@ -45,18 +49,30 @@ func (io *ioState) writePgmImage() {
 	// Request a filename from the distributor.
 	filename := <-io.channels.filename
 
-	file, ioError := os.Create("out/" + filename + ".pgm")
+	extension := ".pgm"
+	if io.params.GzipOutput {
+		extension = ".pgm.gz"
+	}
+	file, ioError := os.Create("out/" + filename + extension)
 	util.Check(ioError)
 	defer file.Close()
 
-	_, _ = file.WriteString("P5\n")
+	// Stream through gzip when asked to, the pgm written is the same either way.
+	var compressed *gzip.Writer
+	writer := bufio.NewWriter(file)
+	if io.params.GzipOutput {
+		compressed = gzip.NewWriter(file)
+		writer = bufio.NewWriter(compressed)
+	}
+
+	_, _ = writer.WriteString("P5\n")
 	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
-	_, _ = file.WriteString(" ")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
-	_, _ = file.WriteString("\n")
-	_, _ = file.WriteString(strconv.Itoa(255))
-	_, _ = file.WriteString("\n")
+	_, _ = writer.WriteString(strconv.Itoa(io.params.ImageWidth))
+	_, _ = writer.WriteString(" ")
+	_, _ = writer.WriteString(strconv.Itoa(io.params.ImageHeight))
+	_, _ = writer.WriteString("\n")
+	_, _ = writer.WriteString(strconv.Itoa(255))
+	_, _ = writer.WriteString("\n")
 
 	world := make([][]byte, io.params.ImageHeight)
 	for i := range world {
@@ -75,54 +91,66 @@ func (io *ioState) writePgmImage() {
 
 	for y := 0; y < io.params.ImageHeight; y++ {
 		for x := 0; x < io.params.ImageWidth; x++ {
-			_, ioError = file.Write([]byte{world[y][x]})
+			_, ioError = writer.Write([]byte{world[y][x]})
 			util.Check(ioError)
 		}
 	}
 
+	util.Check(writer.Flush())
+	if compressed != nil {
+		util.Check(compressed.Close())
+	}
+
 	ioError = file.Sync()
 	util.Check(ioError)
 
 	fmt.Println("File", filename, "output done!")
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
+// readPgmImage opens a pgm file and sends its data as an array of bytes. A file that is not
+// a pgm image of the board's size, with a byte for every cell, is an error and none is sent.
+func (io *ioState) readPgmImage() error {
 
 	// Request a filename from the distributor.
 	filename := <-io.channels.filename
+	path := "images/" + filename + ".pgm"
 
-	data, ioError := ioutil.ReadFile("images/" + filename + ".pgm")
-	util.Check(ioError)
+	data, ioError := ioutil.ReadFile(path)
+	if ioError != nil {
+		return ioError
+	}
 
 	fields := strings.Fields(string(data))
 
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+	if len(fields) < 4 || fields[0] != "P5" {
+		return fmt.Errorf("%v is not a pgm file", path)
 	}
 
 	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
-	}
-
 	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+	if width != io.params.ImageWidth || height != io.params.ImageHeight {
+		return fmt.Errorf("%v is %vx%v, not the %vx%v asked for", path, fields[1], fields[2], io.params.ImageWidth, io.params.ImageHeight)
 	}
 
 	maxval, _ := strconv.Atoi(fields[3])
 	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+		return fmt.Errorf("%v has a maxval of %v, not 255", path, fields[3])
 	}
 
-	image := []byte(fields[4])
+	var image []byte
+	if len(fields) > 4 {
+		image = []byte(fields[4])
+	}
+	if len(image) < width*height {
+		return fmt.Errorf("%v is truncated, with %v of its %v cells", path, len(image), width*height)
+	}
 
-	for _, b := range image {
+	for _, b := range image[:width*height] {
 		io.channels.input <- b
 	}
 
 	fmt.Println("File", filename, "input done!")
+	return nil
 }
 
 // startIo should be the entrypoint of the io goroutine.
@@ -138,7 +166,9 @@ func startIo(p Params, c ioChannels) {
 		case command := <-io.channels.command:
 			switch command {
 			case ioInput:
-				io.readPgmImage()
+				if err := io.readPgmImage(); err != nil {
+					io.channels.inputErr <- err
+				}
 			case ioOutput:
 				io.writePgmImage()
 			case ioCheckIdle:
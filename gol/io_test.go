@@ -0,0 +1,111 @@
+package gol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestGzipOutput saves an image through the io goroutine with GzipOutput set and checks the
+// file decompresses to the pgm that was sent.
+func TestGzipOutput(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	p := Params{ImageWidth: 16, ImageHeight: 8, GzipOutput: true}
+	image := seed(p.ImageHeight, p.ImageWidth, checkerboard)
+
+	commands := make(chan ioCommand)
+	idle := make(chan bool)
+	filenames := make(chan string)
+	output := make(chan uint8)
+	go startIo(p, ioChannels{command: commands, idle: idle, filename: filenames, output: output})
+
+	commands <- ioOutput
+	filenames <- "16x8x0"
+	for _, b := range image {
+		output <- b
+	}
+	commands <- ioCheckIdle
+	<-idle
+
+	file, err := os.Open("out/16x8x0.pgm.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	decompressed, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matrix, err := util.ReadPgm(decompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matrix) != p.ImageHeight || len(matrix[0]) != p.ImageWidth {
+		t.Fatalf("expected a %dx%d image, got %dx%d", p.ImageWidth, p.ImageHeight, len(matrix[0]), len(matrix))
+	}
+	if !bytes.Equal(bytes.Join(matrix, nil), image) {
+		t.Error("decompressed image does not match the one saved")
+	}
+}
+
+// TestReadPgmErrors reads images of the wrong size, short of cells and not pgm at all through
+// the io goroutine, and checks each is reported as an error rather than sent.
+func TestReadPgmErrors(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Mkdir("images", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	p := Params{ImageWidth: 16, ImageHeight: 8}
+	cells := string(seed(8, 16, func(x, y int) bool { return true }))
+	files := map[string]string{
+		"wrong size": "P5\n8 16\n255\n" + cells,
+		"truncated":  "P5\n16 8\n255\n" + cells[:100],
+		"not a pgm":  "P2\n16 8\n255\n" + cells,
+		"empty":      "",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile("images/16x8.pgm", []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		commands := make(chan ioCommand)
+		filenames := make(chan string)
+		input := make(chan uint8)
+		inputErr := make(chan error)
+		go startIo(p, ioChannels{command: commands, filename: filenames, input: input, inputErr: inputErr})
+
+		commands <- ioInput
+		filenames <- "16x8"
+		select {
+		case err := <-inputErr:
+			if !strings.Contains(err.Error(), "images/16x8.pgm") {
+				t.Errorf("%v: expected the error to name the file, got %v", name, err)
+			}
+		case <-input:
+			t.Errorf("%v: expected an error, got a cell", name)
+		case <-time.After(time.Second):
+			t.Errorf("%v: expected an error, got nothing", name)
+		}
+	}
+}
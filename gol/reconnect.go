@@ -0,0 +1,134 @@
+package gol
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// ReconnectAttempts is how many times a dropped broker connection is dialled again before the
+// call that found it dropped gives up.
+var ReconnectAttempts = 8
+
+// ReconnectBackoff is the wait before the first attempt to dial the broker again, doubled
+// after every failed attempt.
+var ReconnectBackoff = 250 * time.Millisecond
+
+// brokerClient is the connection to the broker, dialled again when it drops. It is shared by
+// the run and the reporter, so a connection one of them dials again serves both.
+type brokerClient struct {
+	addr string
+	// tls verifies the broker when it is dialled over TLS, and is nil over plain TCP.
+	tls *tls.Config
+
+	mu     sync.Mutex
+	client *rpc.Client
+	// closed is set by Close, after which a dropped connection stays dropped.
+	closed bool
+}
+
+func dialBroker(addr string, config *tls.Config) (*brokerClient, error) {
+	client, err := util.DialRPC(addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return &brokerClient{addr: addr, tls: config, client: client}, nil
+}
+
+// Call calls method on the broker, and again on a new connection if the connection drops. Only
+// a call that is safe to repeat is made again once the broker may have received it, so a
+// toggle like Pause or a Step is never applied twice when just its reply was lost: other calls
+// return the error unless the connection was found dropped before they were sent. A Process
+// call is made again with Resume, so it waits for the run it started rather than starting it
+// over.
+func (b *brokerClient) Call(method string, args interface{}, reply interface{}) error {
+	client := b.current()
+	err := client.Call(method, args, reply)
+	for attempt := 0; retriable(method, err) && attempt < ReconnectAttempts && !b.isClosed(); attempt++ {
+		time.Sleep(ReconnectBackoff << attempt)
+		if client, err = b.redial(client); err == rpc.ErrShutdown {
+			break
+		} else if err != nil {
+			continue
+		}
+		if request, ok := args.(BrokerProcessRequest); ok {
+			request.Resume = true
+			args = request
+		}
+		err = client.Call(method, args, reply)
+	}
+	return err
+}
+
+// retriable reports whether a call to method that failed with err may be made again on a new
+// connection. An rpc.ErrShutdown is the connection found closed before the call was sent, so
+// the broker never saw it; any other dropped connection may have lost just the reply.
+func retriable(method string, err error) bool {
+	if err == rpc.ErrShutdown {
+		return true
+	}
+	return disconnected(err) && idempotent(method)
+}
+
+// idempotent reports whether calling method on the broker twice has the same effect as once.
+// NextTurns is not, as it takes the turns it returns.
+func idempotent(method string) bool {
+	switch method {
+	case BrokerProcess, BrokerReport, BrokerFlips, BrokerSave,
+		BrokerPauseAndSnapshot, BrokerGetWorld, BrokerGetThumbnail, BrokerFetchBand:
+		return true
+	}
+	return false
+}
+
+func (b *brokerClient) current() *rpc.Client {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.client
+}
+
+// redial replaces the dropped connection failed, unless it has been replaced already.
+func (b *brokerClient) redial(failed *rpc.Client) (*rpc.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return failed, rpc.ErrShutdown
+	}
+	if b.client != failed {
+		return b.client, nil
+	}
+	client, err := util.DialRPC(b.addr, b.tls)
+	if err != nil {
+		return failed, err
+	}
+	failed.Close()
+	b.client = client
+	return client, nil
+}
+
+func (b *brokerClient) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *brokerClient) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return b.client.Close()
+}
+
+// disconnected reports whether err is the connection to the broker dropping, rather than an
+// error the broker answered with.
+func disconnected(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
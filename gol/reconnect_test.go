@@ -0,0 +1,212 @@
+package gol
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bouncingBroker is a BrokerService stand-in whose first Process waits until the connection
+// is dropped, and answers once it is resumed.
+type bouncingBroker struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu      sync.Mutex
+	first   BrokerProcessRequest
+	resumed []BrokerProcessRequest
+}
+
+func (b *bouncingBroker) Start(req BrokerStartRequest, res *BrokerStartResponse) (err error) {
+	res.SessionID = "bounced"
+	return
+}
+
+func (b *bouncingBroker) Process(req BrokerProcessRequest, res *BrokerProcessResponse) (err error) {
+	if !req.Resume {
+		b.mu.Lock()
+		b.first = req
+		b.mu.Unlock()
+		close(b.started)
+		<-b.release
+		return
+	}
+	b.mu.Lock()
+	b.resumed = append(b.resumed, req)
+	b.mu.Unlock()
+	res.World = Unpack(req.Packed)
+	res.Turns = req.Turns
+	return
+}
+
+func (b *bouncingBroker) Report(req BrokerReportRequest, res *BrokerReportResponse) (err error) {
+	return
+}
+
+func (b *bouncingBroker) Quit(req BrokerQuitRequest, res *BrokerQuitResponse) (err error) {
+	return
+}
+
+// bouncer serves a broker, and can drop every connection to it and listen again.
+type bouncer struct {
+	server *rpc.Server
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    []net.Conn
+}
+
+func (b *bouncer) listen(addr string) (string, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	b.mu.Lock()
+	b.listener = listener
+	b.mu.Unlock()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conns = append(b.conns, conn)
+			b.mu.Unlock()
+			go b.server.ServeConn(conn)
+		}
+	}()
+	return listener.Addr().String(), nil
+}
+
+// drop closes the listener and every connection accepted by it.
+func (b *bouncer) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listener.Close()
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+	b.conns = nil
+}
+
+// TestReconnect drops the broker's connections and listener mid-run, and checks the
+// distributor dials it again and resumes the run in its session.
+func TestReconnect(t *testing.T) {
+	defer func(backoff time.Duration) { ReconnectBackoff = backoff }(ReconnectBackoff)
+	ReconnectBackoff = 10 * time.Millisecond
+
+	broker := &bouncingBroker{started: make(chan struct{}), release: make(chan struct{})}
+	defer close(broker.release)
+	server := rpc.NewServer()
+	if err := server.RegisterName("BrokerService", broker); err != nil {
+		t.Fatal(err)
+	}
+	b := &bouncer{server: server}
+	addr, err := b.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.drop()
+
+	go func() {
+		<-broker.started
+		b.drop()
+		// Down for long enough that the first attempts to dial again fail.
+		time.Sleep(50 * time.Millisecond)
+		if _, err := b.listen(addr); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	p := Params{Turns: 5, ImageWidth: 16, ImageHeight: 16, BrokerAddr: addr, NoInitialFlips: true}
+	var final *FinalTurnComplete
+	for _, event := range runDistributor(p, seed(16, 16, checkerboard), nil) {
+		switch e := event.(type) {
+		case RunError:
+			t.Errorf("expected the run to resume, got %v", e.Err)
+		case FinalTurnComplete:
+			final = &e
+		}
+	}
+	if final == nil || final.CompletedTurns != 5 || len(final.Alive) != 16*16/2 {
+		t.Fatalf("expected the resumed run to finish at turn 5, got %+v", final)
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if len(broker.resumed) != 1 {
+		t.Fatalf("expected Process to be resumed once, got %v", len(broker.resumed))
+	}
+	resumed := broker.resumed[0]
+	if resumed.SessionID != "bounced" || resumed.Token != broker.first.Token || resumed.Token == "" {
+		t.Errorf("expected the resumed Process in session bounced with the run's token, got %q with %q", resumed.SessionID, resumed.Token)
+	}
+}
+
+// pausingBroker is a BrokerService stand-in whose first Pause waits until the connection is
+// dropped, so the pause is applied but its reply lost.
+type pausingBroker struct {
+	applied chan struct{}
+	release chan struct{}
+
+	mu     sync.Mutex
+	pauses int
+}
+
+func (b *pausingBroker) Pause(req BrokerPauseRequest, res *BrokerPauseResponse) (err error) {
+	b.mu.Lock()
+	b.pauses++
+	first := b.pauses == 1
+	b.mu.Unlock()
+	if first {
+		close(b.applied)
+		<-b.release
+	}
+	return
+}
+
+// TestReconnectNoReplay drops the broker's connections while a Pause is being answered, and
+// checks the Pause fails rather than being made again and toggling the run back.
+func TestReconnectNoReplay(t *testing.T) {
+	defer func(backoff time.Duration) { ReconnectBackoff = backoff }(ReconnectBackoff)
+	ReconnectBackoff = 10 * time.Millisecond
+
+	broker := &pausingBroker{applied: make(chan struct{}), release: make(chan struct{})}
+	defer close(broker.release)
+	server := rpc.NewServer()
+	if err := server.RegisterName("BrokerService", broker); err != nil {
+		t.Fatal(err)
+	}
+	b := &bouncer{server: server}
+	addr, err := b.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.drop()
+
+	go func() {
+		<-broker.applied
+		b.drop()
+		if _, err := b.listen(addr); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	client, err := dialBroker(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Call(BrokerPause, BrokerPauseRequest{}, new(BrokerPauseResponse)); !disconnected(err) {
+		t.Errorf("expected the Pause to fail with the dropped connection, got %v", err)
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.pauses != 1 {
+		t.Errorf("expected Pause to be applied once, got %v", broker.pauses)
+	}
+}
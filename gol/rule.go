@@ -0,0 +1,73 @@
+package gol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule is a Life-like rule, the neighbour counts on which a dead cell is born and a live one
+// survives. The zero Rule is Conway's B3/S23.
+type Rule struct {
+	Birth    []int
+	Survival []int
+}
+
+// Conway is the rule of Conway's Game of Life.
+var Conway = Rule{Birth: []int{3}, Survival: []int{2, 3}}
+
+// ParseRule reads a rule written as B, the birth counts, then /S and the survival counts, such
+// as B36/S23 for HighLife or B2/S for Seeds.
+func ParseRule(rule string) (Rule, error) {
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(rule)), "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "B") || !strings.HasPrefix(parts[1], "S") {
+		return Rule{}, fmt.Errorf("rule %q is not of the form B3/S23", rule)
+	}
+	birth, err := ruleCounts(parts[0][1:])
+	if err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %v", rule, err)
+	}
+	survival, err := ruleCounts(parts[1][1:])
+	if err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %v", rule, err)
+	}
+	// It would read as the zero Rule, which is Conway's, once sent to the broker.
+	if len(birth) == 0 && len(survival) == 0 {
+		return Rule{}, fmt.Errorf("rule %q has neither births nor survivals", rule)
+	}
+	return Rule{Birth: birth, Survival: survival}, nil
+}
+
+// ruleCounts reads the neighbour counts of one half of a rule, each a digit from 0 to 8.
+func ruleCounts(digits string) ([]int, error) {
+	var counts []int
+	seen := make(map[int]bool)
+	for _, digit := range digits {
+		if digit < '0' || digit > '8' {
+			return nil, fmt.Errorf("%q is not a neighbour count from 0 to 8", digit)
+		}
+		count := int(digit - '0')
+		if seen[count] {
+			return nil, fmt.Errorf("neighbour count %v is repeated", count)
+		}
+		seen[count] = true
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+// String writes the rule as ParseRule reads it.
+func (rule Rule) String() string {
+	if rule.Birth == nil && rule.Survival == nil {
+		rule = Conway
+	}
+	var builder strings.Builder
+	builder.WriteString("B")
+	for _, count := range rule.Birth {
+		builder.WriteByte(byte('0' + count))
+	}
+	builder.WriteString("/S")
+	for _, count := range rule.Survival {
+		builder.WriteByte(byte('0' + count))
+	}
+	return builder.String()
+}
@@ -0,0 +1,35 @@
+package gol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	for text, expected := range map[string]Rule{
+		"B3/S23":  Conway,
+		"b36/s23": {Birth: []int{3, 6}, Survival: []int{2, 3}},
+		"B2/S":    {Birth: []int{2}},
+		" B/S0 ":  {Survival: []int{0}},
+	} {
+		rule, err := ParseRule(text)
+		if err != nil {
+			t.Errorf("%q: %v", text, err)
+			continue
+		}
+		if !reflect.DeepEqual(rule, expected) {
+			t.Errorf("%q: expected %v, got %v", text, expected, rule)
+		}
+	}
+	if rule := (Rule{Birth: []int{3, 6}, Survival: []int{2, 3}}).String(); rule != "B36/S23" {
+		t.Errorf("expected HighLife written as B36/S23, got %v", rule)
+	}
+}
+
+func TestParseRuleMalformed(t *testing.T) {
+	for _, text := range []string{"", "B3", "S23/B3", "B3/S2/3", "B9/S23", "B3/S2a", "B33/S23", "B/S", "23/3"} {
+		if _, err := ParseRule(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
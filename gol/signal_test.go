@@ -0,0 +1,75 @@
+//go:build !windows
+// +build !windows
+
+package gol
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// snapshotBroker is a fakeBroker that also answers snapshots, at a fixed turn.
+type snapshotBroker struct {
+	fakeBroker
+	turns int64
+}
+
+func (b *snapshotBroker) PauseAndSnapshot(req BrokerPauseAndSnapshotRequest, res *BrokerPauseAndSnapshotResponse) (err error) {
+	res.Turns = b.turns
+	res.World = newWorld(16, 16)
+	return
+}
+
+// TestSaveOnSignal sends the process SIGUSR1 twice during a run and checks each saves the
+// board without stopping the run.
+func TestSaveOnSignal(t *testing.T) {
+	broker := &snapshotBroker{fakeBroker: fakeBroker{quit: make(chan bool)}, turns: 9}
+	p := Params{Turns: 100, ImageWidth: 16, ImageHeight: 16, SaveOnSignal: true}
+	p.BrokerAddr = startFakeBroker(t, broker)
+
+	events := make(chan Event, 1000)
+	keyPresses := make(chan rune)
+	c := startTestIo(p, seed(16, 16, checkerboard))
+	c.events = events
+	c.keyPresses = keyPresses
+	go distributor(p, c)
+
+	// Without a handler of its own installed yet, SIGUSR1 would kill the test binary.
+	installed := make(chan os.Signal, 1)
+	signal.Notify(installed, syscall.SIGUSR1)
+	defer signal.Stop(installed)
+
+	saves := 0
+	for saves < 2 {
+		if saves == 0 {
+			// Keep signalling until the distributor's handler is installed.
+			syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+		}
+		select {
+		case event := <-events:
+			if e, ok := event.(ImageOutputComplete); ok {
+				if e.Filename != "16x16x9" {
+					t.Fatalf("expected the board to be saved as 16x16x9, got %v", e.Filename)
+				}
+				saves++
+				if saves == 1 {
+					syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+				}
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// The run is still going, until the consumer exits and it is quit.
+	select {
+	case <-broker.quit:
+		t.Fatal("the run was stopped by a save")
+	default:
+	}
+	close(keyPresses)
+	for range events {
+	}
+}
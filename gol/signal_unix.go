@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package gol
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySave relays SIGUSR1 to signals, to save the board on demand.
+func notifySave(signals chan<- os.Signal) {
+	signal.Notify(signals, syscall.SIGUSR1)
+}
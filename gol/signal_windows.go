@@ -0,0 +1,6 @@
+package gol
+
+import "os"
+
+// notifySave does nothing, as Windows has no SIGUSR1 to save the board on.
+func notifySave(signals chan<- os.Signal) {}
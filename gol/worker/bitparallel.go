@@ -0,0 +1,118 @@
+package main
+
+// Engines a worker can compute regions with.
+const (
+	EngineNaive       = "naive"
+	EngineBitParallel = "bitparallel"
+)
+
+// engines computes the next state of a region with each engine, in place.
+var engines = map[string]func(region *Region){
+	EngineNaive:       (*Region).update,
+	EngineBitParallel: (*Region).updateBitParallel,
+}
+
+// pack stores the alive cells of row as bits, 64 cells to a word, with the unused high bits
+// of the last word left clear.
+func pack(row []Cell) []uint64 {
+	words := make([]uint64, (len(row)+63)/64)
+	for x, cell := range row {
+		if cell.Alive {
+			words[x/64] |= 1 << uint(x%64)
+		}
+	}
+	return words
+}
+
+// west returns row with every cell moved one column up, wrapping around unless fixed, so that
+// each cell lines up with its western neighbour.
+func west(row []uint64, width int, fixed bool) []uint64 {
+	shifted := make([]uint64, len(row))
+	carry := row[(width-1)/64] >> uint((width-1)%64) & 1
+	if fixed {
+		carry = 0
+	}
+	for i, word := range row {
+		shifted[i] = word<<1 | carry
+		carry = word >> 63
+	}
+	if width%64 != 0 {
+		shifted[len(shifted)-1] &= 1<<uint(width%64) - 1
+	}
+	return shifted
+}
+
+// east returns row with every cell moved one column down, wrapping around unless fixed, so
+// that each cell lines up with its eastern neighbour.
+func east(row []uint64, width int, fixed bool) []uint64 {
+	shifted := make([]uint64, len(row))
+	for i, word := range row {
+		shifted[i] = word >> 1
+		if i+1 < len(row) {
+			shifted[i] |= row[i+1] << 63
+		}
+	}
+	if !fixed {
+		shifted[(width-1)/64] |= (row[0] & 1) << uint((width-1)%64)
+	}
+	return shifted
+}
+
+// updateBitParallel computes the same next state as update, but counts the neighbours of 64
+// cells at once by adding the packed neighbouring rows together bit by bit. Tiles, whose
+// rows do not wrap around, and rules other than Conway's are left to update.
+func (region *Region) updateBitParallel() {
+	if region.tiled() || !region.Rule.conway() {
+		region.update()
+		return
+	}
+
+	field := Field{
+		Height: region.Height,
+		Width:  region.Width,
+	}
+	field.cultivate(region.Height, region.Width)
+	if region.Width == 0 {
+		region.Field = field.Data
+		region.Height = len(region.Field)
+		return
+	}
+
+	rows := make([][]uint64, len(region.Field))
+	wests := make([][]uint64, len(region.Field))
+	easts := make([][]uint64, len(region.Field))
+	for y, row := range region.Field {
+		rows[y] = pack(row)
+		wests[y] = west(rows[y], region.Width, region.Boundary == Fixed)
+		easts[y] = east(rows[y], region.Width, region.Boundary == Fixed)
+	}
+
+	for y := DefaultHaloOffset; y < region.Height+DefaultHaloOffset; y++ {
+		neighbours := [][]uint64{
+			wests[y-1], rows[y-1], easts[y-1],
+			wests[y], easts[y],
+			wests[y+1], rows[y+1], easts[y+1],
+		}
+		for i, alive := range rows[y] {
+			// ones and twos are the low bits of each cell's count, fours is set once it reaches 4.
+			var ones, twos, fours uint64
+			for _, neighbour := range neighbours {
+				carry := ones & neighbour[i]
+				ones ^= neighbour[i]
+				fours |= twos & carry
+				twos ^= carry
+			}
+			next := twos &^ fours & (ones | alive)
+
+			for bit := 0; bit < 64 && i*64+bit < region.Width; bit++ {
+				x := i*64 + bit
+				nextCell := region.Field[y][x]
+				nextCell.Alive = next>>uint(bit)&1 == 1
+				field.Data[y-DefaultHaloOffset][x] = nextCell
+			}
+		}
+	}
+
+	region.Field = field.Data
+	region.Height = len(region.Field)
+}
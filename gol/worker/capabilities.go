@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// benchmarkDuration is roughly how long the self-benchmark behind Status's score runs.
+const benchmarkDuration = 100 * time.Millisecond
+
+// capabilities describes this worker's machine in res, for the broker to size the regions it
+// sends and its share of the board.
+func (w *WorkerService) capabilities(res *WorkerStatusResponse) {
+	w.benchmarkOnce.Do(func() { w.score = benchmark(w.engine) })
+	res.MaxRegionHeight = w.maxRegionHeight
+	res.CPUs = runtime.NumCPU()
+	res.Memory = availableMemory()
+	res.Score = w.score
+}
+
+// benchmark returns how many cells a second engine computes on a random region.
+func benchmark(engine string) float64 {
+	update, ok := engines[engine]
+	if !ok {
+		update = engines[EngineNaive]
+	}
+	rng := rand.New(rand.NewSource(1))
+	field := make([][]Cell, 128+2*DefaultHaloOffset)
+	for y := range field {
+		field[y] = make([]Cell, 512)
+		for x := range field[y] {
+			field[y][x] = Cell{X: x, Y: y, Alive: rng.Intn(4) == 0}
+		}
+	}
+	region := Region{Field: field, End: 128, Height: 128, Width: 512}
+
+	cells := 0
+	start := time.Now()
+	for time.Since(start) < benchmarkDuration {
+		r := region
+		update(&r)
+		cells += region.Height * region.Width
+	}
+	return float64(cells) / time.Since(start).Seconds()
+}
+
+// availableMemory returns the bytes of memory available for new work, or 0 where that cannot
+// be read, as on systems without /proc/meminfo.
+func availableMemory() uint64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kB, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kB * 1024
+		}
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+)
+
+// compressRegion gob encodes region and gzips it. A mostly dead region's rows compress to a
+// small fraction of their size, packed or not.
+func compressRegion(region Region) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if err := gob.NewEncoder(writer).Encode(region); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// decompressRegion returns the region compressRegion made payload from.
+func decompressRegion(payload []byte) (Region, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return Region{}, err
+	}
+	defer reader.Close()
+	var region Region
+	if err := gob.NewDecoder(reader).Decode(&region); err != nil {
+		return Region{}, err
+	}
+	return region, nil
+}
+
+// compressed returns the region with its rows compressed into Payload rather than held in
+// Field or Packed. The rest of the region is left in place, for logging.
+func (region Region) compressed() (Region, error) {
+	payload, err := compressRegion(region)
+	if err != nil {
+		return Region{}, err
+	}
+	region.Field = nil
+	region.Packed = PackedWorld{}
+	region.Compressed = true
+	region.Payload = payload
+	return region, nil
+}
+
+// decompressed returns the region held in Payload, if it is Compressed.
+func (region Region) decompressed() (Region, error) {
+	if !region.Compressed {
+		return region, nil
+	}
+	return decompressRegion(region.Payload)
+}
@@ -0,0 +1,68 @@
+package main
+
+import "fmt"
+
+// PackedWorld is a region's rows reduced to a bitmap of their alive cells, a bit per cell with
+// the cells in row order, which gob encodes at a sixteenth of the size of a Cell per cell.
+// Only whether each cell is alive survives packing.
+type PackedWorld struct {
+	Width  int
+	Height int
+	Bits   []uint64
+}
+
+// packRows packs rows, which must all be as wide as the first.
+func packRows(rows [][]Cell) PackedWorld {
+	packed := PackedWorld{Height: len(rows)}
+	if len(rows) > 0 {
+		packed.Width = len(rows[0])
+	}
+	packed.Bits = make([]uint64, (packed.Width*packed.Height+63)/64)
+	for y, row := range rows {
+		for x, cell := range row {
+			if cell.Alive {
+				i := y*packed.Width + x
+				packed.Bits[i/64] |= 1 << uint(i%64)
+			}
+		}
+	}
+	return packed
+}
+
+// rows unpacks the rows packed holds, returning an error if it has too few bits for its size.
+func (packed PackedWorld) rows() ([][]Cell, error) {
+	if packed.Width < 0 || packed.Height < 0 || len(packed.Bits) != (packed.Width*packed.Height+63)/64 {
+		return nil, fmt.Errorf("packed %vx%v rows need %v words, not %v",
+			packed.Width, packed.Height, (packed.Width*packed.Height+63)/64, len(packed.Bits))
+	}
+	rows := make([][]Cell, packed.Height)
+	for y := range rows {
+		rows[y] = make([]Cell, packed.Width)
+		for x := range rows[y] {
+			i := y*packed.Width + x
+			rows[y][x] = Cell{X: x, Y: y, Alive: packed.Bits[i/64]>>uint(i%64)&1 == 1}
+		}
+	}
+	return rows, nil
+}
+
+// packed returns the region with its rows packed in Packed rather than held in Field.
+func (region Region) packed() Region {
+	region.Packed = packRows(region.Field)
+	region.Field = nil
+	return region
+}
+
+// unpacked returns the region with the rows in Packed, if any, unpacked into Field.
+func (region Region) unpacked() (Region, error) {
+	if region.Packed.Height == 0 {
+		return region, nil
+	}
+	rows, err := region.Packed.rows()
+	if err != nil {
+		return Region{}, err
+	}
+	region.Field = rows
+	region.Packed = PackedWorld{}
+	return region, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/rpc"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+var BrokerRegister = "BrokerService.Register"
+
+// register tells the broker at brokerAddr that this worker listens on port, at the address
+// this machine reaches the broker from, and returns how many workers the broker now has. The
+// broker is dialed over TLS verified by config when it is not nil.
+func register(brokerAddr, port string, config *tls.Config) (int, error) {
+	conn, err := util.Dial(brokerAddr, config)
+	if err != nil {
+		return 0, err
+	}
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		conn.Close()
+		return 0, err
+	}
+	client := rpc.NewClient(conn)
+	defer client.Close()
+
+	response := new(BrokerRegisterResponse)
+	err = client.Call(BrokerRegister, BrokerRegisterRequest{Address: net.JoinHostPort(host, port)}, response)
+	return response.Workers, err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+var WorkerExchangeHalo = "WorkerService.ExchangeHalo"
+
+// ErrNoResidentStrip is returned for a resident request this worker does not hold the strip for.
+var ErrNoResidentStrip = errors.New("no resident strip for region")
+
+// ErrEdgesNotResident is returned for an EdgesOnly request that is not Resident, which would
+// keep no strip for the rows it leaves out.
+var ErrEdgesNotResident = errors.New("edges only without a resident strip")
+
+// residentStrip is a strip of the board a resident run keeps on this worker between turns.
+type residentStrip struct {
+	start int
+	// turn is the turn rows are at the start of.
+	turn int64
+	rows [][]Cell
+	// up and down are the workers holding the strips above and below, which start at rows
+	// upStart and downStart.
+	up, down           string
+	upStart, downStart int
+	// edges are the first and last rows at the start of each recent turn, for neighbours
+	// that have not yet moved on from it.
+	edges map[int64][2][]Cell
+}
+
+// keepResident stores the rows computed for a resident request as the run's strip at their
+// Start, keeping the edges of the turn before as well. The caller holds mu.
+func (w *WorkerService) keepResident(req WorkerProcessRequest, region Region) {
+	if w.resident == nil {
+		w.resident = make(map[runRegion]*residentStrip)
+	}
+	key := runRegion{req.Run, region.Start}
+	strip, ok := w.resident[key]
+	if !ok {
+		strip = &residentStrip{start: region.Start, edges: make(map[int64][2][]Cell)}
+		w.resident[key] = strip
+	}
+	if req.Up != "" || req.Down != "" {
+		strip.up, strip.down = req.Up, req.Down
+		strip.upStart, strip.downStart = req.UpStart, req.DownStart
+	}
+	strip.turn = req.Turn + 1
+	strip.rows = region.Field
+	strip.edges[strip.turn] = [2][]Cell{region.Field[0], region.Field[len(region.Field)-1]}
+	for turn := range strip.edges {
+		if turn < req.Turn {
+			delete(strip.edges, turn)
+		}
+	}
+}
+
+// withResidentStrip rebuilds a resident region from this worker's strip and the halo rows
+// its neighbours had at the start of the turn, which come with a HaloOnly request and are
+// fetched from the neighbours otherwise.
+func (w *WorkerService) withResidentStrip(req WorkerProcessRequest) (Region, error) {
+	w.mu.Lock()
+	strip, ok := w.resident[runRegion{req.Run, req.Region.Start}]
+	var rows [][]Cell
+	var up, down string
+	var upStart, downStart int
+	ok = ok && strip.turn == req.Turn
+	if ok {
+		rows, up, down = strip.rows, strip.up, strip.down
+		upStart, downStart = strip.upStart, strip.downStart
+	}
+	w.mu.Unlock()
+	if !ok || len(rows) != req.Region.Height {
+		return Region{}, ErrNoResidentStrip
+	}
+
+	// A HaloOnly request brings its halos. Otherwise the strip above's last row is this one's
+	// top halo, and the strip below's first its bottom. A strip at the edge of a Fixed board
+	// has no neighbour there, and a dead halo instead.
+	top, bottom := make([]Cell, req.Region.Width), make([]Cell, req.Region.Width)
+	var err error
+	if req.HaloOnly {
+		if len(req.Region.Field) != 2 {
+			return Region{}, fmt.Errorf("%v halo rows rather than 2", len(req.Region.Field))
+		}
+		top, bottom = req.Region.Field[0], req.Region.Field[1]
+	} else {
+		if up != "" {
+			if top, err = fetchHalo(up, w.tls, WorkerExchangeHaloRequest{Run: req.Run, Start: upStart, Turn: req.Turn, Bottom: true}); err != nil {
+				return Region{}, fmt.Errorf("halo from %v: %v", up, err)
+			}
+		}
+		if down != "" {
+			if bottom, err = fetchHalo(down, w.tls, WorkerExchangeHaloRequest{Run: req.Run, Start: downStart, Turn: req.Turn}); err != nil {
+				return Region{}, fmt.Errorf("halo from %v: %v", down, err)
+			}
+		}
+	}
+
+	region := req.Region
+	region.Field = make([][]Cell, 0, len(rows)+2)
+	region.Field = append(region.Field, top)
+	region.Field = append(region.Field, rows...)
+	region.Field = append(region.Field, bottom)
+	return region, nil
+}
+
+// fetchHalo asks the worker at address for the edge row of one of its strips that req names,
+// over TLS verified by config when it is not nil.
+func fetchHalo(address string, config *tls.Config, req WorkerExchangeHaloRequest) ([]Cell, error) {
+	client, err := util.DialRPC(address, config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	response := new(WorkerExchangeHaloResponse)
+	err = client.Call(WorkerExchangeHalo, req, response)
+	return response.Row, err
+}
+
+// ExchangeHalo returns the first or last row of one of this worker's resident strips at the
+// start of a turn, for the neighbouring worker to use as a halo.
+func (w *WorkerService) ExchangeHalo(req WorkerExchangeHaloRequest, res *WorkerExchangeHaloResponse) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	strip, ok := w.resident[runRegion{req.Run, req.Start}]
+	if !ok {
+		return ErrNoResidentStrip
+	}
+	edges, ok := strip.edges[req.Turn]
+	if !ok {
+		return fmt.Errorf("no halo kept for turn %v", req.Turn)
+	}
+	res.Row = edges[0]
+	if req.Bottom {
+		res.Row = edges[1]
+	}
+	return
+}
+
+// FetchStrip returns one of this worker's resident strips, for the broker to pull the board
+// back.
+func (w *WorkerService) FetchStrip(req WorkerFetchStripRequest, res *WorkerFetchStripResponse) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	strip, ok := w.resident[runRegion{req.Run, req.Start}]
+	if !ok {
+		return ErrNoResidentStrip
+	}
+	res.Turn = strip.turn
+	res.Region = Region{Field: strip.rows, Start: strip.start, End: strip.start + len(strip.rows), Height: len(strip.rows)}
+	if len(strip.rows) > 0 {
+		res.Region.Width = len(strip.rows[0])
+	}
+	if req.Pack {
+		res.Region = res.Region.packed()
+	}
+	return
+}
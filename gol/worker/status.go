@@ -0,0 +1,30 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// Version is the version Status reports, set when building with
+// -ldflags "-X main.Version=<version>".
+var Version = "dev"
+
+// Status reports whether this worker is ready for regions, for the broker to check before
+// handing it any, along with its version, how many cores compute each region and the
+// capabilities the broker sizes its regions by.
+func (w *WorkerService) Status(req WorkerStatusRequest, res *WorkerStatusResponse) (err error) {
+	res.Ready = atomic.LoadInt32(&w.ready) == 1
+	res.Version = Version
+	res.CoresAvailable = w.cores()
+	w.capabilities(res)
+	return
+}
+
+// cores returns how many goroutines compute each region, the threads setting or else one per
+// CPU.
+func (w *WorkerService) cores() int {
+	if w.threads > 0 {
+		return w.threads
+	}
+	return runtime.NumCPU()
+}
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
+	"log"
 	"net"
 	"net/rpc"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 const (
@@ -12,6 +22,16 @@ const (
 	InitialDelay      = 2 * time.Second
 )
 
+// Boundary is what lies beyond the edges of the board.
+type Boundary string
+
+const (
+	// Toroidal wraps each edge of the board round to the opposite one. It is the default.
+	Toroidal Boundary = "toroidal"
+	// Fixed surrounds the board with cells that are always dead.
+	Fixed Boundary = "fixed"
+)
+
 type (
 	Cell struct {
 		X     int
@@ -31,27 +51,200 @@ type (
 		End    int
 		Height int
 		Width  int
+
+		// StartX and EndX are the columns of a tile from a grid decomposition, whose rows
+		// then carry a halo cell at either end. Both are 0 for a strip of whole rows,
+		// which wraps around horizontally instead.
+		StartX int
+		EndX   int
+
+		// Boundary is Fixed for a strip of a board without wrap around, whose rows are
+		// then dead beyond either end.
+		Boundary Boundary
+
+		// Rule is the rule the region is computed with.
+		Rule Rule
+
+		// Packed holds the rows instead of Field when they are sent packed.
+		Packed PackedWorld
+
+		// Payload holds the rows, packed or not, gzipped when Compressed, see compressed.
+		Compressed bool
+		Payload    []byte
+
+		// threads is how many goroutines update splits the rows between, set by the worker
+		// computing the region rather than sent with it. Below 2 the rows are computed in turn.
+		threads int
+	}
+
+	// Rule is a Life-like rule, the neighbour counts on which a dead cell is born and a live
+	// one survives. The zero Rule is Conway's B3/S23.
+	Rule struct {
+		Birth    []int
+		Survival []int
 	}
 )
 
 type (
 	WorkerProcessRequest struct {
 		Region Region
+		Turn   int64
+		// HaloOnly is set when Region holds just the two halo rows, and the interior is
+		// the rows this worker returned for the same region on the previous turn.
+		HaloOnly bool
+		// Run identifies the broker run the region belongs to, so rows cached for one run
+		// are never reused in another. It is zero when the broker never sends halos only.
+		Run int64
+		// Resident keeps the computed rows on this worker for the next turn rather than
+		// returning them. The first request of a run carries the whole region and the
+		// addresses of the workers holding the strips above and below, Up and Down, with
+		// those strips' first rows, UpStart and DownStart. Later ones carry just the
+		// region's shape, and the halos come from those neighbours, or carry the halos
+		// themselves when HaloOnly is set too.
+		//
+		// EdgesOnly, which needs Resident, answers with just the strip's new first and last
+		// rows, for the broker to relay to the neighbouring strips as their next halos.
+		Resident  bool
+		EdgesOnly bool
+		Up        string
+		Down      string
+		UpStart   int
+		DownStart int
 	}
 
 	WorkerProcessResponse struct {
 		Region Region
+		// AliveCount is the alive cells in the returned region.
+		AliveCount int
 	}
 
 	WorkerShutdownRequest struct{}
 
 	WorkerShutdownResponse struct{}
 
+	WorkerStatusRequest struct{}
+
+	WorkerStatusResponse struct {
+		// Ready is set while the worker accepts regions, from when it starts listening until
+		// it is shut down.
+		Ready   bool
+		Version string
+		// CoresAvailable is how many goroutines compute each region.
+		CoresAvailable int
+		// MaxRegionHeight is the most interior rows this worker accepts in one region,
+		// or 0 when it has no limit.
+		MaxRegionHeight int
+		CPUs            int
+		// Memory is the bytes available for new work, or 0 when unknown.
+		Memory uint64
+		// Score is how many cells a second this worker's engine computes.
+		Score float64
+	}
+
+	WorkerDumpRegionRequest struct{}
+
+	WorkerDumpRegionResponse struct {
+		InputFile  string
+		OutputFile string
+	}
+
+	WorkerExchangeHaloRequest struct {
+		Run int64
+		// Start is the first row of the strip whose edge is wanted.
+		Start int
+		Turn  int64
+		// Bottom asks for the last row of the strip rather than the first.
+		Bottom bool
+	}
+
+	WorkerExchangeHaloResponse struct {
+		Row []Cell
+	}
+
+	WorkerFetchStripRequest struct {
+		Run   int64
+		Start int
+		// Pack asks for the strip's rows packed.
+		Pack bool
+	}
+
+	WorkerFetchStripResponse struct {
+		// Region is the resident strip without halos, at the start of turn Turn.
+		Region Region
+		Turn   int64
+	}
+
+	WorkerEndRunRequest struct {
+		Run int64
+	}
+
+	WorkerEndRunResponse struct{}
+
+	BrokerRegisterRequest struct {
+		// Address is the host:port the worker is listening on.
+		Address string
+	}
+
+	BrokerRegisterResponse struct {
+		// Workers is how many workers the broker knows of, including this one.
+		Workers int
+	}
+
 	WorkerService struct {
-		shutdown chan bool
+		shutdown        chan bool
+		port            string
+		maxRegionHeight int
+		engine          string
+
+		// verify recomputes every region with the naive engine and panics if the chosen
+		// engine's result differs, for catching divergences while developing engines.
+		verify bool
+
+		// threads is how many goroutines compute each region, one per CPU when 0.
+		threads int
+
+		// tls verifies the workers this one fetches halos from, which are dialed over plain
+		// TCP when it is nil.
+		tls *tls.Config
+
+		// ready is 1 from when the worker starts listening until Shutdown is called, and is
+		// read and written atomically.
+		ready int32
+
+		// score is measured by the first Status call and reused after.
+		benchmarkOnce sync.Once
+		score         float64
+
+		mu         sync.Mutex
+		lastTurn   int64
+		lastInput  Region
+		lastOutput Region
+
+		// cache holds the rows returned for each region of each run while the run may still
+		// send halo-only requests for them, so runs sharing this worker keep their own.
+		cache map[runRegion]cachedRegion
+
+		// resident holds the strips resident runs keep on this worker, guarded by mu.
+		resident map[runRegion]*residentStrip
+	}
+
+	// runRegion identifies a region of one broker run by the run and the region's first row.
+	runRegion struct {
+		run   int64
+		start int
+	}
+
+	cachedRegion struct {
+		turn int64
+		end  int
+		rows [][]Cell
 	}
 )
 
+// ErrNoCachedRegion is returned for a halo-only request this worker has no interior rows for,
+// after which the broker sends the whole region instead.
+var ErrNoCachedRegion = errors.New("no cached rows for region")
+
 func (field *Field) cultivate(height, width int) Field {
 	land := make([][]Cell, height)
 	for i := range land {
@@ -61,69 +254,426 @@ func (field *Field) cultivate(height, width int) Field {
 	return *field
 }
 
+// conway reports whether the rule is Conway's B3/S23, as the zero Rule is.
+func (rule Rule) conway() bool {
+	return rule.table() == Rule{}.table()
+}
+
+// table returns whether a cell is alive next turn for each neighbour count, indexed first by
+// whether it is alive now.
+func (rule Rule) table() (next [2][9]bool) {
+	if rule.Birth == nil && rule.Survival == nil {
+		rule = Rule{Birth: []int{3}, Survival: []int{2, 3}}
+	}
+	// The broker rejects counts past 8, which no cell can have.
+	for _, count := range rule.Birth {
+		if count >= 0 && count <= 8 {
+			next[0][count] = true
+		}
+	}
+	for _, count := range rule.Survival {
+		if count >= 0 && count <= 8 {
+			next[1][count] = true
+		}
+	}
+	return
+}
+
+// tiled reports whether the region is a tile with halo columns rather than a strip.
+func (region *Region) tiled() bool {
+	return region.EndX > region.StartX
+}
+
+// update computes the next state of the region's interior rows. On boards one or two rows
+// high the halo rows are the same board rows as each other or as the interior row, and each
+// is still counted once per neighbouring position, exactly as on any other torus. A tile's
+// neighbours across its edges and corners come from its halo cells instead of wrapping, and
+// a Fixed strip's cells past either end of a row are dead. Cells are born and survive by the
+// region's Rule. The rows are split between the region's threads, each writing its own rows
+// of the new field while only reading the old one.
 func (region *Region) update() {
 	field := Field{
 		Height: region.Height,
 		Width:  region.Width,
 	}
 	field.cultivate(region.Height, region.Width)
+	rule := region.Rule.table()
+
+	threads := region.threads
+	if threads > region.Height {
+		threads = region.Height
+	}
+	if threads <= 1 {
+		region.updateRows(field.Data, rule, 0, region.Height)
+	} else {
+		var wg sync.WaitGroup
+		// The first thread to panic has its panic raised again here, where the caller can
+		// recover it, rather than taking the whole worker down.
+		var panicked interface{}
+		var panickedOnce sync.Once
+		for i := 0; i < threads; i++ {
+			wg.Add(1)
+			go func(from, to int) {
+				defer wg.Done()
+				defer func() {
+					if r := recover(); r != nil {
+						panickedOnce.Do(func() { panicked = r })
+					}
+				}()
+				region.updateRows(field.Data, rule, from, to)
+			}(i*region.Height/threads, (i+1)*region.Height/threads)
+		}
+		wg.Wait()
+		if panicked != nil {
+			panic(panicked)
+		}
+	}
 
-	for y := DefaultHaloOffset; y < region.Height+DefaultHaloOffset; y++ {
+	region.Field = field.Data
+	// The halo rows are gone, so Height is now the row count the broker reassembles.
+	region.Height = len(region.Field)
+}
+
+// check returns an error unless the region has its Height rows with a halo row above and below
+// them, and every row is Width cells wide, or a halo cell wider at either end for a tile. The
+// engines read those rows without checking, so a region short of them would panic.
+func (region *Region) check() error {
+	if len(region.Field) != region.Height+2*DefaultHaloOffset {
+		return fmt.Errorf("region [%v, %v) has %v rows, expected %v and a halo row either side",
+			region.Start, region.End, len(region.Field), region.Height)
+	}
+	width := region.Width
+	if region.tiled() {
+		width += 2 * DefaultHaloOffset
+	}
+	for y, row := range region.Field {
+		if len(row) != width {
+			return fmt.Errorf("row %v of region [%v, %v) has %v cells, expected %v", y, region.Start, region.End, len(row), width)
+		}
+	}
+	return nil
+}
+
+// updateRows writes the next state of interior rows [from, to) into the same rows of data.
+func (region *Region) updateRows(data [][]Cell, rule [2][9]bool, from, to int) {
+	haloX := 0
+	if region.tiled() {
+		haloX = DefaultHaloOffset
+	}
+
+	for y := from + DefaultHaloOffset; y < to+DefaultHaloOffset; y++ {
 		for x := 0; x < region.Width; x++ {
-			currentCell := region.Field[y][x]
+			currentCell := region.Field[y][x+haloX]
 			nextCell := currentCell
 			aliveNeighbours := 0
 			for i := -1; i <= 1; i++ {
 				for j := -1; j <= 1; j++ {
 					wx := x + i
 					wy := y + j
-					wx += region.Width
-					wx %= region.Width
+					if region.tiled() {
+						wx += haloX
+					} else if region.Boundary == Fixed && (wx < 0 || wx >= region.Width) {
+						continue
+					} else {
+						wx += region.Width
+						wx %= region.Width
+					}
 					if (j != 0 || i != 0) && region.Field[wy][wx].Alive {
 						aliveNeighbours++
 					}
 				}
 			}
-			if (aliveNeighbours < 2) || (aliveNeighbours > 3) {
-				nextCell.Alive = false
+			if currentCell.Alive {
+				nextCell.Alive = rule[1][aliveNeighbours]
+			} else {
+				nextCell.Alive = rule[0][aliveNeighbours]
 			}
-			if aliveNeighbours == 3 {
-				nextCell.Alive = true
+			data[y-DefaultHaloOffset][x] = nextCell
+		}
+	}
+}
+
+// matrix converts rows of cells into pgm pixel values.
+func matrix(rows [][]Cell) [][]uint8 {
+	pixels := make([][]uint8, len(rows))
+	for y, row := range rows {
+		pixels[y] = make([]uint8, len(row))
+		for x, cell := range row {
+			if cell.Alive {
+				pixels[y][x] = 255
 			}
-			field.Data[y-DefaultHaloOffset][x] = nextCell
 		}
 	}
+	return pixels
+}
 
-	region.Field = field.Data
+func dumpRows(filename string, rows [][]Cell) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return util.WritePgm(file, matrix(rows))
 }
 
+// withCachedInterior rebuilds a halo-only region around the rows cached for it last turn.
+func (w *WorkerService) withCachedInterior(req WorkerProcessRequest) (Region, error) {
+	region := req.Region
+
+	w.mu.Lock()
+	cached, ok := w.cache[runRegion{req.Run, region.Start}]
+	w.mu.Unlock()
+	if !ok || cached.turn != req.Turn-1 || cached.end != region.End || len(region.Field) != 2 {
+		return Region{}, ErrNoCachedRegion
+	}
+
+	field := make([][]Cell, 0, len(cached.rows)+2)
+	field = append(field, region.Field[0])
+	field = append(field, cached.rows...)
+	field = append(field, region.Field[1])
+	region.Field = field
+	return region, nil
+}
+
+// cacheRows keeps the rows computed for a run's region and drops the run's rows too old to be
+// reused. Other runs' rows are kept until EndRun. The caller holds mu.
+func (w *WorkerService) cacheRows(req WorkerProcessRequest, rows [][]Cell) {
+	if w.cache == nil {
+		w.cache = make(map[runRegion]cachedRegion)
+	}
+	for key, cached := range w.cache {
+		if key.run == req.Run && cached.turn < req.Turn-1 {
+			delete(w.cache, key)
+		}
+	}
+	w.cache[runRegion{req.Run, req.Region.Start}] = cachedRegion{turn: req.Turn, end: req.Region.End, rows: rows}
+}
+
+// EndRun drops the rows and strips kept for a broker run, once the run has finished with them.
+func (w *WorkerService) EndRun(req WorkerEndRunRequest, res *WorkerEndRunResponse) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key := range w.cache {
+		if key.run == req.Run {
+			delete(w.cache, key)
+		}
+	}
+	for key := range w.resident {
+		if key.run == req.Run {
+			delete(w.resident, key)
+		}
+	}
+	return
+}
+
+// Process computes the next turn of req's region. A panic computing it, such as from a region
+// with fewer rows than its Height, is returned as an error with its stack, so the broker can
+// fail the region over instead of the worker dying. Only a divergence found by verify is left
+// to panic.
 func (w *WorkerService) Process(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if d, ok := r.(divergence); ok {
+			panic(d)
+		}
+		*res = WorkerProcessResponse{}
+		err = fmt.Errorf("region [%v, %v) on turn %v: panic: %v\n%s", req.Region.Start, req.Region.End, req.Turn, r, debug.Stack())
+	}()
+	// A compressed or packed region is answered in kind.
+	compressed := req.Region.Compressed
+	if req.Region, err = req.Region.decompressed(); err != nil {
+		return
+	}
+	packed := req.Region.Packed.Height > 0
+	if req.Region, err = req.Region.unpacked(); err != nil {
+		return
+	}
 	region := req.Region
+	switch {
+	case req.EdgesOnly && !req.Resident:
+		return ErrEdgesNotResident
+	case req.HaloOnly && !req.Resident:
+		if region, err = w.withCachedInterior(req); err != nil {
+			return
+		}
+	case req.Resident && region.Field == nil, req.Resident && req.HaloOnly:
+		if region, err = w.withResidentStrip(req); err != nil {
+			return
+		}
+	}
+	input := region
+	if w.maxRegionHeight > 0 && region.Height > w.maxRegionHeight {
+		return fmt.Errorf("region of %v rows exceeds this worker's limit of %v", region.Height, w.maxRegionHeight)
+	}
+	if err = region.check(); err != nil {
+		return
+	}
 
-	region.update()
+	update, ok := engines[w.engine]
+	if !ok {
+		update = engines[EngineNaive]
+	}
+	region.threads = w.cores()
+	update(&region)
+	if w.verify {
+		verify(input, region, req.Turn)
+	}
 	res.Region = region
+	for _, row := range region.Field {
+		for _, cell := range row {
+			if cell.Alive {
+				res.AliveCount++
+			}
+		}
+	}
+
+	w.mu.Lock()
+	w.lastTurn = req.Turn
+	w.lastInput = input
+	w.lastOutput = region
+	if req.Resident {
+		w.keepResident(req, region)
+		res.Region.Field = nil
+		if req.EdgesOnly {
+			res.Region.Field = [][]Cell{region.Field[0], region.Field[len(region.Field)-1]}
+		}
+	} else if req.Run != 0 {
+		w.cacheRows(req, region.Field)
+	}
+	w.mu.Unlock()
+	if packed && res.Region.Field != nil {
+		res.Region = res.Region.packed()
+	}
+	if compressed && (res.Region.Field != nil || res.Region.Packed.Height > 0) {
+		res.Region, err = res.Region.compressed()
+	}
 	return
 }
 
-func (w *WorkerService) Shutdown(req WorkerProcessRequest, res *WorkerProcessResponse) (err error) {
+// divergence is what verify panics with, which Process leaves to crash the worker rather than
+// returning it as an error.
+type divergence string
+
+// verify checks output is what the naive engine computes from input, panicking with the
+// first diverging cell otherwise.
+func verify(input, output Region, turn int64) {
+	expected := input
+	expected.update()
+	if len(output.Field) != len(expected.Field) {
+		panic(divergence(fmt.Sprintf("turn %v: region [%v, %v) has %v rows, the naive engine gives %v",
+			turn, input.Start, input.End, len(output.Field), len(expected.Field))))
+	}
+	for y := range expected.Field {
+		for x, cell := range expected.Field[y] {
+			if output.Field[y][x].Alive != cell.Alive {
+				panic(divergence(fmt.Sprintf("turn %v: cell (%v, %v) of region [%v, %v) is alive=%v, the naive engine gives alive=%v",
+					turn, x, input.Start+y, input.Start, input.End, output.Field[y][x].Alive, cell.Alive)))
+			}
+		}
+	}
+}
+
+// DumpRegion writes the last region this worker received, halos included, and the region
+// it returned to pgm files in the out directory, for inspecting a suspect worker.
+func (w *WorkerService) DumpRegion(req WorkerDumpRegionRequest, res *WorkerDumpRegionResponse) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_ = os.Mkdir("out", os.ModePerm)
+	prefix := fmt.Sprintf("out/worker-%v-turn-%v", w.port, w.lastTurn)
+	res.InputFile = prefix + "-input.pgm"
+	res.OutputFile = prefix + "-output.pgm"
+
+	if err = dumpRows(res.InputFile, w.lastInput.Field); err != nil {
+		return
+	}
+	return dumpRows(res.OutputFile, w.lastOutput.Field)
+}
+
+func (w *WorkerService) Shutdown(req WorkerShutdownRequest, res *WorkerShutdownResponse) (err error) {
+	atomic.StoreInt32(&w.ready, 0)
 	w.shutdown <- true
 	return nil
 }
 
+// listen serves w's RPCs on listener, returning a channel closed once Shutdown has been called
+// and the listener closed.
+func (w *WorkerService) listen(listener net.Listener) (<-chan struct{}, error) {
+	server := rpc.NewServer()
+	if err := server.Register(w); err != nil {
+		return nil, err
+	}
+	go server.Accept(listener)
+	atomic.StoreInt32(&w.ready, 1)
+
+	closed := make(chan struct{})
+	go func() {
+		<-w.shutdown
+		listener.Close()
+		close(closed)
+	}()
+	return closed, nil
+}
+
 func main() {
 	pAddr := flag.String("port", "8030", "Port to listen on")
+	maxHeight := flag.Int("max-height", 0, "Most rows accepted in one region, 0 for no limit")
+	engine := flag.String("engine", EngineNaive, "How regions are computed: naive or bitparallel")
+	broker := flag.String("broker", "", "Address of a broker to register with once listening, so it hands this worker regions")
+	verify := flag.Bool("verify", false, "Check every region against the naive engine, panicking on the first difference")
+	tlsCert := flag.String("tls-cert", "", "Certificate to serve RPCs over TLS with, plain TCP when not given")
+	tlsKey := flag.String("tls-key", "", "Key of the -tls-cert certificate")
+	tlsCA := flag.String("tls-ca", "", "CA certificate the broker and other workers must present certificates signed by, to dial them over TLS")
+	threads := flag.Int("threads", 0, "Goroutines computing each region, 0 for one per CPU")
+	config := flag.String("config", "", "JSON file of flag values, overridden by flags given on the command line")
 	flag.Parse()
 
+	if *config != "" {
+		if err := util.LoadConfig(flag.CommandLine, *config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, ok := engines[*engine]; !ok {
+		log.Fatalf("unknown engine %q", *engine)
+	}
+	serverTLS, err := util.ServerTLS(*tlsCert, *tlsKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientTLS, err := util.ClientTLS(*tlsCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	w := &WorkerService{
-		shutdown: make(chan bool),
+		shutdown:        make(chan bool),
+		port:            *pAddr,
+		maxRegionHeight: *maxHeight,
+		engine:          *engine,
+		verify:          *verify,
+		threads:         *threads,
+		tls:             clientTLS,
 	}
 
-	rpc.Register(w)
-	listener, _ := net.Listen("tcp", ":"+*pAddr)
-	defer listener.Close()
-	go rpc.Accept(listener)
+	listener, err := util.Listen(":"+*pAddr, serverTLS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	closed, err := w.listen(listener)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	<-w.shutdown
+	if *broker != "" {
+		workers, err := register(*broker, *pAddr, clientTLS)
+		if err != nil {
+			log.Fatalf("registering with broker %v: %v", *broker, err)
+		}
+		log.Printf("registered with broker %v, which has %v workers", *broker, workers)
+	}
 
-	listener.Close()
+	<-closed
 }
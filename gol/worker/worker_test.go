@@ -0,0 +1,1018 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// newRegion builds a region of the given interior height with halo rows above and below,
+// where the cells for which alive returns true are set. Rows are numbered including the halo.
+func newRegion(height, width int, alive func(x, y int) bool) Region {
+	field := make([][]Cell, height+2*DefaultHaloOffset)
+	for y := range field {
+		field[y] = make([]Cell, width)
+		for x := range field[y] {
+			field[y][x] = Cell{X: x, Y: y, Alive: alive(x, y)}
+		}
+	}
+	return Region{Field: field, Start: 0, End: height, Height: height, Width: width}
+}
+
+// inTempDir runs the rest of the test from an empty directory.
+func inTempDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func readPgmFile(t *testing.T, filename string) [][]uint8 {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	image, err := util.ReadPgm(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+func TestDumpRegion(t *testing.T) {
+	inTempDir(t)
+
+	const height, width = 3, 7
+	w := &WorkerService{port: "8031"}
+	region := newRegion(height, width, func(x, y int) bool { return x == 2 })
+
+	if err := w.Process(WorkerProcessRequest{Region: region, Turn: 12}, new(WorkerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	res := new(WorkerDumpRegionResponse)
+	if err := w.DumpRegion(WorkerDumpRegionRequest{}, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.InputFile != "out/worker-8031-turn-12-input.pgm" {
+		t.Errorf("unexpected input filename %v", res.InputFile)
+	}
+
+	input := readPgmFile(t, res.InputFile)
+	if len(input) != height+2*DefaultHaloOffset || len(input[0]) != width {
+		t.Errorf("expected a %dx%d input dump, got %dx%d", width, height+2, len(input[0]), len(input))
+	}
+
+	output := readPgmFile(t, res.OutputFile)
+	if len(output) != height || len(output[0]) != width {
+		t.Errorf("expected a %dx%d output dump, got %dx%d", width, height, len(output[0]), len(output))
+	}
+	// A vertical line of three becomes a horizontal one, so the dump must show it.
+	if output[1][1] != 255 || output[1][2] != 255 || output[1][3] != 255 || output[1][0] != 0 {
+		t.Errorf("output dump does not match the computed region: %v", output[1])
+	}
+}
+
+// TestHaloOnly checks a halo-only request for the next turn gives the same rows as sending the
+// whole region, even with another run's region at the same rows computed meanwhile, and that
+// one the worker cannot serve from its cache is refused.
+func TestHaloOnly(t *testing.T) {
+	const height, width = 6, 8
+	rng := rand.New(rand.NewSource(2))
+	region := newRegion(height, width, func(x, y int) bool { return rng.Intn(3) == 0 })
+	region.Start, region.End = 16, 16+height
+
+	w := &WorkerService{}
+	first := new(WorkerProcessResponse)
+	if err := w.Process(WorkerProcessRequest{Region: region, Turn: 4, Run: 42}, first); err != nil {
+		t.Fatal(err)
+	}
+	other := newRegion(height, width, func(x, y int) bool { return true })
+	other.Start, other.End = region.Start, region.End
+	if err := w.Process(WorkerProcessRequest{Region: other, Turn: 9, Run: 43}, new(WorkerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	// New halos from the neighbouring regions, around the interior the worker returned.
+	top := []Cell{{Alive: true}, {Alive: true}, {Alive: true}, {}, {}, {}, {}, {}}
+	bottom := make([]Cell, width)
+	whole := region
+	whole.Field = append(append([][]Cell{top}, first.Region.Field...), bottom)
+	halos := region
+	halos.Field = [][]Cell{top, bottom}
+
+	expected := new(WorkerProcessResponse)
+	if err := new(WorkerService).Process(WorkerProcessRequest{Region: whole, Turn: 5}, expected); err != nil {
+		t.Fatal(err)
+	}
+	given := new(WorkerProcessResponse)
+	if err := w.Process(WorkerProcessRequest{Region: halos, Turn: 5, HaloOnly: true, Run: 42}, given); err != nil {
+		t.Fatal(err)
+	}
+	if len(given.Region.Field) != height {
+		t.Fatalf("expected %d rows, got %d", height, len(given.Region.Field))
+	}
+	for y := range expected.Region.Field {
+		for x := range expected.Region.Field[y] {
+			if given.Region.Field[y][x].Alive != expected.Region.Field[y][x].Alive {
+				t.Fatalf("cell (%d, %d) differs from sending the whole region", x, y)
+			}
+		}
+	}
+
+	for _, req := range []WorkerProcessRequest{
+		{Region: halos, Turn: 6, HaloOnly: true, Run: 7},
+		{Region: halos, Turn: 8, HaloOnly: true, Run: 42},
+	} {
+		if err := w.Process(req, new(WorkerProcessResponse)); err != ErrNoCachedRegion {
+			t.Errorf("run %d turn %d: expected ErrNoCachedRegion, got %v", req.Run, req.Turn, err)
+		}
+	}
+
+	if err := w.EndRun(WorkerEndRunRequest{Run: 43}, new(WorkerEndRunResponse)); err != nil {
+		t.Fatal(err)
+	}
+	ended := WorkerProcessRequest{Region: halos, Turn: 10, HaloOnly: true, Run: 43}
+	if err := w.Process(ended, new(WorkerProcessResponse)); err != ErrNoCachedRegion {
+		t.Errorf("expected ErrNoCachedRegion once the run ended, got %v", err)
+	}
+}
+
+// TestHaloOnlyRun computes 100 turns of a board in three resident strips on one worker, sending
+// only their halo rows after the first turn and getting back only their edge rows, from which
+// the next turn's halos are built, and checks every turn matches sending the strips whole. Half
+// way through the strips are sent whole from another board, as after a Quit, which must replace
+// the strips kept. The strips are fetched at the end to compare the board.
+func TestHaloOnlyRun(t *testing.T) {
+	const height, width, turns, run = 24, 16, 100, 3
+	rng := rand.New(rand.NewSource(7))
+	randomBoard := func() [][]bool {
+		board := make([][]bool, height)
+		for y := range board {
+			board[y] = make([]bool, width)
+			for x := range board[y] {
+				board[y][x] = rng.Intn(3) == 0
+			}
+		}
+		return board
+	}
+	strips := [][2]int{{0, 8}, {8, 15}, {15, height}}
+	// edges are each strip's first and last rows as the resident worker returned them.
+	edges := make([][2][]Cell, len(strips))
+
+	resident, whole := new(WorkerService), new(WorkerService)
+	board := randomBoard()
+	for turn := int64(0); turn < turns; turn++ {
+		seed := turn == 0 || turn == turns/2
+		if turn == turns/2 {
+			board = randomBoard()
+		}
+		next := make([][]bool, 0, height)
+		nextEdges := make([][2][]Cell, len(strips))
+		for i, strip := range strips {
+			start, end := strip[0], strip[1]
+			region := newRegion(end-start, width, func(x, y int) bool {
+				return board[(start+y-DefaultHaloOffset+height)%height][x]
+			})
+			region.Start, region.End = start, end
+
+			req := WorkerProcessRequest{Region: region, Turn: turn, Run: run, Resident: true, EdgesOnly: true}
+			if !seed {
+				above, below := edges[(i-1+len(strips))%len(strips)], edges[(i+1)%len(strips)]
+				req.Region.Field = [][]Cell{above[1], below[0]}
+				req.HaloOnly = true
+			}
+			given, expected := new(WorkerProcessResponse), new(WorkerProcessResponse)
+			if err := resident.Process(req, given); err != nil {
+				t.Fatalf("turn %d, rows [%d, %d): %v", turn, start, end, err)
+			}
+			if err := whole.Process(WorkerProcessRequest{Region: region, Turn: turn}, expected); err != nil {
+				t.Fatal(err)
+			}
+			if given.AliveCount != expected.AliveCount {
+				t.Fatalf("turn %d, rows [%d, %d): expected %d alive cells, got %d", turn, start, end, expected.AliveCount, given.AliveCount)
+			}
+
+			rows := expected.Region.Field
+			if len(given.Region.Field) != 2 {
+				t.Fatalf("turn %d, rows [%d, %d): expected just the 2 edge rows, got %d", turn, start, end, len(given.Region.Field))
+			}
+			for y, row := range [][]Cell{rows[0], rows[len(rows)-1]} {
+				for x, cell := range row {
+					if given.Region.Field[y][x].Alive != cell.Alive {
+						t.Fatalf("turn %d: edge %d of rows [%d, %d), cell %d differs from sending the strip whole", turn, y, start, end, x)
+					}
+				}
+			}
+			nextEdges[i] = [2][]Cell{given.Region.Field[0], given.Region.Field[1]}
+			for y, row := range rows {
+				next = append(next, make([]bool, width))
+				for x, cell := range row {
+					next[start+y][x] = cell.Alive
+				}
+			}
+		}
+		board, edges = next, nextEdges
+	}
+
+	for _, strip := range strips {
+		res := new(WorkerFetchStripResponse)
+		if err := resident.FetchStrip(WorkerFetchStripRequest{Run: run, Start: strip[0]}, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Turn != turns || res.Region.Height != strip[1]-strip[0] {
+			t.Fatalf("rows [%d, %d): expected them at turn %d, got %d rows at turn %d", strip[0], strip[1], turns, res.Region.Height, res.Turn)
+		}
+		for y, row := range res.Region.Field {
+			for x, cell := range row {
+				if cell.Alive != board[strip[0]+y][x] {
+					t.Fatalf("row %d, cell %d differs from sending the strips whole", strip[0]+y, x)
+				}
+			}
+		}
+	}
+}
+
+// TestEdgesOnlyModes checks EdgesOnly is refused without Resident, where no strip would keep
+// the rows it leaves out, and that a halo-only region cached for a run and a resident strip of
+// the same run and rows are kept apart, so neither scheme reads the other's rows.
+func TestEdgesOnlyModes(t *testing.T) {
+	w := new(WorkerService)
+	region := newRegion(4, 8, func(x, y int) bool { return x == 3 && y >= 1 && y <= 3 })
+	if err := w.Process(WorkerProcessRequest{Region: region, Run: 5, EdgesOnly: true}, new(WorkerProcessResponse)); err != ErrEdgesNotResident {
+		t.Errorf("expected ErrEdgesNotResident, got %v", err)
+	}
+	if err := w.Process(WorkerProcessRequest{Region: region, Run: 5, EdgesOnly: true, HaloOnly: true}, new(WorkerProcessResponse)); err != ErrEdgesNotResident {
+		t.Errorf("expected ErrEdgesNotResident with HaloOnly, got %v", err)
+	}
+
+	// The cached region holds a blinker and the resident strip nothing, so either scheme
+	// reading the other's rows shows up in the cells it computes.
+	empty := newRegion(4, 8, func(x, y int) bool { return false })
+	halos := Region{Field: [][]Cell{make([]Cell, 8), make([]Cell, 8)}, End: 4, Height: 4, Width: 8}
+	if err := w.Process(WorkerProcessRequest{Region: region, Run: 5}, new(WorkerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Process(WorkerProcessRequest{Region: empty, Run: 5, Resident: true, EdgesOnly: true}, new(WorkerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Process(WorkerProcessRequest{Region: halos, Turn: 1, Run: 5, Resident: true, HaloOnly: true, EdgesOnly: true}, new(WorkerProcessResponse)); err != nil {
+		t.Fatal(err)
+	}
+	cached := new(WorkerProcessResponse)
+	if err := w.Process(WorkerProcessRequest{Region: halos, Turn: 1, Run: 5, HaloOnly: true}, cached); err != nil {
+		t.Fatal(err)
+	}
+	strip := new(WorkerFetchStripResponse)
+	if err := w.FetchStrip(WorkerFetchStripRequest{Run: 5}, strip); err != nil {
+		t.Fatal(err)
+	}
+	if cached.AliveCount != 3 || len(cached.Region.Field) != 4 || !cached.Region.Field[0][3].Alive {
+		t.Errorf("expected the cached blinker back in its column, got %d alive cells", cached.AliveCount)
+	}
+	alive := 0
+	for _, row := range strip.Region.Field {
+		for _, cell := range row {
+			if cell.Alive {
+				alive++
+			}
+		}
+	}
+	if strip.Turn != 2 || alive != 0 {
+		t.Errorf("expected the empty resident strip at turn 2, got %d alive cells at turn %d", alive, strip.Turn)
+	}
+}
+
+// TestUpdateHeight checks an updated region's Height is the number of rows it holds.
+func TestUpdateHeight(t *testing.T) {
+	for _, height := range []int{0, 1, 2, 7} {
+		region := newRegion(height, 4, checker)
+		region.update()
+		if len(region.Field) != region.Height || region.Height != height {
+			t.Errorf("%d row region: updated to %d rows with a height of %d", height, len(region.Field), region.Height)
+		}
+	}
+}
+
+func checker(x, y int) bool { return (x+y)%2 == 0 }
+
+// bruteForce computes the next state of a whole toroidal board directly, without regions.
+func bruteForce(board [][]bool) [][]bool {
+	height, width := len(board), len(board[0])
+	next := make([][]bool, height)
+	for y := range next {
+		next[y] = make([]bool, width)
+		for x := range next[y] {
+			neighbours := 0
+			for j := -1; j <= 1; j++ {
+				for i := -1; i <= 1; i++ {
+					if (i != 0 || j != 0) && board[(y+j+height)%height][(x+i+width)%width] {
+						neighbours++
+					}
+				}
+			}
+			next[y][x] = neighbours == 3 || (board[y][x] && neighbours == 2)
+		}
+	}
+	return next
+}
+
+// TestTinyBoards gives every row of 1x1 to 3x3 boards to its own worker, so the halo rows
+// alias the interior row or each other, and compares every seed with the brute-force result.
+func TestTinyBoards(t *testing.T) {
+	for size := 1; size <= 3; size++ {
+		for bits := 0; bits < 1<<uint(size*size); bits++ {
+			board := make([][]bool, size)
+			for y := range board {
+				board[y] = make([]bool, size)
+				for x := range board[y] {
+					board[y][x] = bits&(1<<uint(y*size+x)) != 0
+				}
+			}
+			expected := bruteForce(board)
+
+			for y := 0; y < size; y++ {
+				// The region rows are the board rows above, at and below y, wrapping around.
+				region := newRegion(1, size, func(x, row int) bool {
+					return board[(y+row-DefaultHaloOffset+size)%size][x]
+				})
+				region.update()
+				for x := 0; x < size; x++ {
+					if region.Field[0][x].Alive != expected[y][x] {
+						t.Fatalf("%dx%d board %b: cell (%d, %d) expected alive=%v", size, size, bits, x, y, expected[y][x])
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestTiledUpdate cuts random boards into tiles with a ring of halo cells, wrapping at the
+// board's edges, and compares each updated tile with the brute-force result, corners included.
+func TestTiledUpdate(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	const height, width = 9, 10
+	for iteration := 0; iteration < 20; iteration++ {
+		board := make([][]bool, height)
+		for y := range board {
+			board[y] = make([]bool, width)
+			for x := range board[y] {
+				board[y][x] = rng.Intn(3) == 0
+			}
+		}
+		expected := bruteForce(board)
+
+		for _, tile := range [][4]int{{0, 3, 0, 4}, {3, 9, 4, 10}, {6, 9, 0, 10}, {0, 1, 9, 10}} {
+			start, end, startX, endX := tile[0], tile[1], tile[2], tile[3]
+			region := Region{Start: start, End: end, Height: end - start, Width: endX - startX, StartX: startX, EndX: endX}
+			for y := start - 1; y <= end; y++ {
+				var row []Cell
+				for x := startX - 1; x <= endX; x++ {
+					row = append(row, Cell{Alive: board[(y+height)%height][(x+width)%width]})
+				}
+				region.Field = append(region.Field, row)
+			}
+			region.update()
+			for y := range region.Field {
+				for x := range region.Field[y] {
+					if region.Field[y][x].Alive != expected[start+y][startX+x] {
+						t.Fatalf("tile %v: cell (%d, %d) expected alive=%v", tile, startX+x, start+y, expected[start+y][startX+x])
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestBitParallelMatchesNaive compares the two engines on random regions, with widths either
+// side of the word size so the wrap columns land in every position of a word.
+func TestBitParallelMatchesNaive(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, width := range []int{1, 2, 3, 31, 63, 64, 65, 127, 128, 129, 200} {
+		for iteration := 0; iteration < 50; iteration++ {
+			height := rng.Intn(6)
+			percent := rng.Intn(101)
+			naive := newRegion(height, width, func(x, y int) bool { return rng.Intn(100) < percent })
+			bitParallel := naive
+
+			naive.update()
+			bitParallel.updateBitParallel()
+
+			if bitParallel.Height != naive.Height || len(bitParallel.Field) != len(naive.Field) {
+				t.Fatalf("%dx%d: expected %d rows, got %d", width, height, len(naive.Field), len(bitParallel.Field))
+			}
+			for y := range naive.Field {
+				for x := range naive.Field[y] {
+					if bitParallel.Field[y][x] != naive.Field[y][x] {
+						t.Fatalf("%dx%d at %d%%: cell (%d, %d) is %v, expected %v",
+							width, height, percent, x, y, bitParallel.Field[y][x], naive.Field[y][x])
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestFixedBoundary checks both engines compute a Fixed strip as a wrapping strip padded with a
+// dead column either side, so nothing reaches across from the other end of a row.
+func TestFixedBoundary(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	for _, width := range []int{1, 2, 3, 63, 64, 65, 130} {
+		for iteration := 0; iteration < 20; iteration++ {
+			height := 1 + rng.Intn(5)
+			fixed := newRegion(height, width, func(x, y int) bool { return rng.Intn(2) == 0 })
+			fixed.Boundary = Fixed
+			padded := newRegion(height, width+2, func(x, y int) bool {
+				return x > 0 && x <= width && fixed.Field[y][x-1].Alive
+			})
+			padded.update()
+
+			for name, update := range engines {
+				given := fixed
+				update(&given)
+				for y := range given.Field {
+					for x := range given.Field[y] {
+						if given.Field[y][x].Alive != padded.Field[y][x+1].Alive {
+							t.Fatalf("%v, %dx%d: cell (%d, %d) expected alive=%v", name, width, height, x, y, padded.Field[y][x+1].Alive)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestHighLifeReplicator evolves HighLife's replicator, which after 12 turns of B36/S23 has
+// become two copies of itself offset diagonally either side, with both engines.
+func TestHighLifeReplicator(t *testing.T) {
+	const size = 24
+	replicator := []string{"..###", ".#..#", "#...#", "#..#.", "###.."}
+	place := func(board [][]bool, offset int) {
+		for y, row := range replicator {
+			for x, c := range row {
+				if c == '#' {
+					board[offset+y][offset+x] = true
+				}
+			}
+		}
+	}
+	newBoard := func() [][]bool {
+		board := make([][]bool, size)
+		for y := range board {
+			board[y] = make([]bool, size)
+		}
+		return board
+	}
+	expected := newBoard()
+	place(expected, 8)
+	place(expected, 12)
+
+	for name, update := range engines {
+		board := newBoard()
+		place(board, 10)
+		for turn := 0; turn < 12; turn++ {
+			region := newRegion(size, size, func(x, y int) bool { return board[(y-DefaultHaloOffset+size)%size][x] })
+			region.Rule = Rule{Birth: []int{3, 6}, Survival: []int{2, 3}}
+			update(&region)
+			for y, row := range region.Field {
+				for x, cell := range row {
+					board[y][x] = cell.Alive
+				}
+			}
+		}
+		for y := range expected {
+			for x := range expected[y] {
+				if board[y][x] != expected[y][x] {
+					t.Fatalf("%v: cell (%d, %d) expected alive=%v", name, x, y, expected[y][x])
+				}
+			}
+		}
+	}
+}
+
+// TestThreadedUpdate checks splitting the rows between any number of threads, including more
+// threads than rows, computes the same region as computing them in turn.
+func TestThreadedUpdate(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+	for _, height := range []int{1, 2, 7, 64} {
+		for _, boundary := range []Boundary{Toroidal, Fixed} {
+			region := newRegion(height, 37, func(x, y int) bool { return rng.Intn(2) == 0 })
+			region.Boundary = boundary
+			expected := region
+			expected.update()
+
+			for _, threads := range []int{2, 3, 8, 100} {
+				given := region
+				given.threads = threads
+				given.update()
+				if !reflect.DeepEqual(given.Field, expected.Field) {
+					t.Fatalf("%v rows, %v, %v threads: differs from computing the rows in turn", height, boundary, threads)
+				}
+			}
+		}
+	}
+}
+
+// TestPackedRegion checks a packed region is computed as the same region sent whole, and
+// answered packed.
+func TestPackedRegion(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	region := newRegion(5, 70, func(x, y int) bool { return rng.Intn(3) == 0 })
+
+	expected := new(WorkerProcessResponse)
+	if err := new(WorkerService).Process(WorkerProcessRequest{Region: region}, expected); err != nil {
+		t.Fatal(err)
+	}
+	given := new(WorkerProcessResponse)
+	if err := new(WorkerService).Process(WorkerProcessRequest{Region: region.packed()}, given); err != nil {
+		t.Fatal(err)
+	}
+	if given.Region.Field != nil || given.AliveCount != expected.AliveCount {
+		t.Fatalf("expected the rows packed with %d alive, got %d rows with %d alive", expected.AliveCount, len(given.Region.Field), given.AliveCount)
+	}
+	rows, err := given.Region.Packed.rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := range expected.Region.Field {
+		for x := range expected.Region.Field[y] {
+			if rows[y][x].Alive != expected.Region.Field[y][x].Alive {
+				t.Fatalf("cell (%d, %d) differs from sending the region whole", x, y)
+			}
+		}
+	}
+}
+
+// TestCompressedRegion checks a compressed region, packed or not, is computed as the same
+// region sent whole, and answered compressed.
+func TestCompressedRegion(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+	region := newRegion(5, 70, func(x, y int) bool { return rng.Intn(3) == 0 })
+
+	expected := new(WorkerProcessResponse)
+	if err := new(WorkerService).Process(WorkerProcessRequest{Region: region}, expected); err != nil {
+		t.Fatal(err)
+	}
+	for _, sent := range []Region{region, region.packed()} {
+		compressed, err := sent.compressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		given := new(WorkerProcessResponse)
+		if err := new(WorkerService).Process(WorkerProcessRequest{Region: compressed}, given); err != nil {
+			t.Fatal(err)
+		}
+		if !given.Region.Compressed || given.Region.Field != nil || given.AliveCount != expected.AliveCount {
+			t.Fatalf("expected the rows compressed with %d alive, got %d rows with %d alive", expected.AliveCount, len(given.Region.Field), given.AliveCount)
+		}
+		result, err := given.Region.decompressed()
+		if err == nil {
+			result, err = result.unpacked()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := range expected.Region.Field {
+			for x := range expected.Region.Field[y] {
+				if result.Field[y][x].Alive != expected.Region.Field[y][x].Alive {
+					t.Fatalf("cell (%d, %d) differs from sending the region whole", x, y)
+				}
+			}
+		}
+	}
+}
+
+// TestVerify breaks an engine from its third region on and checks -verify catches it then,
+// naming the turn and the diverging cell.
+func TestVerify(t *testing.T) {
+	calls := 0
+	engines["broken"] = func(region *Region) {
+		region.updateBitParallel()
+		if calls++; calls >= 3 {
+			region.Field[1][2].Alive = !region.Field[1][2].Alive
+		}
+	}
+	defer delete(engines, "broken")
+
+	w := &WorkerService{engine: "broken", verify: true}
+	var recovered interface{}
+	turn := int64(1)
+	func() {
+		defer func() { recovered = recover() }()
+		for ; turn <= 5; turn++ {
+			region := newRegion(4, 8, checker)
+			region.Start, region.End = 8, 12
+			w.Process(WorkerProcessRequest{Region: region, Turn: turn}, new(WorkerProcessResponse))
+		}
+	}()
+
+	if recovered == nil {
+		t.Fatal("expected the broken engine to be caught")
+	}
+	if turn != 3 || !strings.HasPrefix(fmt.Sprint(recovered), "turn 3: cell (2, 9) of region [8, 12)") {
+		t.Errorf("expected a divergence at (2, 9) on turn 3, got %q on turn %d", recovered, turn)
+	}
+}
+
+// TestProcessPanic computes a region with an engine that drops rows from it before updating
+// it, on one thread and several, and checks the panic comes back as an error rather than rows,
+// and that the worker still computes the next region.
+func TestProcessPanic(t *testing.T) {
+	engines["truncating"] = func(region *Region) {
+		region.Field = region.Field[:2]
+		region.update()
+	}
+	defer delete(engines, "truncating")
+
+	for _, threads := range []int{1, 4} {
+		w := &WorkerService{engine: "truncating", threads: threads}
+		res := new(WorkerProcessResponse)
+		err := w.Process(WorkerProcessRequest{Region: newRegion(4, 8, checker), Turn: 1}, res)
+		if err == nil || !strings.Contains(err.Error(), "panic") {
+			t.Errorf("%d threads: expected the panic as an error, got %v", threads, err)
+		}
+		if res.Region.Field != nil {
+			t.Errorf("%d threads: expected no rows back, got %d", threads, len(res.Region.Field))
+		}
+
+		w.engine = EngineNaive
+		if err := w.Process(WorkerProcessRequest{Region: newRegion(4, 8, checker), Turn: 2}, new(WorkerProcessResponse)); err != nil {
+			t.Errorf("%d threads: expected the next region to be computed, got %v", threads, err)
+		}
+	}
+}
+
+// TestMalformedRegion checks regions missing their bottom halo, with a Height past their rows,
+// or with a short row are refused before either engine computes them.
+func TestMalformedRegion(t *testing.T) {
+	missingHalo := newRegion(4, 8, checker)
+	missingHalo.Field = missingHalo.Field[:len(missingHalo.Field)-1]
+	tooHigh := newRegion(4, 8, checker)
+	tooHigh.Height = 10
+	shortRow := newRegion(4, 8, checker)
+	shortRow.Field[2] = shortRow.Field[2][:5]
+
+	for name, region := range map[string]Region{"missing halo": missingHalo, "too high": tooHigh, "short row": shortRow} {
+		for _, engine := range []string{EngineNaive, EngineBitParallel} {
+			w := &WorkerService{engine: engine}
+			err := w.Process(WorkerProcessRequest{Region: region, Turn: 1}, new(WorkerProcessResponse))
+			if err == nil || strings.Contains(err.Error(), "panic") {
+				t.Errorf("%v, %v: expected the region to be refused, got %v", name, engine, err)
+			}
+		}
+	}
+}
+
+// BenchmarkBitParallelUpdate compares the engines on a dense 512x512 region.
+func BenchmarkBitParallelUpdate(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	region := newRegion(512, 512, func(x, y int) bool { return rng.Intn(2) == 0 })
+	for _, engine := range []string{EngineNaive, EngineBitParallel} {
+		b.Run(engine, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				r := region
+				if engine == EngineBitParallel {
+					r.updateBitParallel()
+				} else {
+					r.update()
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWorkerUpdate measures the compute kernel alone, without any RPC, over regions of
+// several shapes that are either sparse (5% alive) or dense (50% alive).
+func BenchmarkWorkerUpdate(b *testing.B) {
+	for _, density := range []struct {
+		name    string
+		percent int
+	}{{"sparse", 5}, {"dense", 50}} {
+		for _, shape := range [][2]int{{16, 512}, {128, 512}, {512, 512}, {64, 5120}} {
+			height, width := shape[0], shape[1]
+			b.Run(fmt.Sprintf("%s-%dx%d", density.name, width, height), func(b *testing.B) {
+				rng := rand.New(rand.NewSource(1))
+				region := newRegion(height, width, func(x, y int) bool { return rng.Intn(100) < density.percent })
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					r := region
+					r.update()
+				}
+				b.ReportMetric(float64(height*width)*float64(b.N)/b.Elapsed().Seconds(), "cells/sec")
+			})
+		}
+	}
+}
+
+// BenchmarkThreadedUpdate compares computing a dense 1024-row strip in one goroutine with
+// splitting it between one per CPU.
+func BenchmarkThreadedUpdate(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	region := newRegion(1024, 512, func(x, y int) bool { return rng.Intn(2) == 0 })
+	for name, threads := range map[string]int{"single": 1, "per-cpu": runtime.NumCPU()} {
+		threads := threads
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				r := region
+				r.threads = threads
+				r.update()
+			}
+		})
+	}
+}
+
+// TestCapabilities checks a worker's Status advertises its region limit, its CPUs and a
+// benchmark score, measured once.
+func TestCapabilities(t *testing.T) {
+	w := &WorkerService{engine: EngineBitParallel, maxRegionHeight: 7}
+	first := new(WorkerStatusResponse)
+	if err := w.Status(WorkerStatusRequest{}, first); err != nil {
+		t.Fatal(err)
+	}
+	if first.MaxRegionHeight != 7 || first.CPUs < 1 || first.Score <= 0 {
+		t.Errorf("expected a limit of 7, at least one CPU and a positive score, got %+v", first)
+	}
+	second := new(WorkerStatusResponse)
+	if err := w.Status(WorkerStatusRequest{}, second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Score != first.Score {
+		t.Errorf("expected the score to be measured once, got %v then %v", first.Score, second.Score)
+	}
+}
+
+// recordingBroker stands in for the broker's Register RPC, keeping the address it is sent.
+type recordingBroker struct {
+	address string
+}
+
+func (b *recordingBroker) Register(req BrokerRegisterRequest, res *BrokerRegisterResponse) (err error) {
+	b.address = req.Address
+	res.Workers = 1
+	return
+}
+
+// TestRegister checks a worker registers its port at the address it reaches the broker from.
+func TestRegister(t *testing.T) {
+	broker := new(recordingBroker)
+	server := rpc.NewServer()
+	if err := server.RegisterName("BrokerService", broker); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.Accept(listener)
+
+	workers, err := register(listener.Addr().String(), "8031", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workers != 1 || broker.address != "127.0.0.1:8031" {
+		t.Errorf("expected 127.0.0.1:8031 registered as the only worker, got %q of %v", broker.address, workers)
+	}
+}
+
+// TestShutdown starts a worker listening, calls Shutdown over RPC as the broker does, and
+// checks the listener closes.
+func TestShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &WorkerService{shutdown: make(chan bool)}
+	closed, err := w.listen(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Call("WorkerService.Shutdown", WorkerShutdownRequest{}, new(WorkerShutdownResponse)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the listener was not closed after Shutdown")
+	}
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("expected new connections to be refused after Shutdown")
+	}
+}
+
+// TestStatus checks a freshly started worker reports itself ready with the cores it computes
+// on, and no longer ready once it has been shut down.
+func TestStatus(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &WorkerService{shutdown: make(chan bool), threads: 3}
+	closed, err := w.listen(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	status := new(WorkerStatusResponse)
+	if err := client.Call("WorkerService.Status", WorkerStatusRequest{}, status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Ready || status.CoresAvailable != 3 || status.Version != Version {
+		t.Errorf("expected a ready worker of version %v on 3 cores, got %+v", Version, status)
+	}
+
+	if err := client.Call("WorkerService.Shutdown", WorkerShutdownRequest{}, new(WorkerShutdownResponse)); err != nil {
+		t.Fatal(err)
+	}
+	<-closed
+	status = new(WorkerStatusResponse)
+	if err := client.Call("WorkerService.Status", WorkerStatusRequest{}, status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Ready {
+		t.Error("expected a worker shut down to no longer be ready")
+	}
+}
+
+// residentBoard is a board split into strips kept resident on workers, strip i on worker i,
+// for checking resident runs against the brute-force result.
+type residentBoard struct {
+	run    int64
+	board  [][]bool
+	strips [][2]int
+}
+
+func newResidentBoard(run int64, height, width int, seed int64, strips [][2]int) *residentBoard {
+	rng := rand.New(rand.NewSource(seed))
+	board := make([][]bool, height)
+	for y := range board {
+		board[y] = make([]bool, width)
+		for x := range board[y] {
+			board[y][x] = rng.Intn(3) == 0
+		}
+	}
+	return &residentBoard{run: run, board: board, strips: strips}
+}
+
+// startResidentWorkers serves n workers over RPC, so they can swap halos with each other.
+func startResidentWorkers(t *testing.T, n int) ([]*WorkerService, []string) {
+	workers := make([]*WorkerService, n)
+	addresses := make([]string, n)
+	for i := range workers {
+		workers[i] = new(WorkerService)
+		server := rpc.NewServer()
+		if err := server.RegisterName("WorkerService", workers[i]); err != nil {
+			t.Fatal(err)
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		go server.Accept(listener)
+		addresses[i] = listener.Addr().String()
+	}
+	return workers, addresses
+}
+
+// load hands each worker its strip and computes the first turn.
+func (r *residentBoard) load(t *testing.T, workers []*WorkerService, addresses []string) {
+	height, width, n := len(r.board), len(r.board[0]), len(r.strips)
+	for i, strip := range r.strips {
+		start, end := strip[0], strip[1]
+		region := newRegion(end-start, width, func(x, y int) bool {
+			return r.board[(start+y-DefaultHaloOffset+height)%height][x]
+		})
+		region.Start, region.End = start, end
+		up, down := (i-1+n)%n, (i+1)%n
+		req := WorkerProcessRequest{Region: region, Run: r.run, Resident: true,
+			Up: addresses[up], Down: addresses[down], UpStart: r.strips[up][0], DownStart: r.strips[down][0]}
+		if err := workers[i].Process(req, new(WorkerProcessResponse)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.board = bruteForce(r.board)
+}
+
+// step computes turn from the resident strips, and checks their alive cells.
+func (r *residentBoard) step(t *testing.T, workers []*WorkerService, turn int64) {
+	alive := 0
+	for i, strip := range r.strips {
+		shape := Region{Start: strip[0], End: strip[1], Height: strip[1] - strip[0], Width: len(r.board[0])}
+		res := new(WorkerProcessResponse)
+		if err := workers[i].Process(WorkerProcessRequest{Region: shape, Turn: turn, Run: r.run, Resident: true}, res); err != nil {
+			t.Fatalf("run %d, turn %d, worker %d: %v", r.run, turn, i, err)
+		}
+		if res.Region.Field != nil {
+			t.Fatalf("run %d, turn %d, worker %d: expected no rows back from a resident strip", r.run, turn, i)
+		}
+		alive += res.AliveCount
+	}
+	r.board = bruteForce(r.board)
+	expected := 0
+	for y := range r.board {
+		for x := range r.board[y] {
+			if r.board[y][x] {
+				expected++
+			}
+		}
+	}
+	if alive != expected {
+		t.Fatalf("run %d, turn %d: expected %d alive cells, got %d", r.run, turn, expected, alive)
+	}
+}
+
+// check fetches the strips back at turn and compares them with the brute-force board.
+func (r *residentBoard) check(t *testing.T, workers []*WorkerService, turn int64) {
+	for i, strip := range r.strips {
+		res := new(WorkerFetchStripResponse)
+		if err := workers[i].FetchStrip(WorkerFetchStripRequest{Run: r.run, Start: strip[0]}, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Turn != turn || res.Region.Start != strip[0] || len(res.Region.Field) != strip[1]-strip[0] {
+			t.Fatalf("run %d, worker %d: expected rows %d to %d at turn %d, got %d rows from %d at turn %d",
+				r.run, i, strip[0], strip[1], turn, len(res.Region.Field), res.Region.Start, res.Turn)
+		}
+		for y, row := range res.Region.Field {
+			for x, cell := range row {
+				if cell.Alive != r.board[strip[0]+y][x] {
+					t.Fatalf("run %d: cell (%d, %d) expected alive=%v", r.run, x, strip[0]+y, r.board[strip[0]+y][x])
+				}
+			}
+		}
+	}
+}
+
+// TestResident keeps two strips of a random board resident on two workers, which swap halo
+// rows over RPC for several turns, and compares the strips fetched back with the brute-force
+// result.
+func TestResident(t *testing.T) {
+	const turns, run = 5, 9
+	workers, addresses := startResidentWorkers(t, 2)
+	r := newResidentBoard(run, 8, 6, 5, [][2]int{{0, 3}, {3, 8}})
+	r.load(t, workers, addresses)
+	for turn := int64(1); turn < turns; turn++ {
+		r.step(t, workers, turn)
+	}
+	r.check(t, workers, turns)
+
+	if err := workers[0].Process(WorkerProcessRequest{Region: Region{Height: 3, Width: 6}, Turn: turns, Run: run + 1, Resident: true}, new(WorkerProcessResponse)); err != ErrNoResidentStrip {
+		t.Errorf("expected ErrNoResidentStrip for another run, got %v", err)
+	}
+}
+
+// TestResidentRunsShareWorkers keeps two runs' boards resident on the same two workers at once,
+// split at different rows, and checks neither disturbs the other's strips until it ends.
+func TestResidentRunsShareWorkers(t *testing.T) {
+	const turns = 5
+	workers, addresses := startResidentWorkers(t, 2)
+	first := newResidentBoard(11, 8, 6, 5, [][2]int{{0, 3}, {3, 8}})
+	second := newResidentBoard(12, 10, 7, 6, [][2]int{{0, 6}, {6, 10}})
+	first.load(t, workers, addresses)
+	second.load(t, workers, addresses)
+	for turn := int64(1); turn < turns; turn++ {
+		first.step(t, workers, turn)
+		second.step(t, workers, turn)
+	}
+	first.check(t, workers, turns)
+	second.check(t, workers, turns)
+
+	for _, worker := range workers {
+		if err := worker.EndRun(WorkerEndRunRequest{Run: first.run}, new(WorkerEndRunResponse)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := workers[0].FetchStrip(WorkerFetchStripRequest{Run: first.run}, new(WorkerFetchStripResponse)); err != ErrNoResidentStrip {
+		t.Errorf("expected the ended run's strip to be dropped, got %v", err)
+	}
+	second.step(t, workers, turns)
+	second.check(t, workers, turns+1)
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
+	"os"
 	"runtime"
 
 	"uk.ac.bris.cs/gameoflife/gol"
 	"uk.ac.bris.cs/gameoflife/sdl"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -38,12 +41,131 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	flag.BoolVar(
+		&params.NoInitialFlips,
+		"no-initial-flips",
+		false,
+		"Skips the CellFlipped events for the initially alive cells, for a faster startup on dense images.")
+
+	flag.BoolVar(
+		&params.ControlLatency,
+		"control-latency",
+		false,
+		"Print how long the broker takes to acknowledge each control keypress. Defaults to false.")
+
+	flag.IntVar(
+		&params.MaxFlipsPerTurn,
+		"max-flips",
+		0,
+		"Send one board refresh instead of CellFlipped events for turns changing more cells than this, 0 for no limit. Defaults to 0.")
+
+	flag.IntVar(
+		&params.BandRows,
+		"band-rows",
+		0,
+		"Fetch the final board from the broker this many rows at a time, for boards too large for one response, 0 to fetch it whole. Defaults to 0.")
+
+	reportMode := flag.String(
+		"report",
+		string(gol.ReportCount),
+		"Specify what is reported every 2s: count (alive cells), snapshot (the whole board), flips (the cells changed since the last report) or turns (every turn's flips as it completes, with the count every 2s).")
+
+	flag.IntVar(
+		&params.SaveEveryReports,
+		"save-every-report",
+		0,
+		"Save the board every n-th time alive cells are reported, 0 to disable. Defaults to 0.")
+
+	flag.IntVar(
+		&params.StartTurn,
+		"start-turn",
+		0,
+		"Specify the turn the input image was saved at, to carry on its turn numbering. Defaults to 0.")
+
+	flag.BoolVar(
+		&params.GzipOutput,
+		"gzip",
+		false,
+		"Save images gzipped as .pgm.gz files. Defaults to false.")
+
+	flag.BoolVar(
+		&params.SaveOnSignal,
+		"save-on-signal",
+		false,
+		"Save the board whenever the process receives SIGUSR1, without stopping the run. Defaults to false.")
+
+	flag.StringVar(
+		&params.AliveOut,
+		"alive-out",
+		"",
+		"Specify a file to write the final turn and its alive cells to as JSON.")
+
+	flag.StringVar(
+		&params.SummaryOut,
+		"summary-out",
+		"",
+		"Specify a file to write the run summary to as JSON.")
+
+	boundary := flag.String(
+		"boundary",
+		string(gol.Toroidal),
+		"Specify what lies beyond the edges of the board: toroidal (wrap around) or fixed (dead cells).")
+
+	rule := flag.String(
+		"rule",
+		gol.Conway.String(),
+		"Specify the rule as birth and survival counts, e.g. B36/S23 for HighLife.")
+
+	flag.StringVar(
+		&params.Pattern,
+		"pattern",
+		"",
+		"Specify an rle (or pgm) pattern file to seed the board with instead of the input image, centred on a board of -w by -h.")
+
+	flag.StringVar(
+		&params.Golden,
+		"golden",
+		"",
+		"Specify a pgm or rle file to compare the final board with, exiting nonzero if they differ.")
+
+	flag.StringVar(
+		&params.BrokerAddr,
+		"broker",
+		"",
+		"Specify the broker's address as host:port. Defaults to $"+gol.BrokerEnv+", or else "+gol.DefaultBrokerAddr+".")
+
+	flag.StringVar(
+		&params.TLSCA,
+		"tls-ca",
+		"",
+		"Specify a CA certificate the broker's certificate must be signed by, to reach it over TLS. Defaults to plain TCP.")
+
 	noVis := flag.Bool(
 		"noVis",
 		false,
 		"Disables the SDL window, so there is no visualisation during the tests.")
+
+	config := flag.String(
+		"config",
+		"",
+		"Specify a JSON file of flag values, e.g. {\"turns\": 100}. Flags given on the command line take precedence.")
 	flag.Parse()
 
+	if *config != "" {
+		if err := util.LoadConfig(flag.CommandLine, *config); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	params.ReportMode = gol.ReportMode(*reportMode)
+	params.Boundary = gol.Boundary(*boundary)
+	parsedRule, err := gol.ParseRule(*rule)
+	if err != nil {
+		log.Fatal(err)
+	}
+	params.Rule = parsedRule
+	params.Interactive = !*noVis
+
 	fmt.Println("Threads:", params.Threads)
 	fmt.Println("Width:", params.ImageWidth)
 	fmt.Println("Height:", params.ImageHeight)
@@ -54,14 +176,38 @@ func main() {
 	go gol.Run(params, events, keyPresses)
 	if !(*noVis) {
 		sdl.Run(params, events, keyPresses)
+		// Wait for the distributor to finish saving and close the events channel.
+		for range events {
+		}
 	} else {
-		complete := false
+		complete, failed := false, false
 		for !complete {
-			event := <-events
+			event, ok := <-events
+			if !ok {
+				// The run never started, and the RunError saying why has been printed.
+				os.Exit(1)
+			}
 			switch event.(type) {
 			case gol.FinalTurnComplete:
 				complete = true
+			case gol.RunSummary:
+				fmt.Println(event)
+			case gol.GoldenMismatch:
+				fmt.Println(event)
+				failed = true
+			case gol.RunError:
+				fmt.Println(event)
+				// With a golden file the run is a check, which an aborted run fails.
+				if params.Golden != "" {
+					failed = true
+				}
+			}
+		}
+		if failed {
+			// Let the final board be saved for inspection before failing.
+			for range events {
 			}
+			os.Exit(1)
 		}
 	}
 }
@@ -15,16 +15,27 @@ sdlLoop:
 		event := w.PollEvent()
 		if event != nil {
 			switch e := event.(type) {
+			case *sdl.QuitEvent:
+				// The window was closed, tell the distributor nobody is listening anymore.
+				w.Destroy()
+				close(keyPresses)
+				break sdlLoop
 			case *sdl.KeyboardEvent:
 				switch e.Keysym.Sym {
 				case sdl.K_p:
 					keyPresses <- 'p'
 				case sdl.K_s:
 					keyPresses <- 's'
+				case sdl.K_i:
+					keyPresses <- 'i'
 				case sdl.K_q:
 					keyPresses <- 'q'
 				case sdl.K_k:
 					keyPresses <- 'k'
+				case sdl.K_n:
+					keyPresses <- 'n'
+				case sdl.K_1, sdl.K_2, sdl.K_3, sdl.K_4:
+					keyPresses <- '1' + rune(e.Keysym.Sym-sdl.K_1)
 				}
 			}
 		}
@@ -37,6 +48,12 @@ sdlLoop:
 			switch e := event.(type) {
 			case gol.CellFlipped:
 				w.FlipPixel(e.Cell.X, e.Cell.Y)
+			case gol.TurnRefresh:
+				w.ClearPixels()
+				for _, cell := range e.Alive {
+					w.SetPixel(cell.X, cell.Y)
+				}
+				w.RenderFrame()
 			case gol.TurnComplete:
 				w.RenderFrame()
 			case gol.FinalTurnComplete:
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LoadConfig reads a JSON object mapping flag names to values from path and sets each of
+// those flags on fs, except for flags already given on the command line, which take
+// precedence. It must be called after fs has been parsed. Lists such as worker addresses
+// are joined with commas.
+func LoadConfig(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("config %v: %v", path, err)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config %v: unknown setting %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		if err := fs.Set(name, configValue(settings[name])); err != nil {
+			return fmt.Errorf("config %v: %v: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// configValue formats a decoded JSON value the way it would be written as a flag.
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, element := range v {
+			values[i] = configValue(element)
+		}
+		return strings.Join(values, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
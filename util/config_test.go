@@ -0,0 +1,65 @@
+package util
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "run.json")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadConfig checks settings are read from the file and that flags given on the
+// command line override them.
+func TestLoadConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	turns := fs.Int("turns", 10000000000, "")
+	width := fs.Int("w", 512, "")
+	height := fs.Int("h", 512, "")
+	gzip := fs.Bool("gzip", false, "")
+	workers := fs.String("workers", "", "")
+	port := fs.String("port", "8030", "")
+	if err := fs.Parse([]string{"-w", "64"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfig(t, `{
+		"turns": 10000000000,
+		"w": 16,
+		"h": 32,
+		"gzip": true,
+		"workers": ["10.0.0.1:8030", "10.0.0.2:8030"]
+	}`)
+	if err := LoadConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if *turns != 10000000000 || *height != 32 || !*gzip {
+		t.Errorf("settings not loaded: turns=%v h=%v gzip=%v", *turns, *height, *gzip)
+	}
+	if *width != 64 {
+		t.Errorf("expected -w 64 on the command line to override the config, got %v", *width)
+	}
+	if *workers != "10.0.0.1:8030,10.0.0.2:8030" {
+		t.Errorf("unexpected workers %q", *workers)
+	}
+	if *port != "8030" {
+		t.Errorf("expected the default port to be left alone, got %q", *port)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, contents := range []string{`{"unknown": 1}`, `{"turns": "many"}`, `{"turns": 1`} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int("turns", 0, "")
+		if err := LoadConfig(fs, writeConfig(t, contents)); err == nil {
+			t.Errorf("expected an error for %v", contents)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WritePgm writes matrix as a binary (P5) pgm image with a maxval of 255.
+func WritePgm(w io.Writer, matrix [][]uint8) error {
+	height := len(matrix)
+	width := 0
+	if height > 0 {
+		width = len(matrix[0])
+	}
+
+	buffered := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(buffered, "P5\n%d %d\n255\n", width, height); err != nil {
+		return err
+	}
+	for _, row := range matrix {
+		if len(row) != width {
+			return errors.New("rows of a pgm image must all have the same width")
+		}
+		if _, err := buffered.Write(row); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+// ReadPgm reads a binary (P5) pgm image with a maxval of 255 into a matrix of rows.
+func ReadPgm(r io.Reader) ([][]uint8, error) {
+	buffered := bufio.NewReader(r)
+
+	var header [4]string
+	for i := range header {
+		token, err := readPgmToken(buffered)
+		if err != nil {
+			return nil, err
+		}
+		header[i] = token
+	}
+
+	if header[0] != "P5" {
+		return nil, errors.New("not a pgm file")
+	}
+	width, err := strconv.Atoi(header[1])
+	if err != nil || width < 0 {
+		return nil, fmt.Errorf("invalid width %q", header[1])
+	}
+	height, err := strconv.Atoi(header[2])
+	if err != nil || height < 0 {
+		return nil, fmt.Errorf("invalid height %q", header[2])
+	}
+	if header[3] != "255" {
+		return nil, errors.New("incorrect maxval/bit depth")
+	}
+
+	matrix := make([][]uint8, height)
+	for y := range matrix {
+		matrix[y] = make([]uint8, width)
+		if _, err := io.ReadFull(buffered, matrix[y]); err != nil {
+			return nil, fmt.Errorf("reading row %d: %v", y, err)
+		}
+	}
+	return matrix, nil
+}
+
+// readPgmToken reads one whitespace separated header token, skipping # comments. The single
+// whitespace character ending the token is consumed, as the pgm format requires.
+func readPgmToken(r *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				return string(token), nil
+			}
+			return "", err
+		}
+		switch {
+		case b == '#' && len(token) == 0:
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, b)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+)
+
+// ServerTLS loads the certificate and key a listener presents to the clients dialing it. With
+// neither file given it returns nil, for plain TCP.
+func ServerTLS(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls: a certificate needs its key, and a key its certificate")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// ClientTLS loads the CA certificates the listeners dialed must present certificates signed
+// by. With no file given it returns nil, for plain TCP.
+func ClientTLS(caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls: no certificates in %v", caFile)
+	}
+	return &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}, nil
+}
+
+// Listen listens on the TCP address addr, over TLS when config is not nil.
+func Listen(addr string, config *tls.Config) (net.Listener, error) {
+	if config == nil {
+		return net.Listen("tcp", addr)
+	}
+	return tls.Listen("tcp", addr, config)
+}
+
+// Dial connects to the TCP address addr, over TLS verified by config when it is not nil.
+func Dial(addr string, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		return net.Dial("tcp", addr)
+	}
+	return tls.Dial("tcp", addr, config)
+}
+
+// DialRPC connects an rpc client to addr, over TLS verified by config when it is not nil.
+func DialRPC(addr string, config *tls.Config) (*rpc.Client, error) {
+	conn, err := Dial(addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
@@ -0,0 +1,142 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1, which is also its own CA, and its
+// key to dir, and returns their paths.
+func writeCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gol test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+type Echo struct{}
+
+func (Echo) Echo(req string, res *string) error {
+	*res = req
+	return nil
+}
+
+// TestDialRPC serves RPCs over TLS and checks a client trusting the server's CA can call them,
+// while a plain TCP client and one trusting another CA cannot.
+func TestDialRPC(t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir())
+	otherCA, _ := writeCert(t, t.TempDir())
+
+	serverConfig, err := ServerTLS(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := Listen("127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	server := rpc.NewServer()
+	if err := server.Register(Echo{}); err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(listener)
+	addr := listener.Addr().String()
+
+	trusted, err := ClientTLS(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := DialRPC(addr, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var echoed string
+	if err := client.Call("Echo.Echo", "hello", &echoed); err != nil || echoed != "hello" {
+		t.Errorf("expected hello echoed over TLS, got %q and %v", echoed, err)
+	}
+	client.Close()
+
+	untrusted, err := ClientTLS(otherCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client, err := DialRPC(addr, untrusted); err == nil {
+		client.Close()
+		t.Error("expected a certificate from another CA to be refused")
+	}
+
+	plain, err := DialRPC(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	calls := make(chan error, 1)
+	go func() { calls <- plain.Call("Echo.Echo", "hello", new(string)) }()
+	select {
+	case err := <-calls:
+		if err == nil {
+			t.Error("expected a plain TCP call to a TLS listener to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("a plain TCP call to a TLS listener hung")
+	}
+}
+
+// TestTLSFiles checks a certificate without its key is refused, and no files means plain TCP.
+func TestTLSFiles(t *testing.T) {
+	certFile, _ := writeCert(t, t.TempDir())
+	if _, err := ServerTLS(certFile, ""); err == nil {
+		t.Error("expected a certificate without its key to be refused")
+	}
+	if config, err := ServerTLS("", ""); config != nil || err != nil {
+		t.Errorf("expected plain TCP with no certificate, got %v and %v", config, err)
+	}
+	if config, err := ClientTLS(""); config != nil || err != nil {
+		t.Errorf("expected plain TCP with no CA, got %v and %v", config, err)
+	}
+	if _, err := ClientTLS(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("expected a missing CA file to be refused")
+	}
+}